@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"io"
 	"reflect"
+	"strconv"
 
+	se "github.com/barnex/se-lang"
 	"github.com/barnex/se-lang/lex"
 )
 
@@ -29,6 +31,7 @@ func (n *Assign) PrintTo(w io.Writer) {
 // Block is a list of statements, e.g.: {a=1; b}
 type Block struct {
 	Stmts []Node
+	cur   int // index of the statement being resolved
 }
 
 func (n *Block) PrintTo(w io.Writer) {
@@ -52,6 +55,21 @@ func (n *Cond) PrintTo(w io.Writer) {
 	n.Else.PrintTo(w)
 }
 
+// Logic is a short-circuiting boolean operator, e.g.: 'a && b', 'a || b'.
+// Y is only evaluated if X does not determine the result.
+type Logic struct {
+	Op   lex.TType // lex.TAnd or lex.TOr
+	X, Y Node
+}
+
+func (n *Logic) PrintTo(w io.Writer) {
+	fmt.Fprint(w, "(")
+	n.X.PrintTo(w)
+	fmt.Fprint(w, n.Op)
+	n.Y.PrintTo(w)
+	fmt.Fprint(w, ")")
+}
+
 // Num is a number Node, e.g.: '1'
 type Num struct {
 	Value string
@@ -61,10 +79,20 @@ func (n *Num) PrintTo(w io.Writer) {
 	fmt.Fprint(w, n.Value)
 }
 
+// Str is a string literal Node, e.g.: '"hello"'
+type Str struct {
+	Value string // unquoted
+}
+
+func (n *Str) PrintTo(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(n.Value))
+}
+
 // Ident is an identifier Node, e.g.: 'sqrt'
 type Ident struct {
 	Name string
 	Var  // filled in later by resolve
+	Pos  se.Position
 }
 
 func (n *Ident) PrintTo(w io.Writer) {
@@ -131,15 +159,20 @@ func (n *Lambda) NewVariable() Var {
 // printList prints a slice whose elements implement Node, e.g.:
 //  []Node, []*Num, []*Ident, []*Call, []*Lambda
 func printList(w io.Writer, list interface{}) {
-	l := reflect.ValueOf(list)
 	fmt.Fprint(w, "(")
+	printElems(w, list)
+	fmt.Fprint(w, ")")
+}
+
+// printElems prints the elements of a slice like printList, without parentheses.
+func printElems(w io.Writer, list interface{}) {
+	l := reflect.ValueOf(list)
 	for i := 0; i < l.Len(); i++ {
 		if i != 0 {
 			fmt.Fprint(w, ", ")
 		}
 		l.Index(i).Interface().(Node).PrintTo(w)
 	}
-	fmt.Fprint(w, ")")
 }
 
 // ToString returns a string representation based on PrintTo
@@ -0,0 +1,103 @@
+package ast
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	se "github.com/barnex/se-lang"
+)
+
+// Diag is a problem found by Resolve.
+// Errors prevent compilation, warnings do not.
+type Diag struct {
+	Pos     se.Position
+	Warning bool
+	Msg     string
+}
+
+func (d Diag) String() string {
+	var kind string
+	if d.Warning {
+		kind = "warning: "
+	}
+	if d.Pos.IsValid() {
+		return fmt.Sprintf("%v: %v%v", d.Pos, kind, d.Msg)
+	}
+	return kind + d.Msg
+}
+
+// Errors returns the non-warning diagnostics as a single se.Error,
+// or nil if there are none.
+func Errors(diags []Diag) error {
+	var msg []string
+	for _, d := range diags {
+		if !d.Warning {
+			msg = append(msg, d.String())
+		}
+	}
+	if len(msg) == 0 {
+		return nil
+	}
+	return se.Errorf("%v", strings.Join(msg, "\n"))
+}
+
+// sortDiags orders diagnostics by position.
+func sortDiags(diags []Diag) {
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i].Pos, diags[j].Pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+}
+
+// suggest returns the name closest to name,
+// or "" if none is close enough to be a likely typo.
+func suggest(name string, names []string) string {
+	best, bestDist := "", (len(name)+1)/3
+	for _, n := range names {
+		if n == name {
+			continue
+		}
+		if d := editDistance(name, n); d <= bestDist && (best == "" || d < bestDist) {
+			best, bestDist = n, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min3(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,26 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+)
+
+// FuzzParse parses and prints arbitrary programs,
+// checking that failures are reported as errors rather than panics:
+//
+//	go test ./ast -run FuzzParse -fuzz FuzzParse
+func FuzzParse(f *testing.F) {
+	for _, c := range parseTests() {
+		f.Add(c.in)
+	}
+	for _, src := range parseErrors {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		n, err := ParseProgram(strings.NewReader(src))
+		if err != nil {
+			return
+		}
+		ToString(n)
+	})
+}
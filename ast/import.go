@@ -0,0 +1,45 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/barnex/se-lang/lex"
+)
+
+// Import binds the top-level definitions of another source file, e.g.:
+// 	import "lib/prime.howl"
+// 	import p "lib/prime.howl"
+// The first form binds the module to the file's base name, 'prime'.
+type Import struct {
+	Name   *Ident // binds the module, Var filled in by resolve
+	Path   string
+	Module interface{} // filled in by the loader that compiles the program
+}
+
+func (n *Import) PrintTo(w io.Writer) {
+	fmt.Fprint(w, lex.TImport, " ")
+	n.Name.PrintTo(w)
+	fmt.Fprint(w, " ", strconv.Quote(n.Path))
+}
+
+// ModuleName returns the default name for a module imported from path, e.g.:
+// 	lib/prime.howl -> prime
+func ModuleName(p string) string {
+	base := path.Base(p)
+	return strings.TrimSuffix(base, path.Ext(base))
+}
+
+// Select is a qualified identifier, e.g.: 'prime.isPrime'
+type Select struct {
+	X   Node
+	Sel string
+}
+
+func (n *Select) PrintTo(w io.Writer) {
+	n.X.PrintTo(w)
+	fmt.Fprint(w, lex.TDot, n.Sel)
+}
@@ -0,0 +1,47 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/barnex/se-lang/lex"
+)
+
+// List is a list expression, e.g.:
+// 	[1, 2, 3]
+// 	[x, y | rest]  // x and y prepended to list rest
+type List struct {
+	Elems []Node
+	Tail  Node // optional
+}
+
+func (n *List) PrintTo(w io.Writer) {
+	fmt.Fprint(w, lex.TLBrack)
+	printElems(w, n.Elems)
+	if n.Tail != nil {
+		fmt.Fprint(w, lex.TBar)
+		n.Tail.PrintTo(w)
+	}
+	fmt.Fprint(w, lex.TRBrack)
+}
+
+// ListPat is a pattern that matches lists, e.g.:
+// 	[]         // the empty list
+// 	[x, 0]     // a list of two elements
+// 	[x | rest] // a list of at least one element
+type ListPat struct {
+	Elems []Pattern
+	Tail  Pattern // optional, matches the remaining elements
+}
+
+func (*ListPat) pattern() {}
+
+func (n *ListPat) PrintTo(w io.Writer) {
+	fmt.Fprint(w, lex.TLBrack)
+	printElems(w, n.Elems)
+	if n.Tail != nil {
+		fmt.Fprint(w, lex.TBar)
+		n.Tail.PrintTo(w)
+	}
+	fmt.Fprint(w, lex.TRBrack)
+}
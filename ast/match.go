@@ -0,0 +1,305 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"sort"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/lex"
+)
+
+// Match is a pattern matching expression, e.g.:
+// 	match x { 0 -> 1; n if n < 0 -> -n; _ -> x }
+// Multiple values can be matched against tuple patterns at once, e.g.:
+// 	match x, y { (0, _) -> y; (_, 0) -> x; _ -> 0 }
+type Match struct {
+	X     []Node
+	Cases []*Case
+	Tmp   []Var // filled in by resolve: storage for the values of X
+	Pos   se.Position
+}
+
+func (n *Match) PrintTo(w io.Writer) {
+	fmt.Fprint(w, lex.TMatch, " ")
+	for i, x := range n.X {
+		if i != 0 {
+			fmt.Fprint(w, lex.TComma)
+		}
+		x.PrintTo(w)
+	}
+	fmt.Fprint(w, lex.TLBrace)
+	for i, c := range n.Cases {
+		if i != 0 {
+			fmt.Fprint(w, lex.TSemicol)
+		}
+		c.PrintTo(w)
+	}
+	fmt.Fprint(w, lex.TRBrace)
+}
+
+// Case is a single case of a Match, e.g.:
+// 	n if n < 0 -> -n
+type Case struct {
+	Pat   Pattern
+	Guard Node // optional
+	Body  Node
+}
+
+func (n *Case) PrintTo(w io.Writer) {
+	n.Pat.PrintTo(w)
+	if n.Guard != nil {
+		fmt.Fprint(w, " ", lex.TIf, " ")
+		n.Guard.PrintTo(w)
+	}
+	fmt.Fprint(w, lex.TLambda)
+	n.Body.PrintTo(w)
+}
+
+// Find returns the variable bound by the case's pattern, if any.
+func (n *Case) Find(name string) Var {
+	var v Var
+	walkPattern(n.Pat, func(p Pattern) {
+		if id, ok := p.(*Ident); ok && id.Name == name && v == nil {
+			v = id.Var
+		}
+	})
+	return v
+}
+
+// A Pattern is matched against a value in a match case. One of:
+// 	*Wildcard, *Lit, *Ident, *Tuple, *CtorPat, *ListPat, *RecordPat
+type Pattern interface {
+	Node
+	pattern()
+}
+
+func (*Wildcard) pattern() {}
+func (*Lit) pattern()      {}
+func (*Ident) pattern()    {}
+func (*Tuple) pattern()    {}
+
+// Wildcard is a pattern that matches anything, e.g.: '_'
+type Wildcard struct{}
+
+func (n *Wildcard) PrintTo(w io.Writer) {
+	fmt.Fprint(w, "_")
+}
+
+// Lit is a literal pattern, e.g.: '1', '-1', 'true'
+type Lit struct {
+	Value string
+}
+
+func (n *Lit) PrintTo(w io.Writer) {
+	fmt.Fprint(w, n.Value)
+}
+
+// Tuple is a pattern that matches multiple values, e.g.: '(0, x)'
+type Tuple struct {
+	Elems []Pattern
+}
+
+func (n *Tuple) PrintTo(w io.Writer) {
+	printList(w, n.Elems)
+}
+
+// walkPattern calls f for p and all of its sub-patterns.
+func walkPattern(p Pattern, f func(Pattern)) {
+	f(p)
+	switch p := p.(type) {
+	case *Tuple:
+		for _, e := range p.Elems {
+			walkPattern(e, f)
+		}
+	case *CtorPat:
+		for _, a := range p.Args {
+			walkPattern(a, f)
+		}
+	case *ListPat:
+		for _, e := range p.Elems {
+			walkPattern(e, f)
+		}
+		if p.Tail != nil {
+			walkPattern(p.Tail, f)
+		}
+	case *RecordPat:
+		for _, x := range p.Fields {
+			walkPattern(x, f)
+		}
+	}
+}
+
+// Exhaustive returns true if the cases of n match every possible value.
+// Guarded cases are not taken into account.
+func (n *Match) Exhaustive() bool {
+	var rows [][]Pattern
+	for _, c := range n.Cases {
+		if c.Guard != nil {
+			continue
+		}
+		rows = append(rows, columns(c.Pat, len(n.X)))
+	}
+	return exhaustive(rows)
+}
+
+// fits returns true if p can be matched against the values of n:
+// a tuple with one element per value, or any pattern for a single value.
+// '_' matches any number of values.
+func (n *Match) fits(p Pattern) bool {
+	switch p := p.(type) {
+	case *Tuple:
+		return len(p.Elems) == len(n.X)
+	case *Wildcard:
+		return true
+	default:
+		return len(n.X) == 1
+	}
+}
+
+// columns splits a case pattern into one pattern per matched value.
+// The pattern must fit, see fits.
+func columns(p Pattern, n int) []Pattern {
+	if t, ok := p.(*Tuple); ok {
+		return t.Elems
+	}
+	if n == 1 {
+		return []Pattern{p}
+	}
+	cols := make([]Pattern, n)
+	for i := range cols {
+		cols[i] = &Wildcard{}
+	}
+	return cols
+}
+
+// exhaustive returns true if the pattern matrix rows matches every vector of values.
+// The first column is split by the literals or constructors it contains.
+// Booleans, constructors of a sum type, and empty and non-empty list patterns
+// can cover all values by themselves, other literals need an irrefutable pattern next to them.
+// Record patterns are taken to match records with all fields named in the column.
+func exhaustive(rows [][]Pattern) bool {
+	if len(rows) == 0 {
+		return false
+	}
+	if len(rows[0]) == 0 {
+		return true
+	}
+
+	if heads := signature(rows); heads != nil {
+		for _, h := range heads {
+			if !exhaustive(specialize(rows, h)) {
+				return false
+			}
+		}
+		return true
+	}
+	return exhaustive(specialize(rows, head{}))
+}
+
+// head is the outermost literal or constructor of a pattern.
+// Lists are made of the heads "[]" and "[|]" (element and tail),
+// records of the head "{}" with one sub-pattern per field.
+type head struct {
+	name   string
+	arity  int
+	fields []string // of a record
+}
+
+// signature returns all heads a value can have, based on the first column of rows.
+// It returns nil if the first column does not determine a finite set of heads.
+func signature(rows [][]Pattern) []head {
+	var bools int
+	for _, r := range rows {
+		switch p := r[0].(type) {
+		case *CtorPat:
+			var heads []head
+			for _, c := range p.Ctor.Type.Ctors {
+				heads = append(heads, head{name: c.Name.Name, arity: len(c.Fields)})
+			}
+			return heads
+		case *ListPat:
+			return []head{{name: "[]"}, {name: "[|]", arity: 2}}
+		case *RecordPat:
+			fields := recordFields(rows)
+			return []head{{name: "{}", arity: len(fields), fields: fields}}
+		case *Lit:
+			if p.Value == "true" || p.Value == "false" {
+				bools++
+			}
+		}
+	}
+	if bools > 0 {
+		return []head{{name: "true"}, {name: "false"}}
+	}
+	return nil
+}
+
+// recordFields returns the sorted names of the fields
+// matched by the record patterns in the first column of rows.
+func recordFields(rows [][]Pattern) []string {
+	var fields []string
+	seen := make(map[string]bool)
+	for _, r := range rows {
+		if p, ok := r[0].(*RecordPat); ok {
+			for _, name := range p.Names {
+				if !seen[name] {
+					seen[name] = true
+					fields = append(fields, name)
+				}
+			}
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// specialize returns the rows that match head h in the first column,
+// with that column replaced by the sub-patterns of h.
+// The zero head selects only irrefutable patterns.
+func specialize(rows [][]Pattern, h head) [][]Pattern {
+	var spec [][]Pattern
+	for _, r := range rows {
+		switch p := r[0].(type) {
+		case *Lit:
+			if p.Value == h.name {
+				spec = append(spec, r[1:])
+			}
+		case *CtorPat:
+			if p.Name == h.name {
+				spec = append(spec, append(append([]Pattern{}, p.Args...), r[1:]...))
+			}
+		case *ListPat:
+			switch {
+			case h.name == "[]" && len(p.Elems) == 0:
+				spec = append(spec, r[1:])
+			case h.name == "[|]" && len(p.Elems) > 0:
+				var tail Pattern = &ListPat{Elems: p.Elems[1:], Tail: p.Tail}
+				if len(p.Elems) == 1 && p.Tail != nil {
+					tail = p.Tail
+				}
+				spec = append(spec, append([]Pattern{p.Elems[0], tail}, r[1:]...))
+			}
+		case *RecordPat:
+			if h.name == "{}" {
+				args := make([]Pattern, len(h.fields), len(h.fields)+len(r)-1)
+				for i, name := range h.fields {
+					args[i] = &Wildcard{}
+					for j, n := range p.Names {
+						if n == name {
+							args[i] = p.Fields[j]
+						}
+					}
+				}
+				spec = append(spec, append(args, r[1:]...))
+			}
+		default:
+			wild := make([]Pattern, h.arity, h.arity+len(r)-1)
+			for i := range wild {
+				wild[i] = &Wildcard{}
+			}
+			spec = append(spec, append(wild, r[1:]...))
+		}
+	}
+	return spec
+}
@@ -0,0 +1,87 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExhaustive(t *testing.T) {
+	cases := []struct {
+		src  string
+		want bool
+	}{
+		{`match x {_->1}`, true},
+		{`match x {y->1}`, true},
+		{`match x {1->1}`, false},
+		{`match x {1->1; _->2}`, true},
+		{`match x {y if y>1->1}`, false},
+		{`match x {y if y>1->1; _->2}`, true},
+		{`match x {true->1}`, false},
+		{`match x {true->1; false->2}`, true},
+		{`match x, y {(0, _)->1}`, false},
+		{`match x, y {(0, _)->1; (_, _)->2}`, true},
+		{`match x, y {(0, _)->1; _->2}`, true},
+		{`match x, y {(true, 0)->1; (false, _)->2}`, false},
+		{`match x, y {(true, 0)->1; (false, _)->2; (true, _)->3}`, true},
+		{`match x, y {(true, true)->1; (false, _)->2; (_, false)->3}`, true},
+		{`match x, y {(true, true)->1; (_, false)->3}`, false},
+		{`match x {[]->1}`, false},
+		{`match x {[]->1; [_|_]->2}`, true},
+		{`match x {[]->1; [_]->2}`, false},
+		{`match x {[]->1; [_]->2; [_, _|_]->3}`, true},
+		{`match x {[y|[]]->1; [_, _|_]->2; []->3}`, true},
+		{`match x {[true|_]->1; [false]->2; []->3}`, false},
+		{`match x {[true|_]->1; [false|_]->2; []->3}`, true},
+		{`match x {{a: 0}->1}`, false},
+		{`match x {{a: 0}->1; {a: _}->2}`, true},
+		{`match x {{a: true}->1; {b: 0, a: false}->2}`, false},
+		{`match x {{a: true}->1; {b: _, a: false}->2}`, true},
+		{`match x, y {([], _)->1; (_, [])->2; ([_|_], [_|_])->3}`, true},
+	}
+
+	for _, c := range cases {
+		n, err := parse(c.src)
+		if err != nil {
+			t.Errorf("%v: error: %v", c.src, err)
+			continue
+		}
+		if have := n.(*Match).Exhaustive(); have != c.want {
+			t.Errorf("%v: exhaustive: have %v, want %v", c.src, have, c.want)
+		}
+	}
+}
+
+func TestExhaustiveCtor(t *testing.T) {
+	cases := []struct {
+		src  string
+		want bool
+	}{
+		{`type T = A | B(x); match y {A->1; B(_)->2}`, true},
+		{`type T = A | B(x); match y {A->1}`, false},
+		{`type T = A | B(x); match y {B(_)->1}`, false},
+		{`type T = A | B(x); match y {B(1)->1; A->2}`, false},
+		{`type T = A | B(x); match y {B(1)->1; _->2}`, true},
+		{`type T = A | B(x); match y {B(true)->1; B(false)->2; A->3}`, true},
+		{`type L = Nil | Cons(h, t); match y {Nil->1; Cons(_, Nil)->2}`, false},
+		{`type L = Nil | Cons(h, t); match y {Nil->1; Cons(_, Nil)->2; Cons(_, Cons(_, _))->3}`, true},
+		{`type L = Nil | Cons(h, t); match y, z {(Nil, _)->1; (_, Nil)->2}`, false},
+		{`type L = Nil | Cons(h, t); match y, z {(Nil, _)->1; (_, Nil)->2; (Cons(_, _), Cons(_, _))->3}`, true},
+	}
+
+	for _, c := range cases {
+		n, err := ParseProgram(strings.NewReader(c.src))
+		if err != nil {
+			t.Errorf("%v: error: %v", c.src, err)
+			continue
+		}
+		exhaustive := true
+		for _, d := range Resolve(&Lambda{Body: n, Args: args(ident("y"), ident("z"))}) {
+			if d.Msg == "match is not exhaustive" {
+				exhaustive = false
+			}
+		}
+		if exhaustive != c.want {
+			t.Errorf("%v: exhaustive: have %v, want %v", c.src, exhaustive, c.want)
+		}
+	}
+}
@@ -7,6 +7,9 @@ package ast
 import (
 	"fmt"
 	"io"
+	"strconv"
+	"unicode"
+	"unicode/utf8"
 
 	se "github.com/barnex/se-lang"
 	"github.com/barnex/se-lang/lex"
@@ -42,11 +45,33 @@ func ParseProgram(src io.Reader) (_ *Block, e error) {
 			e = err
 		}
 	}()
-	p := &parser{lex: *lex.NewLexer(src)}
+	return parseProgram(lex.NewLexer(src)), nil
+}
+
+// ParseFile is like ParseProgram,
+// but reports positions in the named file.
+func ParseFile(filename string, src io.Reader) (_ *Block, e error) {
+	defer func() {
+		switch err := recover().(type) {
+		default:
+			panic(err) // resume
+		case nil:
+			// no error
+		case se.Error:
+			e = err
+		}
+	}()
+	l := lex.NewLexer(src)
+	l.SetFilename(filename)
+	return parseProgram(l), nil
+}
+
+func parseProgram(l *lex.Lexer) *Block {
+	p := &parser{lex: *l}
 	p.init()
-	b := &Block{p.parseInnerBlock()}
+	b := &Block{Stmts: p.parseInnerBlock()}
 	p.Expect(lex.TEOF)
-	return b, nil
+	return b
 }
 
 // The syntax is LL(4),
@@ -56,8 +81,11 @@ func ParseProgram(src io.Reader) (_ *Block, e error) {
 const readAhead = 4
 
 type parser struct {
-	lex  lex.Lexer
-	next [readAhead]lex.Token
+	lex    lex.Lexer
+	next   [readAhead]lex.Token
+	pos    [readAhead]se.Position // positions of next tokens
+	ops    map[string]fixity      // operators declared by the program
+	blocks int                    // nesting depth of blocks
 }
 
 func (p *parser) parse() Node {
@@ -72,6 +100,7 @@ func (p *parser) parse() Node {
 //  | lambda
 //  | block
 //  | cond
+//  | match
 func (p *parser) parseExpr() Node {
 	// peek for lambda: "()" or "(ident," or "ident->" or "(ident)->"
 	if p.HasPeek(lex.TLParen, lex.TRParen) ||
@@ -81,10 +110,15 @@ func (p *parser) parseExpr() Node {
 		return p.parseLambda()
 	}
 
-	if p.HasPeek(lex.TLBrace) {
+	// "{", but not a record: "{ident:"
+	if p.HasPeek(lex.TLBrace) && !p.HasPeek(lex.TLBrace, lex.TIdent, lex.TColon) {
 		return p.parseBlock()
 	}
 
+	if p.HasPeek(lex.TMatch) {
+		return p.parseMatch()
+	}
+
 	e := p.parseExpr1()
 	if p.Accept(lex.TQuestion) {
 		a := p.parseExpr()
@@ -100,26 +134,46 @@ func (p *parser) parseExpr() Node {
 //  | { stmt; ... }
 func (p *parser) parseBlock() Node {
 	p.Expect(lex.TLBrace)
+	p.blocks++
 	stmt := p.parseInnerBlock()
+	p.blocks--
 	p.Expect(lex.TRBrace)
-	return &Block{stmt}
+	return &Block{Stmts: stmt}
 }
 
+// parse statements separated by semicolons.
+// Fixity declarations only change the operator table,
+// they are not part of the returned statements.
 func (p *parser) parseInnerBlock() []Node {
-	stmt := []Node{p.parseStmt()}
-	for p.Accept(lex.TSemicol) {
-		stmt = append(stmt, p.parseStmt())
+	var stmt []Node
+	for {
+		if s := p.parseStmt(); s != nil {
+			stmt = append(stmt, s)
+		}
+		if !p.Accept(lex.TSemicol) {
+			return stmt
+		}
 	}
-	return stmt
 }
 
 // stmt:
 // 	| expr
 //  | assign
+//  | typedef
+//  | import
+//  | fixity
 func (p *parser) parseStmt() Node {
-	if p.HasPeek(lex.TIdent, lex.TAssign) {
+	switch {
+	case p.HasPeek(lex.TInfixl), p.HasPeek(lex.TInfixr):
+		p.parseFixity()
+		return nil
+	case p.HasPeek(lex.TIdent, lex.TAssign):
 		return p.parseAssign()
-	} else {
+	case p.HasPeek(lex.TTypedef):
+		return p.parseTypeDef()
+	case p.HasPeek(lex.TImport):
+		return p.parseImport()
+	default:
 		return p.parseExpr()
 	}
 }
@@ -133,6 +187,141 @@ func (p *parser) parseAssign() Node {
 	return &Assign{lhs, rhs}
 }
 
+// import:
+//  | import string
+//  | import ident string
+func (p *parser) parseImport() Node {
+	p.Expect(lex.TImport)
+	pos := p.Pos()
+
+	var name *Ident
+	if p.HasPeek(lex.TIdent) {
+		name = p.parseIdent()
+	}
+
+	path, err := strconv.Unquote(p.Expect(lex.TString).Value)
+	if err != nil {
+		panic(p.SyntaxError(err.Error()))
+	}
+
+	if name == nil {
+		name = &Ident{Name: ModuleName(path), Pos: pos}
+		if !isIdent(name.Name) {
+			panic(p.SyntaxError(fmt.Sprintf("import %q: %q is not a valid name, use: import name %q", path, name.Name, path)))
+		}
+	}
+	return &Import{Name: name, Path: path}
+}
+
+// fixity:
+//  | infixl num op = ident
+//  | infixr num op = ident
+//  | infixl num `ident`
+//  | infixr num `ident`
+// declares an operator for the rest of the program.
+func (p *parser) parseFixity() {
+	if p.blocks > 0 {
+		panic(p.SyntaxError(fmt.Sprintf("%v is only allowed at the top level", p.Peek())))
+	}
+	right := p.Next().TType == lex.TInfixr
+
+	prec, err := strconv.Atoi(p.Peek().Value)
+	if p.PeekTT() != lex.TNum || err != nil || prec < 1 || prec > maxPrec {
+		panic(p.SyntaxError(fmt.Sprintf("unexpected '%v', expected precedence 1 to %v", p.Peek(), maxPrec)))
+	}
+	p.Next()
+
+	pos := p.Pos()
+	op := p.Peek()
+	if _, ok := operators[op.Value]; ok {
+		panic(p.SyntaxError(fmt.Sprintf("cannot redefine built-in operator %v", op)))
+	}
+	p.Expect(lex.TOp)
+
+	f := fixity{prec: prec, right: right}
+	if name, ok := backquoted(op.Value); ok {
+		f.fn = name
+	} else {
+		p.Expect(lex.TAssign)
+		f.fn = p.parseIdent().Name
+	}
+
+	if p.mixesAssoc(op.Value, f) {
+		panic(se.Errorf("%v: %v: cannot mix left- and right-associative operators of precedence %v", pos, op, prec))
+	}
+	if p.ops == nil {
+		p.ops = make(map[string]fixity)
+	}
+	p.ops[op.Value] = f
+}
+
+// mixesAssoc returns true if another operator of the same precedence as fixity f,
+// declared for op, has a different associativity.
+// Such operators could not be chained without parentheses.
+func (p *parser) mixesAssoc(op string, f fixity) bool {
+	if f.prec == backquotePrec && f.right {
+		return true // backquoted functions are left-associative by default
+	}
+	for _, table := range []map[string]fixity{operators, p.ops} {
+		for o, g := range table {
+			if o != op && g.prec == f.prec && g.right != f.right {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func isIdent(name string) bool {
+	for i, r := range name {
+		if !(unicode.IsLetter(r) || r == '_' || i > 0 && unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// typedef:
+//  | type ident = ctor | ctor ...
+func (p *parser) parseTypeDef() Node {
+	p.Expect(lex.TTypedef)
+	t := &TypeDef{Name: p.Expect(lex.TIdent).Value}
+	p.Expect(lex.TAssign)
+	t.Ctors = []*Ctor{p.parseCtor(t)}
+	for p.Accept(lex.TBar) {
+		t.Ctors = append(t.Ctors, p.parseCtor(t))
+	}
+	return t
+}
+
+// ctor:
+//  | Ident
+//  | Ident(ident, ...)
+func (p *parser) parseCtor(t *TypeDef) *Ctor {
+	c := &Ctor{Name: p.parseCtorName(), Type: t}
+	if p.HasPeek(lex.TLParen) {
+		for _, f := range p.parseIdentList() {
+			c.Fields = append(c.Fields, f.Name)
+		}
+	}
+	return c
+}
+
+// parse a constructor name, which must be capitalized
+// to distinguish it from a variable in a pattern.
+func (p *parser) parseCtorName() *Ident {
+	id := p.parseIdent()
+	if !isCtorName(id.Name) {
+		panic(p.SyntaxError(fmt.Sprintf("constructor %v must start with an upper case letter", id.Name)))
+	}
+	return id
+}
+
+func isCtorName(name string) bool {
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
 // lambda:
 //  | ident -> expr1
 //  | () -> expr1
@@ -154,6 +343,158 @@ func (p *parser) parseLambda() Node {
 	return &Lambda{Args: args, Body: body}
 }
 
+// match:
+//  | match expr1 { case; ... }
+//  | match expr1, expr1, ... { case; ... }
+func (p *parser) parseMatch() Node {
+	pos := p.Pos()
+	p.Expect(lex.TMatch)
+
+	x := []Node{p.parseExpr1()}
+	for p.Accept(lex.TComma) {
+		x = append(x, p.parseExpr1())
+	}
+
+	p.Expect(lex.TLBrace)
+	cases := []*Case{p.parseCase()}
+	for p.Accept(lex.TSemicol) {
+		cases = append(cases, p.parseCase())
+	}
+	p.Expect(lex.TRBrace)
+	return &Match{X: x, Cases: cases, Pos: pos}
+}
+
+// case:
+//  | pattern -> expr
+//  | pattern if expr1 -> expr
+func (p *parser) parseCase() *Case {
+	var pat Pattern
+	if p.HasPeek(lex.TLParen) {
+		pat = p.parseTuple()
+	} else {
+		pat = p.parsePattern()
+	}
+
+	var guard Node
+	if p.Accept(lex.TIf) {
+		guard = p.parseExpr1()
+	}
+
+	p.Expect(lex.TLambda)
+	body := p.parseExpr()
+	return &Case{Pat: pat, Guard: guard, Body: body}
+}
+
+// tuple:
+//  | (pattern, ...)
+func (p *parser) parseTuple() Pattern {
+	p.Expect(lex.TLParen)
+	elems := []Pattern{p.parsePattern()}
+	for p.Accept(lex.TComma) {
+		elems = append(elems, p.parsePattern())
+	}
+	p.Expect(lex.TRParen)
+	return &Tuple{elems}
+}
+
+// pattern:
+//  | _
+//  | num
+//  | - num
+//  | true
+//  | false
+//  | ident
+//  | Ident
+//  | Ident(pattern, ...)
+//  | listpat
+//  | recordpat
+func (p *parser) parsePattern() Pattern {
+	if p.HasPeek(lex.TLBrack) {
+		return p.parseListPat()
+	}
+
+	if p.HasPeek(lex.TLBrace) {
+		return p.parseRecordPat()
+	}
+
+	if p.Accept(lex.TMinus) {
+		return &Lit{"-" + p.Expect(lex.TNum).Value}
+	}
+
+	if p.HasPeek(lex.TIdent) && isCtorName(p.Peek().Value) {
+		return p.parseCtorPat()
+	}
+
+	pos := p.Pos()
+	if tt := p.PeekTT(); tt != lex.TNum && tt != lex.TIdent {
+		panic(p.Unexpected(p.Peek()))
+	}
+	switch tok := p.Next(); {
+	case tok.TType == lex.TNum:
+		return &Lit{tok.Value}
+	case tok.Value == "_":
+		return &Wildcard{}
+	case tok.Value == "true" || tok.Value == "false":
+		return &Lit{tok.Value}
+	default:
+		return &Ident{Name: tok.Value, Pos: pos}
+	}
+}
+
+// ctorpat:
+//  | Ident
+//  | Ident(pattern, ...)
+func (p *parser) parseCtorPat() Pattern {
+	id := p.parseCtorName()
+	c := &CtorPat{Name: id.Name, Pos: id.Pos}
+	if p.Accept(lex.TLParen) {
+		c.Args = []Pattern{p.parsePattern()}
+		for p.Accept(lex.TComma) {
+			c.Args = append(c.Args, p.parsePattern())
+		}
+		p.Expect(lex.TRParen)
+	}
+	return c
+}
+
+// listpat:
+//  | []
+//  | [pattern, ...]
+//  | [pattern, ... | pattern]
+func (p *parser) parseListPat() Pattern {
+	p.Expect(lex.TLBrack)
+	l := &ListPat{}
+	if p.Accept(lex.TRBrack) {
+		return l
+	}
+	l.Elems = []Pattern{p.parsePattern()}
+	for p.Accept(lex.TComma) {
+		l.Elems = append(l.Elems, p.parsePattern())
+	}
+	if p.Accept(lex.TBar) {
+		l.Tail = p.parsePattern()
+	}
+	p.Expect(lex.TRBrack)
+	return l
+}
+
+// recordpat:
+//  | {ident: pattern, ...}
+func (p *parser) parseRecordPat() Pattern {
+	r := &RecordPat{Pos: p.Pos()}
+	p.Expect(lex.TLBrace)
+	for {
+		r.Names = append(r.Names, p.parseIdent().Name)
+		p.Expect(lex.TColon)
+		r.Fields = append(r.Fields, p.parsePattern())
+		if !p.Accept(lex.TComma) {
+			break
+		}
+	}
+	p.Expect(lex.TRBrace)
+	return r
+}
+
 // identlist:
 //  | ()
 //  | (ident,...)
@@ -187,55 +528,124 @@ func (p *parser) parseExpr1() Node {
 // inspired by https://github.com/adonovan/gopl.io/blob/master/ch7/eval/parse.go
 func (p *parser) parseBinaryExpr(prec1 int) Node {
 	lhs := p.parseOperand()
-	for prec := precedence[p.Peek().TType]; prec >= prec1; prec-- {
-		for precedence[p.Peek().TType] == prec {
-			op := p.Next()
-			rhs := p.parseBinaryExpr(prec + 1)
-			lhs = &Call{&Ident{Name: opFunc(op.TType)}, []Node{lhs, rhs}}
+	for prec := p.infix(p.Peek()).prec; prec >= prec1; prec-- {
+		for p.infix(p.Peek()).prec == prec {
+			pos := p.Pos()
+			tok := p.Next()
+			op := p.infix(tok)
+			next := prec + 1
+			if op.right {
+				next = prec
+			}
+			rhs := p.parseBinaryExpr(next)
+			if op.fn == "" {
+				lhs = &Logic{tok.TType, lhs, rhs}
+			} else {
+				lhs = &Call{&Ident{Name: op.fn, Pos: pos}, []Node{lhs, rhs}}
+			}
 		}
 	}
 	return lhs
 }
 
+// infix returns the fixity of binary operator t,
+// or zero precedence if t is not a binary operator.
+func (p *parser) infix(t lex.Token) fixity {
+	switch t.TType {
+	default:
+		return fixity{}
+	case lex.TOp:
+		if f, ok := p.ops[t.Value]; ok {
+			return f
+		}
+		if f, ok := operators[t.Value]; ok {
+			return f
+		}
+		if name, ok := backquoted(t.Value); ok {
+			return fixity{prec: backquotePrec, fn: name}
+		}
+		panic(p.SyntaxError(fmt.Sprintf("undefined operator %v", t.Value)))
+	case lex.TCompose, lex.TComposeL, lex.TMul, lex.TDiv, lex.TMod, lex.TAdd, lex.TMinus,
+		lex.TEq, lex.TGe, lex.TGt, lex.TLe, lex.TLt, lex.TNEq, lex.TAnd, lex.TOr, lex.TPipe:
+		return operators[t.Value]
+	}
+}
+
+// backquoted returns the function name of a backquoted operator, like `max`.
+func backquoted(op string) (string, bool) {
+	if len(op) > 2 && op[0] == '`' && op[len(op)-1] == '`' {
+		return op[1 : len(op)-1], true
+	}
+	return "", false
+}
+
 // parse an operand:
 // operand:
 //  | - operand
 //  | num
+//  | string
 //  | ident
 //  | parenexpr
-//  | operand *(list)
+//  | list
+//  | record
+//  | operand *(arglist)
+//  | operand.ident
 func (p *parser) parseOperand() Node {
 
+	pos := p.Pos()
+
 	// - operand
 	if p.Accept(lex.TMinus) {
-		return &Call{&Ident{Name: "neg"}, []Node{p.parseOperand()}}
+		return &Call{&Ident{Name: "neg", Pos: pos}, []Node{p.parseOperand()}}
 	}
 
 	// !operand
 	if p.Accept(lex.TNot) {
-		return &Call{&Ident{Name: "not"}, []Node{p.parseOperand()}}
+		return &Call{&Ident{Name: "not", Pos: pos}, []Node{p.parseOperand()}}
 	}
 
-	// num, ident, parenexpr
+	// num, string, ident, parenexpr, list, record
 	var expr Node
 	switch p.PeekTT() {
 	case lex.TNum:
 		expr = p.parseNum()
+	case lex.TString:
+		expr = p.parseStr()
 	case lex.TIdent:
 		expr = p.parseIdent()
 	case lex.TLParen:
 		expr = p.parseParenExpr()
+	case lex.TLBrack:
+		expr = p.parseList()
+	case lex.TLBrace:
+		expr = p.parseRecord()
 	default:
-		panic(p.Unexpected(p.Next()))
+		panic(p.Unexpected(p.Peek()))
 	}
 
-	// operand *(list): function call
-	for p.PeekTT() == lex.TLParen {
-		args := p.parseArgList()
-		expr = &Call{expr, args}
+	// operand *(arglist): function call
+	// operand.ident: qualified identifier
+	for {
+		switch p.PeekTT() {
+		case lex.TLParen:
+			args := p.parseArgList()
+			expr = &Call{expr, args}
+		case lex.TDot:
+			p.Next()
+			expr = &Select{expr, p.parseIdent().Name}
+		default:
+			return expr
+		}
 	}
+}
 
-	return expr
+// parse a quoted string.
+func (p *parser) parseStr() Node {
+	s, err := strconv.Unquote(p.Expect(lex.TString).Value)
+	if err != nil {
+		panic(p.SyntaxError(err.Error()))
+	}
+	return &Str{s}
 }
 
 // parse a number.
@@ -250,8 +660,9 @@ func (p *parser) parseNum() Node {
 
 // parse an identifier
 func (p *parser) parseIdent() *Ident {
+	pos := p.Pos()
 	tok := p.Expect(lex.TIdent)
-	return &Ident{Name: tok.Value}
+	return &Ident{Name: tok.Value, Pos: pos}
 }
 
 // parse a parenthesized argument list:
@@ -275,6 +686,46 @@ func (p *parser) parseArgList() []Node {
 	return list
 }
 
+// parse a list:
+//  list:
+//   | []
+//   | [expr1, ...]
+//   | [expr1, ... | expr1]
+func (p *parser) parseList() Node {
+	p.Expect(lex.TLBrack)
+	l := &List{}
+	if p.Accept(lex.TRBrack) {
+		return l
+	}
+	l.Elems = []Node{p.parseExpr1()}
+	for p.Accept(lex.TComma) {
+		l.Elems = append(l.Elems, p.parseExpr1())
+	}
+	if p.Accept(lex.TBar) {
+		l.Tail = p.parseExpr1()
+	}
+	p.Expect(lex.TRBrack)
+	return l
+}
+
+// parse a record:
+//  record:
+//   | {ident: expr1, ...}
+func (p *parser) parseRecord() Node {
+	r := &Record{Pos: p.Pos()}
+	p.Expect(lex.TLBrace)
+	for {
+		r.Names = append(r.Names, p.parseIdent().Name)
+		p.Expect(lex.TColon)
+		r.Fields = append(r.Fields, p.parseExpr1())
+		if !p.Accept(lex.TComma) {
+			break
+		}
+	}
+	p.Expect(lex.TRBrace)
+	return r
+}
+
 func (p *parser) parseParenExpr() Node {
 	p.Expect(lex.TLParen)
 	expr := p.parseExpr()
@@ -284,48 +735,48 @@ func (p *parser) parseParenExpr() Node {
 
 // ------------------------------------------
 
-var precedence = map[lex.TType]int{
-	lex.TMul: 5,
-	lex.TDiv: 5,
-	lex.TMod: 5,
+// fixity is the precedence and associativity of a binary operator,
+// and the function it calls.
+type fixity struct {
+	prec  int
+	right bool   // right-associative
+	fn    string // empty for the logic operators, which are parsed into a Logic node
+}
 
-	lex.TAdd:   4,
-	lex.TMinus: 4,
+// operators is the table of built-in operators, keyed by operator text.
+// A program adds its own with infixl and infixr declarations.
+var operators = map[string]fixity{
+	">>": {prec: 7, fn: "andThen"},
+	"<<": {prec: 7, fn: "compose"},
 
-	lex.TEq:  3,
-	lex.TGe:  3,
-	lex.TGt:  3,
-	lex.TLe:  3,
-	lex.TLt:  3,
-	lex.TNEq: 3,
+	"*": {prec: 6, fn: "mul"},
+	"/": {prec: 6, fn: "div"},
+	"%": {prec: 6, fn: "mod"},
 
-	lex.TAnd: 2,
+	"+": {prec: 5, fn: "add"},
+	"-": {prec: 5, fn: "sub"},
 
-	lex.TOr: 1,
-}
+	"==": {prec: 4, fn: "eq"},
+	">=": {prec: 4, fn: "ge"},
+	">":  {prec: 4, fn: "gt"},
+	"<=": {prec: 4, fn: "le"},
+	"<":  {prec: 4, fn: "lt"},
+	"!=": {prec: 4, fn: "neq"},
 
-func opFunc(t lex.TType) string {
-	if f, ok := opStr[t]; ok {
-		return f
-	}
-	panic(fmt.Sprintf("bug: bad operator: %v", t))
-}
+	"&&": {prec: 3},
+
+	"||": {prec: 2},
 
-var opStr = map[lex.TType]string{
-	lex.TAdd:   "add",
-	lex.TAnd:   "and",
-	lex.TEq:    "eq",
-	lex.TGe:    "ge",
-	lex.TGt:    "gt",
-	lex.TLe:    "le",
-	lex.TLt:    "lt",
-	lex.TMinus: "sub",
-	lex.TMod:   "mod",
-	lex.TMul:   "mul",
-	lex.TNEq:   "neq",
-	lex.TOr:    "or",
+	"|>": {prec: 1, fn: "pipe"},
 }
 
+// Precedence of a backquoted function, like a `max` b, unless declared otherwise.
+// It binds tighter than the built-in operators, and is left-associative.
+const backquotePrec = 9
+
+// maxPrec is the highest precedence an operator can be declared with.
+const maxPrec = 9
+
 var isUnary = map[lex.TType]bool{
 	lex.TAdd:   true,
 	lex.TMinus: true,
@@ -339,6 +790,11 @@ func (p *parser) Peek() lex.Token {
 	return p.next[0]
 }
 
+// Pos returns the position of the next token in the stream.
+func (p *parser) Pos() se.Position {
+	return p.pos[0]
+}
+
 func (p *parser) HasPeek(want ...lex.TType) bool {
 	for i, w := range want {
 		if p.next[i].TType != w {
@@ -358,8 +814,10 @@ func (p *parser) Next() lex.Token {
 
 	for i := 0; i < readAhead-1; i++ {
 		p.next[i] = p.next[i+1]
+		p.pos[i] = p.pos[i+1]
 	}
 	p.next[readAhead-1] = p.lex.Next()
+	p.pos[readAhead-1] = p.lex.Position()
 	return curr
 }
 
@@ -374,11 +832,10 @@ func (p *parser) Accept(t lex.TType) bool {
 
 // consume the next token and throw an error if it is not of the expected type.
 func (p *parser) Expect(t lex.TType) lex.Token {
-	if n := p.Next(); n.TType != t {
+	if n := p.Peek(); n.TType != t {
 		panic(p.SyntaxError(fmt.Sprintf("unexpected '%v', expected '%v'", n, t)))
-	} else {
-		return n
 	}
+	return p.Next()
 }
 
 // construct a syntax error for unexpected token at current position.
@@ -388,12 +845,7 @@ func (p *parser) Unexpected(t lex.Token) se.Error {
 
 // construct a syntax error at current position.
 func (p *parser) SyntaxError(msg string) se.Error {
-	return se.Errorf("line %v: %v", p.nextPos(), msg)
-}
-
-func (p *parser) nextPos() se.Position {
-	// TODO: return p.Peek().Pos
-	return se.Position{}
+	return se.Errorf("%v: %v", p.Pos(), msg)
 }
 
 func (p *parser) init() {
@@ -5,11 +5,20 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/internal/bench"
+	"github.com/barnex/se-lang/lex"
 )
 
-// Parse expressions and compare to the expected AST.
-func TestParseExpr(t *testing.T) {
+// parseTest is a valid input and its AST.
+type parseTest struct {
+	in   string
+	want Node
+}
 
+// parseTests returns the cases of TestParseExpr, also used to seed FuzzParse.
+func parseTests() []parseTest {
 	var (
 		add = ident("add")
 		f   = ident("f")
@@ -22,10 +31,7 @@ func TestParseExpr(t *testing.T) {
 		z   = ident("z")
 	)
 
-	cases := []struct {
-		in   string
-		want Node
-	}{
+	return []parseTest{
 		// operand
 		//  | - operand
 		{`--1`, call(neg, call(neg, one))},
@@ -37,6 +43,10 @@ func TestParseExpr(t *testing.T) {
 		//  | num
 		{`1`, one},
 
+		//  | string
+		{`"a b"`, &Str{"a b"}},
+		{`f("x\n")`, call(f, &Str{"x\n"})},
+
 		//  | ident
 		{`f`, f},
 
@@ -54,13 +64,29 @@ func TestParseExpr(t *testing.T) {
 
 		// binary
 		{`1*2>3`, call(ident("gt"), call(mul, num(1), num(2)), num(3))},
+		{`1/2-3`, call(sub, call(ident("div"), num(1), num(2)), num(3))},
 		{`1*2<3`, call(ident("lt"), call(mul, num(1), num(2)), num(3))},
 		{`1*2>=3`, call(ident("ge"), call(mul, num(1), num(2)), num(3))},
 		{`1*2<=3`, call(ident("le"), call(mul, num(1), num(2)), num(3))},
 		{`1*2==3`, call(ident("eq"), call(mul, num(1), num(2)), num(3))},
 		{`1*2!=3`, call(ident("neq"), call(mul, num(1), num(2)), num(3))},
 		{`2-1`, call(sub, num(2), num(1))},
+		{`x|>f|>g`, call(ident("pipe"), call(ident("pipe"), x, f), ident("g"))},
+		{`x+1|>f`, call(ident("pipe"), call(add, x, one), f)},
+		{`x|>f>>g<<h`, call(ident("pipe"), x, call(ident("compose"), call(ident("andThen"), f, ident("g")), ident("h")))},
+		{`x||y|>f`, call(ident("pipe"), &Logic{lex.TOr, x, y}, f)},
 		//{`3%4`, call(ident("mod"), num(3), num(4))},
+		{"x`f`y", call(f, x, y)},
+		{"x`f`y`f`z", call(f, call(f, x, y), z)},
+		{"x+y`f`z", call(add, x, call(f, y, z))},
+		{"-x`f`y", call(f, call(neg, x), y)},
+
+		// logic
+		{`x&&y`, &Logic{lex.TAnd, x, y}},
+		{`x||y`, &Logic{lex.TOr, x, y}},
+		{`x||y&&z`, &Logic{lex.TOr, x, &Logic{lex.TAnd, y, z}}},
+		{`x&&y||z`, &Logic{lex.TOr, &Logic{lex.TAnd, x, y}, z}},
+		{`x==y&&!z`, &Logic{lex.TAnd, call(ident("eq"), x, y), call(ident("not"), z)}},
 
 		// cond
 		{`x<y?x+y:0`, &Cond{call(ident("lt"), x, y), call(add, x, y), num(0)}},
@@ -85,9 +111,35 @@ func TestParseExpr(t *testing.T) {
 		{`{{x}}`, block(block(x))},
 		{`{x=1}`, block(assign(x, num(1)))},
 		{`{x=1;x}`, block(assign(x, num(1)), x)},
+		{`{type T=A|B(x,y); A}`, block(typedef("T", ctor("A"), ctor("B", "x", "y")), ident("A"))},
+
+		// match
+		{`match x {_->1}`, match(nodes(x), cas(&Wildcard{}, nil, one))},
+		{`match x {1->x; y->y}`, match(nodes(x), cas(&Lit{"1"}, nil, x), cas(y, nil, y))},
+		{`match x {-1->true; _->false}`, match(nodes(x), cas(&Lit{"-1"}, nil, ident("true")), cas(&Wildcard{}, nil, ident("false")))},
+		{`match x {y if y<0 -> -y}`, match(nodes(x), cas(y, call(ident("lt"), y, num(0)), call(neg, y)))},
+		{`match x, y {(0, z)->z}`, match(nodes(x, y), cas(&Tuple{[]Pattern{&Lit{"0"}, z}}, nil, z))},
+		{`match x {Nil->1; Cons(y, Nil)->y}`, match(nodes(x), cas(&CtorPat{Name: "Nil"}, nil, one), cas(&CtorPat{Name: "Cons", Args: []Pattern{y, &CtorPat{Name: "Nil"}}}, nil, y))},
+		{`match f(x) {y->z->y}`, match(nodes(call(f, x)), cas(y, nil, lambda(args(z), y)))},
+		{`match x {[]->1; [y]->y; [y, _|z]->z}`, match(nodes(x), cas(&ListPat{}, nil, one), cas(&ListPat{Elems: []Pattern{y}}, nil, y), cas(&ListPat{Elems: []Pattern{y, &Wildcard{}}, Tail: z}, nil, z))},
+		{`match x {{y: 0, z: z}->z}`, match(nodes(x), cas(&RecordPat{Names: []string{"y", "z"}, Fields: []Pattern{&Lit{"0"}, z}}, nil, z))},
+
+		// list
+		{`[]`, &List{}},
+		{`[x]`, &List{Elems: nodes(x)}},
+		{`[x, f(y)]`, &List{Elems: nodes(x, call(f, y))}},
+		{`[x, y | z]`, &List{Elems: nodes(x, y), Tail: z}},
+
+		// record
+		{`{x: 1}`, &Record{Names: []string{"x"}, Fields: nodes(one)}},
+		{`{x: 1, y: z}.y`, &Select{&Record{Names: []string{"x", "y"}, Fields: nodes(one, z)}, "y"}},
+		{`{{x: 1}}`, block(&Record{Names: []string{"x"}, Fields: nodes(one)})},
 	}
+}
 
-	for i, c := range cases {
+// Parse expressions and compare to the expected AST.
+func TestParseExpr(t *testing.T) {
+	for i, c := range parseTests() {
 		have, err := parse(c.in)
 		if err != nil {
 			t.Errorf("case %v: %v: error: %v", i, c.in, err)
@@ -99,39 +151,64 @@ func TestParseExpr(t *testing.T) {
 	}
 }
 
+// parseErrors are inputs with a syntax error.
+var parseErrors = []string{
+	`(1`,
+	`1)`,
+	` ( 1 `,
+	` 1 ) `,
+	`f(x`,
+	`f(x))`,
+	`f(x y)`,
+	`f(,)`,
+	`f g`,
+	`f(g) x`,
+	`1 2`,
+	`+`,
+	`-`,
+	`*`,
+	`,`,
+	`(,)`,
+	`1+`,
+	`a-`,
+	`(1+1)->2`,
+	`x(y)->x+y`, // not (lambda (x y) (add x y))
+	`()()`,      // not (())
+	`()`,        // not ()
+	`(1,2)`,     // not (1 2)
+	`1,2`,       // not (1 2)
+	`1,x`,
+	`x,y->y,x`,
+	`(x,y)->(y,x)`,
+	`match x {}`,
+	`match x {1}`,
+	`match x {1->2;}`,
+	`match x {f(y)->2}`,
+	`match x {((1,2),y)->2}`,
+	`match x {y if y->}`,
+	`match x {A(->1}`,
+	`match x {[|y]->1}`,
+	`match x {[x|]->1}`,
+	`match x {{}->1}`,
+	`match x {{y}->1}`,
+	`[1,]`,
+	`[|x]`,
+	`[x|y|z]`,
+	`{x: 1,}`,
+	`{x: y->y}`,
+	`{type T = a}`,
+	`{type T = A | b(x)}`,
+	`{type T = A(1)}`,
+	`{type T}`,
+	`x <> y`,
+	"x ` y",
+	"x `f y",
+	"x `1` y",
+}
+
 // Ensure parse errors on bad input.
 func TestParseError(t *testing.T) {
-	cases := []string{
-		`(1`,
-		`1)`,
-		` ( 1 `,
-		` 1 ) `,
-		`f(x`,
-		`f(x))`,
-		`f(x y)`,
-		`f(,)`,
-		`f g`,
-		`f(g) x`,
-		`1 2`,
-		`+`,
-		`-`,
-		`*`,
-		`,`,
-		`(,)`,
-		`1+`,
-		`a-`,
-		`(1+1)->2`,
-		`x(y)->x+y`, // not (lambda (x y) (add x y))
-		`()()`,      // not (())
-		`()`,        // not ()
-		`(1,2)`,     // not (1 2)
-		`1,2`,       // not (1 2)
-		`1,x`,
-		`x,y->y,x`,
-		`(x,y)->(y,x)`,
-	}
-
-	for _, c := range cases {
+	for _, c := range parseErrors {
 		e, err := parse(c)
 		if err == nil {
 			t.Errorf("%v: expected error, have: %v", c, ToString(e))
@@ -140,8 +217,100 @@ func TestParseError(t *testing.T) {
 	}
 }
 
+// Parse programs with fixity declarations.
+func TestParseFixity(t *testing.T) {
+	var (
+		f = ident("f")
+		x = ident("x")
+		y = ident("y")
+		z = ident("z")
+	)
+	cases := []struct {
+		in   string
+		want *Block
+	}{
+		{`infixl 6 <> = f; x <> y <> z`, block(call(f, call(f, x, y), z))},
+		{`infixr 8 <> = f; x <> y <> z`, block(call(f, x, call(f, y, z)))},
+		{`infixl 3 <> = f; x <> y + z`, block(call(f, x, call(ident("add"), y, z)))},
+		{`infixl 9 <> = f; x <> y + z`, block(call(ident("add"), call(f, x, y), z))},
+		{`infixl 3 <> = f; x <> y && z`, block(&Logic{lex.TAnd, call(f, x, y), z})},
+		{`infixl 6 <> = f; infixl 6 <> = g; x <> y`, block(call(ident("g"), x, y))},
+		{"infixl 3 `f`; x`f`y*z", block(call(f, x, call(ident("mul"), y, z)))},
+		{`infixl 6 <> = f`, block()},
+	}
+	for _, c := range cases {
+		have, err := parseProg(c.in)
+		if err != nil {
+			t.Errorf("%v: error: %v", c.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(have, c.want) {
+			t.Errorf("%v: have %v, want %v", c.in, ToString(have), ToString(c.want))
+		}
+	}
+
+	errors := []string{
+		`infixl <> = f; 1`,
+		`infixl 0 <> = f; 1`,
+		`infixl 10 <> = f; 1`,
+		`infixl 6 + = f; 1`,
+		`infixl 6 x = f; 1`,
+		`infixl 6 <>; 1`,
+		`infixl 6 <> = 1; 1`,
+		"infixl 6 `f` = g; 1",
+		`infixr 4 <> = f; 1`,
+		`infixl 8 <> = f; infixr 8 >< = f; 1`,
+		`infixl 1 |> = f; 1`,
+		"infixr 9 `f`; 1",
+		`{infixl 6 <> = f; 1}`,
+		`f = x -> {infixl 6 <> = f; 1}; 1`,
+		`x <> y; infixl 6 <> = f`,
+	}
+	for _, src := range errors {
+		if b, err := parseProg(src); err == nil {
+			t.Errorf("%v: expected error, have: %v", src, ToString(b))
+		}
+	}
+}
+
+// parseProg is like parse, for a program.
+func parseProg(src string) (*Block, error) {
+	b, err := ParseProgram(strings.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	Walk(b, func(n Node) bool {
+		if n, ok := n.(*Ident); ok {
+			n.Pos = se.Position{}
+		}
+		return true
+	})
+	return b, nil
+}
+
+// parse parses an expression, without source positions
+// so that it can be compared to a hand-written AST.
 func parse(src string) (Node, error) {
-	return ParseExpr(strings.NewReader(src))
+	n, err := ParseExpr(strings.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	Walk(n, func(n Node) bool {
+		switch n := n.(type) {
+		case *Ident:
+			n.Pos = se.Position{}
+		case *Match:
+			n.Pos = se.Position{}
+		case *CtorPat:
+			n.Pos = se.Position{}
+		case *Record:
+			n.Pos = se.Position{}
+		case *RecordPat:
+			n.Pos = se.Position{}
+		}
+		return true
+	})
+	return n, nil
 }
 
 func num(v float64) Node                   { return &Num{fmt.Sprint(v)} }
@@ -151,6 +320,18 @@ func lambda(args []*Ident, body Node) Node { return &Lambda{Args: args, Body: bo
 func args(n ...*Ident) []*Ident            { return n }
 func block(n ...Node) *Block               { return &Block{Stmts: n} }
 func assign(lhs *Ident, rhs Node) Node     { return &Assign{lhs, rhs} }
+func cas(p Pattern, g, body Node) *Case    { return &Case{p, g, body} }
+func match(x []Node, c ...*Case) Node      { return &Match{X: x, Cases: c} }
+func nodes(n ...Node) []Node               { return n }
+func ctor(n string, f ...string) *Ctor     { return &Ctor{Name: ident(n), Fields: f} }
+
+func typedef(name string, c ...*Ctor) *TypeDef {
+	t := &TypeDef{Name: name, Ctors: c}
+	for _, c := range c {
+		c.Type = t
+	}
+	return t
+}
 
 func normalize(x []Node) []Node {
 	if x == nil {
@@ -159,3 +340,17 @@ func normalize(x []Node) []Node {
 	}
 	return x
 }
+
+func BenchmarkParse(b *testing.B) {
+	for _, p := range bench.Programs(b) {
+		b.Run(p.Name, func(b *testing.B) {
+			b.SetBytes(int64(len(p.Src)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ParseProgram(strings.NewReader(p.Src)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,52 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/lex"
+)
+
+// Record is a record expression, e.g.:
+// 	{x: 1, y: 2}
+// Its fields are selected like module members: r.x
+type Record struct {
+	Names  []string
+	Fields []Node
+	Pos    se.Position
+}
+
+func (n *Record) PrintTo(w io.Writer) {
+	printFields(w, n.Names, n.Fields)
+}
+
+// RecordPat is a pattern that matches records with the given fields, e.g.:
+// 	{x: 0, y: y}
+// The record may have more fields than the pattern.
+type RecordPat struct {
+	Names  []string
+	Fields []Pattern
+	Pos    se.Position
+}
+
+func (*RecordPat) pattern() {}
+
+func (n *RecordPat) PrintTo(w io.Writer) {
+	printFields(w, n.Names, n.Fields)
+}
+
+// printFields prints the fields of a record or record pattern, e.g.:
+// 	{x:1, y:2}
+func printFields(w io.Writer, names []string, fields interface{}) {
+	fmt.Fprint(w, lex.TLBrace)
+	for i, name := range names {
+		if i != 0 {
+			fmt.Fprint(w, ", ")
+		}
+		fmt.Fprint(w, name, lex.TColon)
+		reflect.ValueOf(fields).Index(i).Interface().(Node).PrintTo(w)
+	}
+	fmt.Fprint(w, lex.TRBrace)
+}
@@ -2,14 +2,35 @@ package ast
 
 import (
 	"fmt"
+	"strings"
+
+	se "github.com/barnex/se-lang"
 )
 
 // Resolve traverses the AST and populates the Var fields of all identifiers.
 // Lambda arguments are resolved to *Arg.
 // Local variables are resolved to *LocVar.
-func Resolve(n Node) {
-	gather(n, Frames{})
-	resolve(Frames{}, n)
+//
+// All bindings of a block are in scope in the entire block,
+// and are captured by reference. So functions can refer to
+// themselves and to functions defined later in the same block, e.g.:
+// 	isEven = n -> n == 0 || isOdd(n-1);
+// 	isOdd  = n -> n != 0 && isEven(n-1);
+// Other bindings must be defined before they are used,
+// functions must be defined before they are called.
+//
+// Identifiers that are not declared in the AST must be in globals,
+// e.g. the names of built-in functions.
+// Resolve returns the problems it found, sorted by position:
+// errors for undefined or redeclared identifiers,
+// warnings for unused or shadowing declarations.
+func Resolve(n Node, globals ...string) []Diag {
+	g := &global{names: globals, used: make(map[Var]bool)}
+	gather(n, Frames{g})
+	resolve(Frames{g}, n)
+	g.checkUsed()
+	sortDiags(g.diags)
+	return g.diags
 }
 
 // gather traverses the AST and records all variable declarations.
@@ -30,9 +51,30 @@ func gather(n Node, s Frames) {
 		gatherCond(n, s)
 	case *Ident:
 		gatherIdent(n, s)
+	case *Import: // declared by gatherBlock
 	case *Lambda:
 		gatherLambda(n, s)
-	case *Num: // nothing to do
+	case *List:
+		for _, e := range n.Elems {
+			gather(e, s)
+		}
+		if n.Tail != nil {
+			gather(n.Tail, s)
+		}
+	case *Logic:
+		gather(n.X, s)
+		gather(n.Y, s)
+	case *Match:
+		gatherMatch(n, s)
+	case *Num, *Str: // nothing to do
+	case *Record:
+		checkFields(s, n, n.Names)
+		for _, x := range n.Fields {
+			gather(x, s)
+		}
+	case *Select:
+		gather(n.X, s)
+	case *TypeDef: // declared by gatherBlock
 	default:
 		panic(unhandled(n))
 	}
@@ -60,7 +102,25 @@ func resolve(s Frames, n Node) {
 		resolveIdent(s, n)
 	case *Lambda:
 		resolveLambda(s, n)
-	case *Num: // nothing to do
+	case *List:
+		for _, e := range n.Elems {
+			resolve(s, e)
+		}
+		if n.Tail != nil {
+			resolve(s, n.Tail)
+		}
+	case *Logic:
+		resolve(s, n.X)
+		resolve(s, n.Y)
+	case *Match:
+		resolveMatch(s, n)
+	case *Num, *Str, *Import, *TypeDef: // nothing to do
+	case *Record:
+		for _, x := range n.Fields {
+			resolve(s, x)
+		}
+	case *Select:
+		resolve(s, n.X)
 	default:
 		panic(unhandled(n))
 	}
@@ -69,7 +129,6 @@ func resolve(s Frames, n Node) {
 // ---- Assign
 
 func gatherAssign(a *Assign, s Frames) {
-	a.LHS.Var = parentLambda(s).NewVariable()
 	gather(a.RHS, s)
 }
 
@@ -80,9 +139,28 @@ func resolveAssign(s Frames, a *Assign) {
 // ---- Block
 
 func gatherBlock(b *Block, s Frames) {
+	top := s.isTop()
+	outer := s
 	s.Push(b)
 	defer s.Pop()
 
+	// declare all bindings first,
+	// so that they can be captured before their definition.
+	// Top-level bindings may be used by importers, so they are not reported as unused.
+	seen := make(map[string]bool)
+	for _, stmt := range b.Stmts {
+		for _, id := range bindings(stmt) {
+			_, isCtor := stmt.(*TypeDef)
+			if seen[id.Name] {
+				s.global().errorf(id, "%v redeclared in this block", id.Name)
+			} else {
+				s.global().declare(outer, id, !top && !isCtor)
+			}
+			seen[id.Name] = true
+			id.Var = parentLambda(s).NewVariable()
+		}
+	}
+
 	for _, stmt := range b.Stmts {
 		gather(stmt, s)
 	}
@@ -92,17 +170,61 @@ func resolveBlock(s Frames, b *Block) {
 	s.Push(b)
 	defer s.Pop()
 
-	for _, stmt := range b.Stmts {
+	for i, stmt := range b.Stmts {
+		b.cur = i
 		resolve(s, stmt)
 	}
 }
 
 func (b *Block) Find(name string) Var {
+	if i := b.index(name); i >= 0 {
+		for _, id := range bindings(b.Stmts[i]) {
+			if id.Name == name {
+				return id.Var
+			}
+		}
+	}
+	return nil
+}
+
+// index returns the index of the statement that declares name, or -1.
+func (b *Block) index(name string) int {
+	for i, stmt := range b.Stmts {
+		for _, id := range bindings(stmt) {
+			if id.Name == name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// bindings returns the identifiers declared by a block statement.
+func bindings(stmt Node) []*Ident {
+	switch stmt := stmt.(type) {
+	case *Assign:
+		return []*Ident{stmt.LHS}
+	case *Import:
+		return []*Ident{stmt.Name}
+	case *TypeDef:
+		var ids []*Ident
+		for _, c := range stmt.Ctors {
+			ids = append(ids, c.Name)
+		}
+		return ids
+	default:
+		return nil
+	}
+}
+
+// FindCtor returns the constructor with given name declared in the block, if any.
+func (b *Block) FindCtor(name string) *Ctor {
 	for _, stmt := range b.Stmts {
-		if a, ok := stmt.(*Assign); ok {
-			if a.LHS.Name == name {
-				//assert(a.LHS.Var != nil)
-				return a.LHS.Var
+		if t, ok := stmt.(*TypeDef); ok {
+			for _, c := range t.Ctors {
+				if c.Name.Name == name {
+					return c
+				}
 			}
 		}
 	}
@@ -149,6 +271,7 @@ func gatherIdent(id *Ident, s Frames) {
 	if v == nil {
 		return // not in parent, so not a capture
 	}
+	s.global().used[v] = true
 
 	switch {
 	case defScope == -1:
@@ -160,7 +283,8 @@ func gatherIdent(id *Ident, s Frames) {
 		// loop over frames, capture from defscope+1 to last, capture all the way
 		for i := defScope + 1; i < len(s); i++ {
 			if l, ok := s[i].(*Lambda); ok {
-				v := s[i-1].Find(name)
+				outer := s[:i]
+				v, _ := outer.Find(name)
 				l.DoCapture(name, v)
 			}
 		}
@@ -170,15 +294,74 @@ func gatherIdent(id *Ident, s Frames) {
 func resolveIdent(s Frames, id *Ident) {
 	v, _ := s.Find(id.Name)
 	id.Var = v
+	if v == nil && !s.global().isGlobal(id.Name) {
+		if alt := suggest(id.Name, s.names()); alt != "" {
+			s.global().errorf(id, "undefined: %v, did you mean %v?", id.Name, alt)
+		} else {
+			s.global().errorf(id, "undefined: %v", id.Name)
+		}
+		return
+	}
+	checkDefined(s, id)
+}
+
+// checkDefined reports an error if id refers to a binding in an enclosing block
+// that is not yet defined when id is evaluated.
+// A function may be referred to before its definition,
+// but only from inside a lambda, which is evaluated later.
+// Constructors and imports are defined before all other bindings.
+func checkDefined(s Frames, id *Ident) {
+	deferred := false // id is evaluated later than the statement containing it
+	for i := len(s) - 1; i >= 0; i-- {
+		switch f := s[i].(type) {
+		case *Lambda:
+			for _, a := range f.Args {
+				if a.Name == id.Name {
+					return
+				}
+			}
+			deferred = true
+		case *Case:
+			if f.Find(id.Name) != nil {
+				return
+			}
+		case *Block:
+			j := f.index(id.Name)
+			if j < 0 {
+				continue
+			}
+			if j < f.cur {
+				return // defined before
+			}
+			a, ok := f.Stmts[j].(*Assign)
+			if !ok {
+				return // constructor or import
+			}
+			if _, isFunc := a.RHS.(*Lambda); !isFunc || !deferred {
+				s.global().errorf(id, "%v used before its definition", id.Name)
+			}
+			return
+		}
+	}
 }
 
 // ---- Lambda
 
 func gatherLambda(n *Lambda, s Frames) {
+	outer := s
 	s.Push(n)
 	defer s.Pop()
 
 	for i, a := range n.Args {
+		dup := false
+		for _, b := range n.Args[:i] {
+			dup = dup || a.Name == b.Name
+		}
+		if dup {
+			s.global().errorf(a, "duplicate argument %v", a.Name)
+		} else {
+			s.global().declare(outer, a, true)
+		}
 		a.Var = &Arg{Index: i}
 	}
 	gather(n.Body, s)
@@ -191,6 +374,112 @@ func resolveLambda(s Frames, n *Lambda) {
 	resolve(s, n.Body)
 }
 
+// ---- Match
+
+func gatherMatch(n *Match, s Frames) {
+	for _, x := range n.X {
+		gather(x, s)
+	}
+	n.Tmp = nil
+	for range n.X {
+		n.Tmp = append(n.Tmp, parentLambda(s).NewVariable())
+	}
+	for _, c := range n.Cases {
+		gatherCase(n, c, s)
+	}
+}
+
+func gatherCase(m *Match, c *Case, s Frames) {
+	if t, ok := c.Pat.(*Tuple); ok && len(t.Elems) != len(m.X) {
+		s.global().errorf(m, "tuple pattern has %v elements, matching %v values", len(t.Elems), len(m.X))
+	} else if !m.fits(c.Pat) {
+		s.global().errorf(m, "matching %v values, pattern must be a tuple or _", len(m.X))
+	}
+
+	outer := s
+	s.Push(c)
+	defer s.Pop()
+
+	seen := make(map[string]bool)
+	walkPattern(c.Pat, func(p Pattern) {
+		if id, ok := p.(*Ident); ok {
+			if seen[id.Name] {
+				s.global().errorf(id, "%v bound more than once in pattern", id.Name)
+			} else {
+				s.global().declare(outer, id, true)
+			}
+			seen[id.Name] = true
+			id.Var = parentLambda(s).NewVariable()
+		}
+	})
+	if c.Guard != nil {
+		gather(c.Guard, s)
+	}
+	gather(c.Body, s)
+}
+
+func resolveMatch(s Frames, n *Match) {
+	for _, x := range n.X {
+		resolve(s, x)
+	}
+	ok := true
+	for _, c := range n.Cases {
+		ok = resolveCase(s, c) && n.fits(c.Pat) && ok
+	}
+	if ok && !n.Exhaustive() {
+		s.global().warnf(n, "match is not exhaustive")
+	}
+}
+
+// resolveCase returns false if the case has an invalid constructor or record pattern.
+func resolveCase(s Frames, c *Case) bool {
+	ok := true
+	walkPattern(c.Pat, func(p Pattern) {
+		switch p := p.(type) {
+		case *CtorPat:
+			ok = resolveCtorPat(s, p) && ok
+		case *RecordPat:
+			ok = checkFields(s, p, p.Names) && ok
+		}
+	})
+
+	s.Push(c)
+	defer s.Pop()
+
+	if c.Guard != nil {
+		resolve(s, c.Guard)
+	}
+	resolve(s, c.Body)
+	return ok
+}
+
+func resolveCtorPat(s Frames, p *CtorPat) bool {
+	p.Ctor = s.FindCtor(p.Name)
+	if p.Ctor == nil {
+		s.global().errorf(p, "undefined constructor: %v", p.Name)
+		return false
+	}
+	if len(p.Args) != len(p.Ctor.Fields) {
+		s.global().errorf(p, "constructor %v has %v fields, pattern has %v", p.Name, len(p.Ctor.Fields), len(p.Args))
+		return false
+	}
+	return true
+}
+
+// checkFields returns false, after reporting an error,
+// if a record or record pattern n has duplicate field names.
+func checkFields(s Frames, n Node, names []string) bool {
+	for i, name := range names {
+		for _, prev := range names[:i] {
+			if name == prev {
+				s.global().errorf(n, "duplicate field %v", name)
+				return false
+			}
+		}
+	}
+	return true
+}
+
 func parentLambda(s Frames) *Lambda {
 	//if len(s) < 2 {
 	//	panic("no parent frame (1)")
@@ -272,6 +561,135 @@ func (f *Frames) Find(name string) (Var, int) {
 	return nil, 0
 }
 
+// FindCtor returns the innermost constructor with given name, if any.
+func (f *Frames) FindCtor(name string) *Ctor {
+	s := *f
+	for i := len(s) - 1; i >= 0; i-- {
+		if b, ok := s[i].(*Block); ok {
+			if c := b.FindCtor(name); c != nil {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// global is the outermost frame.
+// It holds the names of globals and the diagnostics found by Resolve.
+type global struct {
+	names []string
+	diags []Diag
+	used  map[Var]bool
+	decls []*Ident // declarations to be reported if unused
+}
+
+// Find returns nil: globals are not variables.
+func (g *global) Find(name string) Var {
+	return nil
+}
+
+func (g *global) isGlobal(name string) bool {
+	for _, n := range g.names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// declare warns if id shadows a name visible in the outer frames,
+// and records id to check if it is used.
+// Names starting with an underscore are exempt.
+func (g *global) declare(outer Frames, id *Ident, checkUsed bool) {
+	if strings.HasPrefix(id.Name, "_") {
+		return
+	}
+	if v, _ := outer.Find(id.Name); v != nil {
+		g.warnf(id, "declaration of %v shadows an outer declaration", id.Name)
+	} else if g.isGlobal(id.Name) {
+		g.warnf(id, "declaration of %v shadows a built-in", id.Name)
+	}
+	if checkUsed {
+		g.decls = append(g.decls, id)
+	}
+}
+
+func (g *global) checkUsed() {
+	for _, id := range g.decls {
+		if !g.used[id.Var] {
+			g.warnf(id, "%v declared and not used", id.Name)
+		}
+	}
+}
+
+func (g *global) errorf(n Node, format string, x ...interface{}) {
+	g.diags = append(g.diags, Diag{Pos: pos(n), Msg: fmt.Sprintf(format, x...)})
+}
+
+func (g *global) warnf(n Node, format string, x ...interface{}) {
+	g.diags = append(g.diags, Diag{Pos: pos(n), Warning: true, Msg: fmt.Sprintf(format, x...)})
+}
+
+// pos returns the source position of nodes that record one.
+func pos(n Node) se.Position {
+	switch n := n.(type) {
+	case *Ident:
+		return n.Pos
+	case *Match:
+		return n.Pos
+	case *CtorPat:
+		return n.Pos
+	case *Record:
+		return n.Pos
+	case *RecordPat:
+		return n.Pos
+	default:
+		return se.Position{}
+	}
+}
+
+func (s Frames) global() *global {
+	return s[0].(*global)
+}
+
+// isTop returns true if no block encloses the current frame.
+func (s Frames) isTop() bool {
+	for _, f := range s {
+		if _, ok := f.(*Block); ok {
+			return false
+		}
+	}
+	return true
+}
+
+// names returns all names visible in the current frame, innermost first.
+func (s Frames) names() []string {
+	var names []string
+	for i := len(s) - 1; i >= 0; i-- {
+		switch f := s[i].(type) {
+		case *global:
+			names = append(names, f.names...)
+		case *Lambda:
+			for _, a := range f.Args {
+				names = append(names, a.Name)
+			}
+		case *Block:
+			for _, stmt := range f.Stmts {
+				for _, id := range bindings(stmt) {
+					names = append(names, id.Name)
+				}
+			}
+		case *Case:
+			walkPattern(f.Pat, func(p Pattern) {
+				if id, ok := p.(*Ident); ok {
+					names = append(names, id.Name)
+				}
+			})
+		}
+	}
+	return names
+}
+
 func Log(action string, arg interface{}) {
 	//log.SetFlags(0)
 	//log.Printf("%s: %#v\n", action, arg)
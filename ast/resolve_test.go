@@ -0,0 +1,70 @@
+package ast
+
+import (
+	"strings"
+	"testing"
+)
+
+// Resolve programs and compare the diagnostics.
+func TestResolveDiags(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{`x = 1; x`, ``},
+		{`f = x -> x + 1; f(1)`, ``},
+		{`{y = 1; y}`, ``},
+		{`_x -> 1`, ``},
+
+		// undefined
+		{`x`, `1:1: undefined: x`},
+		{`adx(1, 2)`, `1:1: undefined: adx, did you mean add?`},
+		{`count = 1; cuont + 1`, `1:12: undefined: cuont, did you mean count?`},
+		{`f = (abc) -> abd; f`, "1:6: warning: abc declared and not used\n" +
+			"1:14: undefined: abd, did you mean abc?"},
+		{`match 1 {Nil -> 1}`, `1:10: undefined constructor: Nil`},
+
+		// duplicate
+		{`x = 1; x = 2; x`, `1:8: x redeclared in this block`},
+		{`(x, x) -> x`, `1:5: duplicate argument x`},
+		{`match 1, 2 {(x, x) -> x}`, `1:17: x bound more than once in pattern`},
+		{`match 1, 2 {n -> n}`, `1:1: matching 2 values, pattern must be a tuple or _`},
+		{`match 1, 2 {(1, n) -> n; 0 -> 0; _ -> 1}`, `1:1: matching 2 values, pattern must be a tuple or _`},
+		{`match 1, 2 {(n, 1, 2) -> n}`, `1:1: tuple pattern has 3 elements, matching 2 values`},
+		{`match 1 {(_, 0) -> 0; _ -> 0}`, `1:1: tuple pattern has 2 elements, matching 1 values`},
+		{`{x: 1, y: 2, x: 3}`, `1:1: duplicate field x`},
+		{`match 1 {{x: a, x: _} -> a; _ -> 0}`, `1:10: duplicate field x`},
+		{`match [1] {[x, x] -> x; _ -> 0}`, `1:16: x bound more than once in pattern`},
+
+		// unused
+		{`{y = 1; 2}`, `1:2: warning: y declared and not used`},
+		{`x -> 1`, `1:1: warning: x declared and not used`},
+		{`match 1 {x -> 2}`, `1:10: warning: x declared and not used`},
+
+		// shadowing
+		{`x = 1; f = x -> x; f(x)`, `1:12: warning: declaration of x shadows an outer declaration`},
+		{`x -> {x = 1; x}`, "1:1: warning: x declared and not used\n" +
+			"1:7: warning: declaration of x shadows an outer declaration"},
+		{`f = add -> add; f(1)`, `1:5: warning: declaration of add shadows a built-in`},
+
+		// several, sorted by position
+		{`x -> {y = z; 1}`, "1:1: warning: x declared and not used\n" +
+			"1:7: warning: y declared and not used\n" +
+			"1:11: undefined: z"},
+	}
+
+	for _, c := range cases {
+		n, err := ParseProgram(strings.NewReader(c.src))
+		if err != nil {
+			t.Errorf("%v: error: %v", c.src, err)
+			continue
+		}
+		var have []string
+		for _, d := range Resolve(&Lambda{Body: n}, "add", "true", "false") {
+			have = append(have, strings.TrimPrefix(d.String(), "<input>:"))
+		}
+		if h := strings.Join(have, "\n"); h != c.want {
+			t.Errorf("%v:\nhave:\n%v\nwant:\n%v", c.src, h, c.want)
+		}
+	}
+}
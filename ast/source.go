@@ -0,0 +1,299 @@
+package ast
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+
+	"github.com/barnex/se-lang/lex"
+)
+
+// Source returns source text for n, which parses back to the same tree.
+// Unlike ToString, it prints no annotations from resolve,
+// and calls of operator functions (add, neg, ...) are printed as operators,
+// parenthesized only where precedence or associativity requires it.
+// A program, as returned by ParseProgram, is printed as a block.
+func Source(n Node) string {
+	var p sourcePrinter
+	p.expr(n, precExpr)
+	return p.String()
+}
+
+// Precedence levels of the printed expressions, extending the operator precedence.
+// An expression is parenthesized where a higher level is needed.
+const (
+	precExpr    = 0           // lambda, block, cond or match
+	precExpr1   = 1           // binary operators: 1 and up, see operators
+	precUnary   = maxPrec + 1 // - operand, ! operand
+	precOperand = maxPrec + 2 // num, ident, call, ...
+)
+
+// opSymbol is the built-in operator of each operator function.
+var opSymbol = map[string]string{}
+
+func init() {
+	for op, f := range operators {
+		if f.fn != "" {
+			opSymbol[f.fn] = op
+		}
+	}
+}
+
+type sourcePrinter struct {
+	bytes.Buffer
+}
+
+// expr prints n, in parentheses if its precedence is below prec.
+func (p *sourcePrinter) expr(n Node, prec int) {
+	if precOf(n) < prec {
+		p.WriteString("(")
+		defer p.WriteString(")")
+	}
+
+	switch n := n.(type) {
+	default:
+		panic(fmt.Sprintf("bug: Source: unexpected node: %T", n))
+	case *Num:
+		p.WriteString(n.Value)
+	case *Str:
+		p.WriteString(strconv.Quote(n.Value))
+	case *Ident:
+		p.WriteString(n.Name)
+	case *Select:
+		x := precOperand
+		if _, ok := n.X.(*Num); ok {
+			x = precOperand + 1 // 1.x would lex as a float
+		}
+		p.expr(n.X, x)
+		p.print(lex.TDot, n.Sel)
+	case *Call:
+		if op, ok := operator(n); ok {
+			if len(n.Args) == 1 {
+				p.print(op)
+				p.expr(n.Args[0], precUnary)
+			} else {
+				prec := operators[op].prec
+				p.expr(n.Args[0], prec)
+				p.print(" ", op, " ")
+				p.expr(n.Args[1], prec+1) // left associative
+			}
+			return
+		}
+		p.expr(n.F, precOperand)
+		p.WriteString("(")
+		for i, a := range n.Args {
+			if i != 0 {
+				p.WriteString(", ")
+			}
+			p.expr(a, precExpr1)
+		}
+		p.WriteString(")")
+	case *Logic:
+		prec := operators[n.Op.String()].prec
+		p.expr(n.X, prec)
+		p.print(" ", n.Op, " ")
+		p.expr(n.Y, prec+1)
+	case *Cond:
+		p.expr(n.Test, precExpr1)
+		p.print(" ", lex.TQuestion, " ")
+		p.expr(n.If, precExpr)
+		p.print(" ", lex.TColon, " ")
+		p.expr(n.Else, precExpr)
+	case *Lambda:
+		if len(n.Args) == 1 {
+			p.WriteString(n.Args[0].Name)
+		} else {
+			p.idents(n.Args)
+		}
+		p.print(" ", lex.TLambda, " ")
+		p.expr(n.Body, precExpr)
+	case *Block:
+		p.WriteString("{")
+		for i, s := range n.Stmts {
+			if i != 0 {
+				p.WriteString("; ")
+			}
+			p.stmt(s)
+		}
+		p.WriteString("}")
+	case *List:
+		p.WriteString("[")
+		for i, e := range n.Elems {
+			if i != 0 {
+				p.WriteString(", ")
+			}
+			p.expr(e, precExpr1)
+		}
+		if n.Tail != nil {
+			p.print(" ", lex.TBar, " ")
+			p.expr(n.Tail, precExpr1)
+		}
+		p.WriteString("]")
+	case *Record:
+		p.WriteString("{")
+		for i, x := range n.Fields {
+			if i != 0 {
+				p.WriteString(", ")
+			}
+			p.print(n.Names[i], lex.TColon, " ")
+			p.expr(x, precExpr1)
+		}
+		p.WriteString("}")
+	case *Match:
+		p.print(lex.TMatch, " ")
+		for i, x := range n.X {
+			if i != 0 {
+				p.WriteString(", ")
+			}
+			p.expr(x, precExpr1)
+		}
+		p.WriteString(" {")
+		for i, c := range n.Cases {
+			if i != 0 {
+				p.WriteString("; ")
+			}
+			p.pattern(c.Pat)
+			if c.Guard != nil {
+				p.print(" ", lex.TIf, " ")
+				p.expr(c.Guard, precExpr1)
+			}
+			p.print(" ", lex.TLambda, " ")
+			p.expr(c.Body, precExpr)
+		}
+		p.WriteString("}")
+	}
+}
+
+// stmt prints a statement of a block.
+func (p *sourcePrinter) stmt(n Node) {
+	switch n := n.(type) {
+	default:
+		p.expr(n, precExpr)
+	case *Assign:
+		p.print(n.LHS.Name, " ", lex.TAssign, " ")
+		p.expr(n.RHS, precExpr)
+	case *Import:
+		p.print(lex.TImport, " ", n.Name.Name, " ", strconv.Quote(n.Path))
+	case *TypeDef:
+		p.print(lex.TTypedef, " ", n.Name, " ", lex.TAssign, " ")
+		for i, c := range n.Ctors {
+			if i != 0 {
+				p.print(" ", lex.TBar, " ")
+			}
+			p.WriteString(c.Name.Name)
+			if len(c.Fields) > 0 {
+				var fields []*Ident
+				for _, f := range c.Fields {
+					fields = append(fields, &Ident{Name: f})
+				}
+				p.idents(fields)
+			}
+		}
+	}
+}
+
+func (p *sourcePrinter) pattern(n Pattern) {
+	switch n := n.(type) {
+	default:
+		panic(fmt.Sprintf("bug: Source: unexpected pattern: %T", n))
+	case *Wildcard:
+		p.WriteString("_")
+	case *Lit:
+		p.WriteString(n.Value)
+	case *Ident:
+		p.WriteString(n.Name)
+	case *CtorPat:
+		p.WriteString(n.Name)
+		if len(n.Args) > 0 {
+			p.patterns(n.Args)
+		}
+	case *Tuple:
+		p.patterns(n.Elems)
+	case *ListPat:
+		p.WriteString("[")
+		for i, e := range n.Elems {
+			if i != 0 {
+				p.WriteString(", ")
+			}
+			p.pattern(e)
+		}
+		if n.Tail != nil {
+			p.print(" ", lex.TBar, " ")
+			p.pattern(n.Tail)
+		}
+		p.WriteString("]")
+	case *RecordPat:
+		p.WriteString("{")
+		for i, x := range n.Fields {
+			if i != 0 {
+				p.WriteString(", ")
+			}
+			p.print(n.Names[i], lex.TColon, " ")
+			p.pattern(x)
+		}
+		p.WriteString("}")
+	}
+}
+
+func (p *sourcePrinter) patterns(l []Pattern) {
+	p.WriteString("(")
+	for i, x := range l {
+		if i != 0 {
+			p.WriteString(", ")
+		}
+		p.pattern(x)
+	}
+	p.WriteString(")")
+}
+
+func (p *sourcePrinter) idents(l []*Ident) {
+	p.WriteString("(")
+	for i, id := range l {
+		if i != 0 {
+			p.WriteString(", ")
+		}
+		p.WriteString(id.Name)
+	}
+	p.WriteString(")")
+}
+
+func (p *sourcePrinter) print(x ...interface{}) {
+	fmt.Fprint(p, x...)
+}
+
+// precOf returns the precedence level of n, see precExpr.
+func precOf(n Node) int {
+	switch n := n.(type) {
+	case *Lambda, *Block, *Cond, *Match:
+		return precExpr
+	case *Logic:
+		return operators[n.Op.String()].prec
+	case *Call:
+		if op, ok := operator(n); ok {
+			if len(n.Args) == 1 {
+				return precUnary
+			}
+			return operators[op].prec
+		}
+	}
+	return precOperand
+}
+
+// operator returns the operator of a call printed as an operator:
+// of an unresolved built-in operator function, with one argument for - and !, or else two.
+// Calls of user-defined operators are printed as calls,
+// since their declarations are not part of the tree.
+func operator(n *Call) (string, bool) {
+	id, ok := n.F.(*Ident)
+	if !ok || id.Var != nil {
+		return "", false
+	}
+	switch {
+	case id.Name == "neg" && len(n.Args) == 1:
+		return lex.TMinus.String(), true
+	case id.Name == "not" && len(n.Args) == 1:
+		return lex.TNot.String(), true
+	}
+	op, ok := opSymbol[id.Name]
+	return op, ok && len(n.Args) == 2
+}
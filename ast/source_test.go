@@ -0,0 +1,47 @@
+package ast
+
+import "testing"
+
+func TestSource(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{`1`, `1`},
+		{`"a\tb"`, `"a\tb"`},
+		{`1+2*3`, `1 + 2 * 3`},
+		{`(1+2)*3`, `(1 + 2) * 3`},
+		{`1-(2-3)`, `1 - (2 - 3)`},
+		{`(1-2)-3`, `1 - 2 - 3`},
+		{`1/2/3`, `1 / 2 / 3`},
+		{`1<2==(3<4)`, `1 < 2 == (3 < 4)`},
+		{`-(1+2)`, `-(1 + 2)`},
+		{`--x`, `--x`},
+		{`-f(x)`, `-f(x)`},
+		{`(-f)(x)`, `(-f)(x)`},
+		{`!a&&b||c`, `!a && b || c`},
+		{`a&&(b||c)`, `a && (b || c)`},
+		{`a?b:c?d:e`, `a ? b : c ? d : e`},
+		{`(a?b:c)?d:e`, `(a ? b : c) ? d : e`},
+		{`f((x->x), (a?1:2))`, `f((x -> x), (a ? 1 : 2))`},
+		{`(x->x)(1)`, `(x -> x)(1)`},
+		{`(x,y)->()->x+y`, `(x, y) -> () -> x + y`},
+		{`{a=1; type T = A | B(x, y); a}`, `{a = 1; type T = A | B(x, y); a}`},
+		{`{import p "lib/prime"; p.isPrime}`, `{import p "lib/prime"; p.isPrime}`},
+		{`(1).x`, `(1).x`},
+		{`match x, y {(0, _)->1; (A(z), -1) if z>0 -> z}`, `match x, y {(0, _) -> 1; (A(z), -1) if z > 0 -> z}`},
+		{`[1,a+b|t]`, `[1, a + b | t]`},
+		{`{x:1,y:(z->z)}.x`, `{x: 1, y: (z -> z)}.x`},
+		{`{{x:1}}`, `{{x: 1}}`},
+		{`match l {[]->0; [x,_|t]->x; {a:[], b:_}->1}`, `match l {[] -> 0; [x, _ | t] -> x; {a: [], b: _} -> 1}`},
+	}
+	for _, c := range cases {
+		n, err := parse(c.in)
+		if err != nil {
+			t.Errorf("%v: %v", c.in, err)
+			continue
+		}
+		if have := Source(n); have != c.want {
+			t.Errorf("%v: have %v, want %v", c.in, have, c.want)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package ast
+
+import (
+	"fmt"
+	"io"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/lex"
+)
+
+// TypeDef declares a sum type and its constructors, e.g.:
+// 	type Shape = Circle(r) | Rect(w, h)
+type TypeDef struct {
+	Name  string
+	Ctors []*Ctor
+}
+
+func (n *TypeDef) PrintTo(w io.Writer) {
+	fmt.Fprint(w, lex.TTypedef, " ", n.Name, lex.TAssign)
+	for i, c := range n.Ctors {
+		if i != 0 {
+			fmt.Fprint(w, lex.TBar)
+		}
+		c.PrintTo(w)
+	}
+}
+
+// Ctor is a constructor of a sum type, e.g.: 'Rect(w, h)'.
+// A constructor without fields is a value, e.g.: 'Nil'.
+// Otherwise it is a function that returns a value tagged with the constructor.
+type Ctor struct {
+	Name   *Ident // binds the constructor, Var filled in by resolve
+	Fields []string
+	Type   *TypeDef // declaration, which identifies the type; copies made by inlining keep the original
+}
+
+func (n *Ctor) PrintTo(w io.Writer) {
+	n.Name.PrintTo(w)
+	if len(n.Fields) > 0 {
+		fmt.Fprint(w, "(")
+		for i, f := range n.Fields {
+			if i != 0 {
+				fmt.Fprint(w, ", ")
+			}
+			fmt.Fprint(w, f)
+		}
+		fmt.Fprint(w, ")")
+	}
+}
+
+// CtorPat is a pattern that matches values built by a constructor, e.g.:
+// 	Circle(r)
+// 	Cons(x, Nil)
+type CtorPat struct {
+	Name string
+	Args []Pattern
+	Ctor *Ctor // filled in by resolve
+	Pos  se.Position
+}
+
+func (*CtorPat) pattern() {}
+
+func (n *CtorPat) PrintTo(w io.Writer) {
+	fmt.Fprint(w, n.Name)
+	if len(n.Args) > 0 {
+		printList(w, n.Args)
+	}
+}
@@ -10,7 +10,8 @@ type Var interface {
 }
 
 type Arg struct {
-	Index int
+	Index    int
+	Captured bool // captured by a nested lambda, set by the compiler
 }
 
 func (a *Arg) String() string {
@@ -18,7 +19,8 @@ func (a *Arg) String() string {
 }
 
 type LocVar struct {
-	Index int
+	Index    int
+	Captured bool // captured by a nested lambda, set by the compiler
 }
 
 func (l *LocVar) String() string {
@@ -0,0 +1,93 @@
+package ast
+
+// Walk traverses the AST rooted at n in source order.
+// It calls f for each node, and descends into the node's children
+// if f returns true.
+func Walk(n Node, f func(Node) bool) {
+	if n == nil || !f(n) {
+		return
+	}
+	switch n := n.(type) {
+	case *Assign:
+		Walk(n.LHS, f)
+		Walk(n.RHS, f)
+	case *Block:
+		for _, s := range n.Stmts {
+			Walk(s, f)
+		}
+	case *Call:
+		Walk(n.F, f)
+		for _, a := range n.Args {
+			Walk(a, f)
+		}
+	case *Case:
+		Walk(n.Pat, f)
+		if n.Guard != nil {
+			Walk(n.Guard, f)
+		}
+		Walk(n.Body, f)
+	case *Cond:
+		Walk(n.Test, f)
+		Walk(n.If, f)
+		Walk(n.Else, f)
+	case *Ctor:
+		Walk(n.Name, f)
+	case *CtorPat:
+		for _, a := range n.Args {
+			Walk(a, f)
+		}
+	case *Import:
+		Walk(n.Name, f)
+	case *Lambda:
+		for _, a := range n.Args {
+			Walk(a, f)
+		}
+		Walk(n.Body, f)
+	case *List:
+		for _, e := range n.Elems {
+			Walk(e, f)
+		}
+		if n.Tail != nil {
+			Walk(n.Tail, f)
+		}
+	case *ListPat:
+		for _, e := range n.Elems {
+			Walk(e, f)
+		}
+		if n.Tail != nil {
+			Walk(n.Tail, f)
+		}
+	case *Logic:
+		Walk(n.X, f)
+		Walk(n.Y, f)
+	case *Match:
+		for _, x := range n.X {
+			Walk(x, f)
+		}
+		for _, c := range n.Cases {
+			Walk(c, f)
+		}
+	case *Record:
+		for _, x := range n.Fields {
+			Walk(x, f)
+		}
+	case *RecordPat:
+		for _, x := range n.Fields {
+			Walk(x, f)
+		}
+	case *Select:
+		Walk(n.X, f)
+	case *Tuple:
+		for _, e := range n.Elems {
+			Walk(e, f)
+		}
+	case *TypeDef:
+		for _, c := range n.Ctors {
+			Walk(c, f)
+		}
+	case *Ident, *Lit, *Num, *Str, *Wildcard:
+		// no children
+	default:
+		panic(unhandled(n))
+	}
+}
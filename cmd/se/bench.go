@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"text/tabwriter"
+	"time"
+
+	"github.com/barnex/se-lang/eva"
+)
+
+// bench compiles and runs a file repeatedly,
+// and reports the time, allocations and steps per run:
+//
+//	se bench [-n runs] file.howl
+//
+// Steps are function calls with the tree backend,
+// and instructions with the bytecode VM (-vm).
+func bench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	n := fs.Int("n", 10, "number of runs")
+	name := oneFile("bench", parseArgs(fs, args))
+	if *n < 1 {
+		log.Fatal("bench: -n must be positive")
+	}
+
+	backend := "tree"
+	if *flagVM {
+		backend = "vm"
+	}
+
+	var compile func()
+	var exec func() int // returns the number of steps
+	if *flagVM {
+		var code *eva.Code
+		compile = func() { code = loadCode(name) }
+		exec = func() int {
+			_, steps, err := eva.RunSteps(code)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return steps
+		}
+	} else {
+		var prog eva.Prog
+		compile = func() {
+			var err error
+			prog, err = newLoader().CompileFile(name)
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		exec = func() int {
+			_, steps, err := eva.EvalSteps(prog)
+			if err != nil {
+				log.Fatal(err)
+			}
+			return steps
+		}
+	}
+
+	fmt.Printf("%v (%v, %v runs)\n", name, backend, *n)
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "\ttime/op\tallocs/op\tbytes/op\tsteps/op\t")
+	c := measure(1, func() { compile() })
+	fmt.Fprintf(w, "compile\t%v\t%v\t%v\t\t\n", c.time, c.allocs, c.bytes)
+	steps := 0
+	r := measure(*n, func() { steps = exec() })
+	fmt.Fprintf(w, "run\t%v\t%v\t%v\t%v\t\n", r.time, r.allocs, r.bytes, steps)
+	w.Flush()
+}
+
+// benchResult holds the time and allocations per run.
+type benchResult struct {
+	time          time.Duration
+	allocs, bytes uint64
+}
+
+// measure calls f n times, and returns the mean time and allocations per call.
+func measure(n int, f func()) benchResult {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	for i := 0; i < n; i++ {
+		f()
+	}
+	t := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return benchResult{
+		time:   (t / time.Duration(n)).Round(time.Microsecond),
+		allocs: (after.Mallocs - before.Mallocs) / uint64(n),
+		bytes:  (after.TotalAlloc - before.TotalAlloc) / uint64(n),
+	}
+}
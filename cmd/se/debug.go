@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/eva"
+)
+
+const debugHelp = `commands:
+	b [file:]line   set a breakpoint
+	d [file:]line   delete a breakpoint
+	c               continue until a breakpoint
+	s               step in: run until the next call
+	n               step over: run until the next call in this function
+	o               step out: run until the next call after this function returns
+	bt              print the call stack
+	p [name]        print a variable, or all variables, of the selected frame
+	f [n]           select frame n, or print the selected frame
+	q               quit
+`
+
+// debug runs a file in the interactive debugger, reading commands from stdin.
+func debug(args []string) {
+	name, err := filepath.Abs(oneFile("debug", args))
+	if err != nil {
+		log.Fatal(err)
+	}
+	l := newLoader()
+	l.NoOpt = true // keep calls and variables as in the source
+	prog, err := l.CompileFile(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db := &debugger{Debugger: eva.NewDebugger(prog), file: name, src: make(map[string][]string)}
+	defer db.Quit()
+	fmt.Printf("debugging %v, type h for help\n", args[0])
+	in := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Print("(debug) ")
+		if !in.Scan() {
+			fmt.Println()
+			return
+		}
+		if db.command(strings.Fields(in.Text())) {
+			return
+		}
+	}
+}
+
+type debugger struct {
+	*eva.Debugger
+	file  string              // the file being debugged
+	frame int                 // selected frame, 0 being the innermost
+	src   map[string][]string // source lines, by file
+}
+
+// command executes a debugger command, and returns true when the debugger should quit.
+func (db *debugger) command(cmd []string) bool {
+	if len(cmd) == 0 {
+		return false
+	}
+	arg := ""
+	if len(cmd) > 1 {
+		arg = cmd[1]
+	}
+	switch cmd[0] {
+	default:
+		fmt.Printf("unknown command %q, type h for help\n", cmd[0])
+	case "h", "help":
+		fmt.Print(debugHelp)
+	case "q", "quit":
+		return true
+	case "b", "break":
+		if file, line, ok := db.location(arg); ok {
+			db.SetBreakpoint(file, line)
+		}
+	case "d", "delete":
+		if file, line, ok := db.location(arg); ok {
+			lines := db.Breakpoints(file)
+			db.ClearBreakpoints(file)
+			for _, l := range lines {
+				if l != line {
+					db.SetBreakpoint(file, l)
+				}
+			}
+		}
+	case "c", "continue":
+		db.stopped(db.Continue())
+	case "s", "step":
+		db.stopped(db.StepIn())
+	case "n", "next":
+		db.stopped(db.StepOver())
+	case "o", "out":
+		db.stopped(db.StepOut())
+	case "bt", "backtrace":
+		for i, f := range db.Frames() {
+			fmt.Printf("#%v %v at %v\n", i, f.Name, f.Pos)
+		}
+	case "f", "frame":
+		frames := db.Frames()
+		if arg != "" {
+			i, err := strconv.Atoi(arg)
+			if err != nil || i < 0 || i >= len(frames) {
+				fmt.Printf("no frame %v\n", arg)
+				return false
+			}
+			db.frame = i
+		}
+		if db.frame < len(frames) {
+			db.printFrame(db.frame, frames[db.frame])
+		}
+	case "p", "print":
+		frames := db.Frames()
+		if db.frame >= len(frames) {
+			fmt.Println("program not running")
+			return false
+		}
+		for _, v := range frames[db.frame].Vars {
+			if arg == "" || v.Name == arg {
+				fmt.Printf("%v = %v (%v)\n", v.Name, v.ValueString(), v.Kind)
+			}
+		}
+		if arg != "" {
+			if _, ok := db.Lookup(db.frame, arg); !ok {
+				fmt.Printf("undefined: %v\n", arg)
+			}
+		}
+	}
+	return false
+}
+
+// stopped reports why the program paused, and selects the innermost frame.
+func (db *debugger) stopped(s eva.Stop) {
+	db.frame = 0
+	if s.Reason == eva.Exited {
+		if s.Err != nil {
+			fmt.Println("program failed:", s.Err)
+		} else {
+			fmt.Println("program exited:", s.Value)
+		}
+		return
+	}
+	fmt.Printf("%v: ", s.Reason)
+	db.printFrame(0, db.Frames()[0])
+}
+
+// printFrame prints a frame's function, position and source line.
+func (db *debugger) printFrame(i int, f eva.Frame) {
+	fmt.Printf("#%v %v at %v\n", i, f.Name, f.Pos)
+	if line := db.line(f.Pos); line != "" {
+		fmt.Printf("%5d\t%v\n", f.Pos.Line, line)
+	}
+}
+
+// line returns the source line at pos, if available.
+func (db *debugger) line(pos se.Position) string {
+	lines, ok := db.src[pos.Filename]
+	if !ok {
+		b, _ := ioutil.ReadFile(pos.Filename)
+		lines = strings.Split(string(b), "\n")
+		db.src[pos.Filename] = lines
+	}
+	if pos.Line < 1 || pos.Line > len(lines) {
+		return ""
+	}
+	return strings.TrimSpace(lines[pos.Line-1])
+}
+
+// location parses a breakpoint location: line, or file:line.
+func (db *debugger) location(arg string) (file string, line int, ok bool) {
+	file = db.file
+	if i := strings.LastIndex(arg, ":"); i >= 0 {
+		f, err := filepath.Abs(arg[:i])
+		if err != nil {
+			fmt.Println(err)
+			return "", 0, false
+		}
+		file, arg = f, arg[i+1:]
+	}
+	line, err := strconv.Atoi(arg)
+	if err != nil || line < 1 {
+		fmt.Printf("bad location %q, want line or file:line\n", arg)
+		return "", 0, false
+	}
+	return file, line, true
+}
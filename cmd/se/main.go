@@ -5,12 +5,52 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/dap"
 	"github.com/barnex/se-lang/eva"
+	"github.com/barnex/se-lang/gogen"
+	"github.com/barnex/se-lang/lsp"
 )
 
+var (
+	flagPath  = flag.String("path", os.Getenv("SEPATH"), "list of directories to search for imports")
+	flagVM    = flag.Bool("vm", false, "run files on the bytecode VM")
+	flagNoOpt = flag.Bool("noopt", false, "disable optimization")
+)
+
+// commands are the sub-commands, called with the remaining arguments.
+var commands = map[string]func(args []string){
+	"bench":  bench,
+	"build":  build,
+	"dap":    serveDAP,
+	"debug":  debug,
+	"disasm": disasm,
+	"gogen":  generate,
+	"lsp":    serveLSP,
+	"run":    run,
+	"test":   test,
+}
+
+// Usage:
+//
+//	se                              start a REPL
+//	se file.howl                    run a file
+//	se disasm file.howl             print the compiled file (bytecode with -vm, or of a .sec file)
+//	se build file.howl [-o out.sec] compile a file to bytecode
+//	se bench file.howl [-n runs]    report time, allocations and steps of running a file
+//	se debug file.howl              run a file in the interactive debugger
+//	se dap                          serve the Debug Adapter Protocol on stdin and stdout
+//	se gogen file.howl [-o out.go]  translate a file to a Go program
+//	se lsp                          serve the Language Server Protocol on stdin and stdout
+//	se run file.sec                 run bytecode, or a source file on the VM
+//	se run -profile out.txt file.howl  run a file, writing a profile to out.txt and out.pb.gz
+//	se test [-v] [-run regexp] dir  run the test_* functions in the directory's *_test.howl files
 func main() {
 	log.SetFlags(0)
 	flag.Parse()
@@ -20,6 +60,11 @@ func main() {
 		return
 	}
 
+	if cmd, ok := commands[flag.Arg(0)]; ok {
+		cmd(flag.Args()[1:])
+		return
+	}
+
 	if flag.NArg() > 1 {
 		log.Fatal("too many input files")
 		os.Exit(1)
@@ -28,14 +73,186 @@ func main() {
 	evalFile(flag.Arg(0))
 }
 
-func evalFile(name string) {
+// parseArgs parses the flags in args, which may be mixed with other arguments,
+// and returns the other arguments.
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var rest []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return rest
+		}
+		rest = append(rest, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// oneFile returns the only file in args, or exits with a usage message.
+func oneFile(cmd string, args []string) string {
+	if len(args) != 1 {
+		log.Fatalf("usage: se %v file", cmd)
+	}
+	return args[0]
+}
+
+func disasm(args []string) {
+	name := oneFile("disasm", args)
+	if *flagVM || filepath.Ext(name) == eva.CodeExt {
+		fmt.Print(loadCode(name))
+		return
+	}
+	prog, err := newLoader().CompileFile(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(eva.Disasm(prog))
+}
+
+func build(args []string) {
+	fs := flag.NewFlagSet("build", flag.ExitOnError)
+	out := fs.String("o", "", "output file (default: input file with extension "+eva.CodeExt+")")
+	name := oneFile("build", parseArgs(fs, args))
+	if *out == "" {
+		*out = strings.TrimSuffix(name, filepath.Ext(name)) + eva.CodeExt
+	}
+
+	code := loadCode(name)
+	f, err := os.Create(*out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := eva.EncodeCode(f, code); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func generate(args []string) {
+	fs := flag.NewFlagSet("gogen", flag.ExitOnError)
+	out := fs.String("o", "", "output file (default: stdout)")
+	name := oneFile("gogen", parseArgs(fs, args))
+
+	root, err := newLoader().ResolveFile(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	w := os.Stdout
+	if *out != "" {
+		w, err = os.Create(*out)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if err := gogen.Generate(w, root); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serveLSP(args []string) {
+	if len(args) != 0 {
+		log.Fatal("usage: se lsp")
+	}
+	if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func serveDAP(args []string) {
+	if len(args) != 0 {
+		log.Fatal("usage: se dap")
+	}
+	if err := dap.Serve(os.Stdin, os.Stdout, newLoader()); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(args []string) {
+	fs := flag.NewFlagSet("run", flag.ExitOnError)
+	prof := fs.String("profile", "", "write a report of the steps per function and line to this file, and a pprof profile next to it")
+	name := oneFile("run", parseArgs(fs, args))
+	if *prof != "" {
+		profileFile(name, *prof)
+		return
+	}
+	runFile(name)
+}
+
+// profileFile runs a source file on the tree backend, unoptimized, counting its steps.
+// It writes a flat report to out, and a profile for 'go tool pprof'
+// to out with extension .pb.gz.
+func profileFile(name, out string) {
+	if filepath.Ext(name) == eva.CodeExt {
+		log.Fatal("run: -profile needs a source file")
+	}
+	l := newLoader()
+	l.NoOpt = true // keep calls as in the source, inlined functions would not be counted
+	prog, err := l.CompileFile(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	v, prof, runErr := eva.EvalProfile(prog)
+
+	write := func(file string, w func(io.Writer) error) {
+		f, err := os.Create(file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := w(f); err != nil {
+			log.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	write(out, prof.WriteReport)
+	write(strings.TrimSuffix(out, filepath.Ext(out))+".pb.gz", prof.WritePprof)
+
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
+	fmt.Printf("%v\n", v)
+}
+
+// loadCode returns the bytecode in a file built by 'se build',
+// or compiles a source file to bytecode.
+func loadCode(name string) *eva.Code {
+	if filepath.Ext(name) != eva.CodeExt {
+		code, err := newLoader().CompileCodeFile(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return code
+	}
 	f, err := os.Open(name)
 	if err != nil {
 		log.Fatal(err)
 	}
 	defer f.Close()
+	code, err := eva.DecodeCode(bufio.NewReader(f))
+	if err != nil {
+		log.Fatalf("%v: %v", name, err)
+	}
+	return code
+}
 
-	prog, err := eva.Compile(bufio.NewReader(f))
+func newLoader() *eva.Loader {
+	l := eva.NewLoader(filepath.SplitList(*flagPath)...)
+	l.Warn = func(d ast.Diag) { fmt.Fprintln(os.Stderr, d) }
+	l.NoOpt = *flagNoOpt
+	return l
+}
+
+func evalFile(name string) {
+	if *flagVM {
+		runFile(name)
+		return
+	}
+	prog, err := newLoader().CompileFile(name)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -43,10 +260,19 @@ func evalFile(name string) {
 	if err != nil {
 		log.Fatal(err)
 	}
-	fmt.Printf("%#v\n", v)
+	fmt.Printf("%v\n", v)
+}
+
+func runFile(name string) {
+	v, err := eva.Run(loadCode(name))
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%v\n", v)
 }
 
 func repl() {
+	loader := newLoader()
 	for {
 		fmt.Print("> ")
 		in := bufio.NewReader(os.Stdin)
@@ -55,7 +281,12 @@ func repl() {
 			return // EOF
 		}
 
-		prog, err := eva.Compile(bytes.NewReader(src))
+		n, err := ast.ParseProgram(bytes.NewReader(src))
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		prog, err := loader.CompileAST(n, ".")
 		if err != nil {
 			fmt.Println(err)
 			continue
@@ -64,7 +295,7 @@ func repl() {
 		if err != nil {
 			fmt.Println(err)
 		} else {
-			fmt.Printf("%#v\n", v)
+			fmt.Printf("%v\n", v)
 		}
 	}
 }
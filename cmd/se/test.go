@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/barnex/se-lang/eva"
+)
+
+// test runs the test functions in test files, and exits with status 1 if any fails:
+//
+//	se test [-v] [-run regexp] [dir | dir/... | file_test.howl]...
+//
+// A directory stands for its *_test.howl files, dir/... also for those in its subdirectories.
+// Without arguments, the current directory is tested.
+func test(args []string) {
+	fs := flag.NewFlagSet("test", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "also report passing tests")
+	run := fs.String("run", "", "only run tests whose name matches this regular expression")
+	args = parseArgs(fs, args)
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	var match func(string) bool
+	if *run != "" {
+		re, err := regexp.Compile(*run)
+		if err != nil {
+			log.Fatal(err)
+		}
+		match = re.MatchString
+	}
+
+	l := newLoader()
+	failed := false
+	for _, arg := range args {
+		for _, dir := range testDirs(arg) {
+			files := testFiles(dir)
+			if len(files) == 0 {
+				fmt.Printf("?   \t%v\t[no test files]\n", dir)
+			}
+			for _, f := range files {
+				if !testFile(l, f, *verbose, match) {
+					failed = true
+				}
+			}
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// testDirs returns the directories or file denoted by a command line argument.
+func testDirs(arg string) []string {
+	dir := strings.TrimSuffix(arg, "/...")
+	if dir == arg {
+		return []string{arg}
+	}
+	var dirs []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			dirs = append(dirs, path)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return dirs
+}
+
+// testFiles returns the test files in a directory, sorted,
+// or the argument itself if it is not a directory.
+func testFiles(dir string) []string {
+	if info, err := os.Stat(dir); err != nil {
+		log.Fatal(err)
+	} else if !info.IsDir() {
+		return []string{dir}
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*"+eva.TestSuffix))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// testFile runs the tests in a file, reporting like 'go test'.
+// It returns false if any test failed, or the file did not compile.
+func testFile(l *eva.Loader, file string, verbose bool, match func(string) bool) bool {
+	start := time.Now()
+	results, err := l.RunTests(file, match)
+	if err != nil {
+		fmt.Println(err)
+		fmt.Printf("FAIL\t%v [setup failed]\n", file)
+		return false
+	}
+
+	ok := true
+	for _, r := range results {
+		if verbose {
+			fmt.Printf("=== RUN   %v\n", r.Name)
+		}
+		switch {
+		case r.Err != nil:
+			ok = false
+			fmt.Printf("--- FAIL: %v (%.2fs)\n", r.Name, r.Duration.Seconds())
+			fmt.Printf("    %v\n", r.Err)
+		case verbose:
+			fmt.Printf("--- PASS: %v (%.2fs)\n", r.Name, r.Duration.Seconds())
+		}
+	}
+
+	elapsed := fmt.Sprintf("%.3fs", time.Since(start).Seconds())
+	switch {
+	case !ok:
+		fmt.Printf("FAIL\t%v\t%v\n", file, elapsed)
+	case len(results) == 0:
+		fmt.Printf("ok  \t%v\t%v [no tests to run]\n", file, elapsed)
+	default:
+		if verbose {
+			fmt.Println("PASS")
+		}
+		fmt.Printf("ok  \t%v\t%v\n", file, elapsed)
+	}
+	return ok
+}
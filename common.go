@@ -5,10 +5,14 @@ The real functionality is implemented in these packages:
 
 	lex: Lexical scanner for SE source text.
 	ast: Parser and Abstract Syntax Tree
+	format: Source code formatter
 	typ: Typechekcer
 	std: Standard library
+	ir: Closure-converted intermediate representation
 	eva: Intermediate Representation & evaluator
-	jit: Just-In-Time compiler
+	gogen: Ahead-of-time compiler to Go source
+	lsp: Language Server Protocol server for editors
+	dap: Debug Adapter Protocol server for editors
 */
 package se
 
@@ -0,0 +1,391 @@
+/*
+Package dap implements a Debug Adapter Protocol server for se-lang,
+used by editors through 'se dap'.
+
+It runs a single program on the eva.Debugger, as a single thread.
+The program pauses before function calls, so that is where
+breakpoints hit and steps end.
+Each stack frame has the scopes Arguments, Locals and Captured,
+holding the frame's stack slots and the variables captured by its closure.
+*/
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/barnex/se-lang/eva"
+)
+
+// Serve reads requests from r and writes responses and events to w,
+// until the client disconnects or closes r.
+// Programs are compiled by l, without optimization.
+func Serve(r io.Reader, w io.Writer, l *eva.Loader) error {
+	l.NoOpt = true // keep calls and variables as in the source
+	s := &server{
+		r:      bufio.NewReader(r),
+		w:      w,
+		loader: l,
+		bps:    make(map[string][]int),
+	}
+	defer func() {
+		if s.dbg != nil {
+			s.dbg.Quit()
+		}
+	}()
+	for {
+		req, err := readRequest(s.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.handle(req); err != nil {
+			return err
+		}
+		if req.Command == "disconnect" {
+			return nil
+		}
+	}
+}
+
+const threadID = 1 // the only thread
+
+type server struct {
+	r      *bufio.Reader
+	w      io.Writer
+	seq    int // sequence number of the last message sent
+	loader *eva.Loader
+
+	dbg        *eva.Debugger    // set by launch
+	launch     LaunchArguments  // arguments of launch
+	bps        map[string][]int // breakpoint lines, by absolute file name
+	configured bool             // configurationDone received
+	running    bool             // the program has been started
+	entry      bool             // the program is started with stopOnEntry
+	resume     func() eva.Stop  // resumes the program after responding to a request
+}
+
+// readRequest reads a request, preceded by its Content-Length header.
+func readRequest(r *bufio.Reader) (*request, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			length, err = strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("dap: bad header: %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("dap: missing Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	var req request
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("dap: %v", err)
+	}
+	return &req, nil
+}
+
+// send writes a response or event, preceded by its Content-Length header.
+func (s *server) send(msg interface{}) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(s.w, "Content-Length: %v\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = s.w.Write(body)
+	return err
+}
+
+func (s *server) event(name string, body interface{}) error {
+	s.seq++
+	return s.send(&event{Seq: s.seq, Type: "event", Event: name, Body: body})
+}
+
+// handle answers a request, and then resumes the program if the request asked for it.
+// Only errors writing to the client are returned,
+// failing requests are reported to the client.
+func (s *server) handle(req *request) error {
+	s.seq++
+	resp := &response{Seq: s.seq, Type: "response", RequestSeq: req.Seq, Command: req.Command, Success: true}
+	body, err := s.request(req.Command, req.Arguments)
+	if err != nil {
+		resp.Success = false
+		resp.Message = err.Error()
+	} else {
+		resp.Body = body
+	}
+	if err := s.send(resp); err != nil {
+		return err
+	}
+
+	switch {
+	case req.Command == "initialize" && err == nil:
+		return s.event("initialized", nil)
+	case s.resume != nil:
+		resume := s.resume
+		s.resume = nil
+		return s.stopped(resume())
+	}
+	return nil
+}
+
+func (s *server) request(command string, args json.RawMessage) (interface{}, error) {
+	switch command {
+	default:
+		return nil, fmt.Errorf("unsupported request: %v", command)
+	case "initialize":
+		return Capabilities{SupportsConfigurationDoneRequest: true}, nil
+	case "launch":
+		if s.dbg != nil {
+			return nil, fmt.Errorf("already launched")
+		}
+		if err := decode(args, &s.launch); err != nil {
+			return nil, err
+		}
+		prog, err := s.loader.CompileFile(s.launch.Program)
+		if err != nil {
+			return nil, err
+		}
+		s.dbg = eva.NewDebugger(prog)
+		s.setBreakpoints()
+		s.start()
+		return nil, nil
+	case "setBreakpoints":
+		var a SetBreakpointsArguments
+		if err := decode(args, &a); err != nil {
+			return nil, err
+		}
+		file, err := filepath.Abs(a.Source.Path)
+		if err != nil {
+			return nil, err
+		}
+		var lines []int
+		bps := make([]Breakpoint, 0, len(a.Breakpoints))
+		for _, b := range a.Breakpoints {
+			lines = append(lines, b.Line)
+			bps = append(bps, Breakpoint{Verified: true, Line: b.Line})
+		}
+		s.bps[file] = lines
+		s.setBreakpoints()
+		return map[string]interface{}{"breakpoints": bps}, nil
+	case "configurationDone":
+		s.configured = true
+		s.start()
+		return nil, nil
+	case "threads":
+		return map[string]interface{}{"threads": []Thread{{ID: threadID, Name: "main"}}}, nil
+	case "stackTrace":
+		var a StackTraceArguments
+		if err := decode(args, &a); err != nil {
+			return nil, err
+		}
+		frames := s.frames()
+		all := len(frames)
+		if a.StartFrame > 0 && a.StartFrame <= len(frames) {
+			frames = frames[a.StartFrame:]
+		}
+		if a.Levels > 0 && a.Levels < len(frames) {
+			frames = frames[:a.Levels]
+		}
+		return map[string]interface{}{"stackFrames": frames, "totalFrames": all}, nil
+	case "scopes":
+		var a ScopesArguments
+		if err := decode(args, &a); err != nil {
+			return nil, err
+		}
+		scopes, err := s.scopes(a.FrameID)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"scopes": scopes}, nil
+	case "variables":
+		var a VariablesArguments
+		if err := decode(args, &a); err != nil {
+			return nil, err
+		}
+		vars, err := s.variables(a.VariablesReference)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"variables": vars}, nil
+	case "continue":
+		return map[string]interface{}{"allThreadsContinued": true}, s.step((*eva.Debugger).Continue)
+	case "next":
+		return nil, s.step((*eva.Debugger).StepOver)
+	case "stepIn":
+		return nil, s.step((*eva.Debugger).StepIn)
+	case "stepOut":
+		return nil, s.step((*eva.Debugger).StepOut)
+	case "disconnect":
+		return nil, nil
+	}
+}
+
+func decode(args json.RawMessage, v interface{}) error {
+	if len(args) == 0 {
+		return nil
+	}
+	return json.Unmarshal(args, v)
+}
+
+// start starts the program once it is launched and configured.
+func (s *server) start() {
+	if s.dbg == nil || !s.configured || s.running {
+		return
+	}
+	s.running = true
+	switch {
+	case s.launch.StopOnEntry && !s.launch.NoDebug:
+		s.entry = true
+		s.resume = s.dbg.StepIn
+	default:
+		s.resume = s.dbg.Continue
+	}
+}
+
+// step resumes the paused program with f, after responding to the request.
+func (s *server) step(f func(*eva.Debugger) eva.Stop) error {
+	if !s.running || s.dbg.Exited() {
+		return fmt.Errorf("program is not paused")
+	}
+	s.resume = func() eva.Stop { return f(s.dbg) }
+	return nil
+}
+
+// stopped tells the client that the program paused or ended.
+func (s *server) stopped(st eva.Stop) error {
+	if st.Reason != eva.Exited {
+		reason := st.Reason.String()
+		if s.entry {
+			reason = "entry"
+			s.entry = false
+		}
+		return s.event("stopped", StoppedEvent{Reason: reason, ThreadID: threadID, AllThreadsStopped: true})
+	}
+
+	out, code := OutputEvent{Category: "stdout", Output: fmt.Sprintln(st.Value)}, 0
+	if st.Err != nil {
+		out, code = OutputEvent{Category: "stderr", Output: fmt.Sprintln(st.Err)}, 1
+	}
+	if err := s.event("output", out); err != nil {
+		return err
+	}
+	if err := s.event("exited", ExitedEvent{ExitCode: code}); err != nil {
+		return err
+	}
+	return s.event("terminated", nil)
+}
+
+// setBreakpoints passes the breakpoints to the debugger.
+func (s *server) setBreakpoints() {
+	if s.dbg == nil || s.launch.NoDebug {
+		return
+	}
+	for file, lines := range s.bps {
+		s.dbg.ClearBreakpoints(file)
+		for _, l := range lines {
+			s.dbg.SetBreakpoint(file, l)
+		}
+	}
+}
+
+// frames returns the stack frames of the paused program, innermost first.
+// Frame IDs count from 1, for the innermost frame.
+func (s *server) frames() []StackFrame {
+	frames := []StackFrame{}
+	if s.dbg == nil {
+		return frames
+	}
+	for i, f := range s.dbg.Frames() {
+		frames = append(frames, StackFrame{
+			ID:     i + 1,
+			Name:   f.Name,
+			Source: Source{Name: filepath.Base(f.Pos.Filename), Path: f.Pos.Filename},
+			Line:   f.Pos.Line,
+			Column: f.Pos.Column,
+		})
+	}
+	return frames
+}
+
+// scopeNames are the names of the scopes holding each kind of variable.
+var scopeNames = map[eva.VarKind]string{
+	eva.ArgVar:      "Arguments",
+	eva.LocalVar:    "Locals",
+	eva.CapturedVar: "Captured",
+}
+
+// numKinds is the number of variable kinds, and scopes per frame.
+const numKinds = 3
+
+// frame returns the i'th frame of the paused program, 0 being the innermost.
+func (s *server) frame(i int) (eva.Frame, error) {
+	var frames []eva.Frame
+	if s.dbg != nil {
+		frames = s.dbg.Frames()
+	}
+	if i < 0 || i >= len(frames) {
+		return eva.Frame{}, fmt.Errorf("no frame %v", i+1)
+	}
+	return frames[i], nil
+}
+
+// scopes returns the non-empty scopes of a frame.
+// Their variable references encode the frame and the kind of variables.
+func (s *server) scopes(frameID int) ([]Scope, error) {
+	f, err := s.frame(frameID - 1)
+	if err != nil {
+		return nil, err
+	}
+	scopes := []Scope{}
+	for k := eva.ArgVar; k <= eva.CapturedVar; k++ {
+		for _, v := range f.Vars {
+			if v.Kind == k {
+				scopes = append(scopes, Scope{Name: scopeNames[k], VariablesReference: (frameID-1)*numKinds + int(k)})
+				break
+			}
+		}
+	}
+	return scopes, nil
+}
+
+// variables returns the variables in a scope returned by scopes.
+func (s *server) variables(ref int) ([]Variable, error) {
+	if ref < 1 {
+		return nil, fmt.Errorf("bad variables reference: %v", ref)
+	}
+	f, err := s.frame((ref - 1) / numKinds)
+	if err != nil {
+		return nil, err
+	}
+	kind := eva.VarKind((ref-1)%numKinds + 1)
+	vars := []Variable{}
+	for _, v := range f.Vars {
+		if v.Kind == kind {
+			vars = append(vars, Variable{Name: v.Name, Value: v.ValueString()})
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,139 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/eva"
+)
+
+// TestScripts drives the server with the scripted sessions in testdata/*.txt.
+// Lines starting with -> are requests sent to the server,
+// lines starting with <- are the expected responses and events,
+// of which only the given fields are checked.
+func TestScripts(t *testing.T) {
+	scripts, err := filepath.Glob("testdata/*.txt")
+	if err != nil || len(scripts) == 0 {
+		t.Fatal("no scripts", err)
+	}
+	for _, name := range scripts {
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			script, err := ioutil.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runScript(t, string(script))
+		})
+	}
+}
+
+func runScript(t *testing.T, script string) {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- Serve(inR, outW, eva.NewLoader())
+		outW.Close()
+	}()
+	r := bufio.NewReader(outR)
+
+	seq := 0
+	for i, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		pos := fmt.Sprint("line ", i+1)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "->"):
+			var req map[string]interface{}
+			if err := json.Unmarshal([]byte(line[2:]), &req); err != nil {
+				t.Fatal(pos, err)
+			}
+			seq++
+			req["seq"] = seq
+			req["type"] = "request"
+			body, _ := json.Marshal(req)
+			if _, err := fmt.Fprintf(inW, "Content-Length: %v\r\n\r\n%s", len(body), body); err != nil {
+				t.Fatal(pos, err)
+			}
+		case strings.HasPrefix(line, "<-"):
+			var want map[string]interface{}
+			if err := json.Unmarshal([]byte(line[2:]), &want); err != nil {
+				t.Fatal(pos, err)
+			}
+			if want["type"] == "response" {
+				want["request_seq"] = float64(seq)
+			}
+			have := recv(t, r)
+			if err := match(want, have); err != nil {
+				t.Fatalf("%v: %v\nhave: %v", pos, err, have)
+			}
+		default:
+			t.Fatalf("%v: bad script line: %q", pos, line)
+		}
+	}
+
+	inW.Close()
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+	if extra, err := ioutil.ReadAll(r); err != nil || len(extra) != 0 {
+		t.Errorf("unexpected messages: %s", extra)
+	}
+}
+
+// recv reads a message from the server.
+func recv(t *testing.T, r *bufio.Reader) interface{} {
+	t.Helper()
+	var length int
+	if _, err := fmt.Fscanf(r, "Content-Length: %d\r\n\r\n", &length); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		t.Fatal(err)
+	}
+	var msg interface{}
+	if err := json.Unmarshal(body, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
+
+// match returns an error if have does not contain the fields in want.
+// Lists must have the same length.
+func match(want, have interface{}) error {
+	switch want := want.(type) {
+	default:
+		if want != have {
+			return fmt.Errorf("have %v, want %v", have, want)
+		}
+	case map[string]interface{}:
+		have, ok := have.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("have %v, want object", have)
+		}
+		for k, w := range want {
+			if err := match(w, have[k]); err != nil {
+				return fmt.Errorf("%v: %v", k, err)
+			}
+		}
+	case []interface{}:
+		have, ok := have.([]interface{})
+		if !ok || len(have) != len(want) {
+			return fmt.Errorf("have %v, want %v elements", have, len(want))
+		}
+		for i := range want {
+			if err := match(want[i], have[i]); err != nil {
+				return fmt.Errorf("%v: %v", i, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package eva
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+)
+
+// -------- TypeDef
+
+// compileTypeDef returns assignments that bind each constructor
+// to its value (no fields) or constructor function.
+func compileTypeDef(n *ast.TypeDef) []Assign {
+	var init []Assign
+	for _, c := range n.Ctors {
+		var v Value
+		if len(c.Fields) == 0 {
+			v = &Tagged{Type: c.Type, Ctor: c.Name.Name}
+		} else {
+			v = &Constructor{Type: c.Type, Name: c.Name.Name, Arity: len(c.Fields)}
+		}
+		init = append(init, Assign{
+			LHS: compileLocVar(c.Name.Var.(*ast.LocVar)),
+			RHS: &Const{v},
+		})
+	}
+	return init
+}
+
+// Tagged is a value of a sum type, e.g.: Rect(1, 2).
+// Its type is identified by its declaration, not by its name:
+// types declared in different blocks or modules are different, even with the same name.
+type Tagged struct {
+	Type   *ast.TypeDef
+	Ctor   string
+	Fields []Value
+}
+
+func (t *Tagged) String() string {
+	if len(t.Fields) == 0 {
+		return t.Ctor
+	}
+	var buf bytes.Buffer
+	fmt.Fprint(&buf, t.Ctor, "(")
+	for i, f := range t.Fields {
+		if i != 0 {
+			fmt.Fprint(&buf, ", ")
+		}
+		fmt.Fprint(&buf, f)
+	}
+	fmt.Fprint(&buf, ")")
+	return buf.String()
+}
+
+// Constructor is a function that returns a Tagged value with its arguments as fields.
+type Constructor struct {
+	Type  *ast.TypeDef
+	Name  string
+	Arity int
+}
+
+var _ Applier = (*Constructor)(nil)
+
+func (c *Constructor) Apply(m *Machine) {
+	t := &Tagged{Type: c.Type, Ctor: c.Name, Fields: make([]Value, c.Arity)}
+	for i := range t.Fields {
+		t.Fields[i] = m.FromSP(-1 - i)
+	}
+	m.SetRA(t)
+}
+
+func (c *Constructor) String() string {
+	return c.Name
+}
+
+// equal compares values, structurally in case of Tagged values, lists and records.
+// Built-in functions cannot be compared.
+func equal(a, b Value) bool {
+	if !canCompare(a) || !canCompare(b) {
+		panic(se.Errorf("cannot compare built-in functions"))
+	}
+	switch a := a.(type) {
+	case *Tagged:
+		b, ok := b.(*Tagged)
+		return ok && a.equal(b)
+	case *List:
+		b, ok := b.(*List)
+		return ok && a.equal(b)
+	case *Record:
+		b, ok := b.(*Record)
+		return ok && a.equal(b)
+	default:
+		return a == b
+	}
+}
+
+func (t *Tagged) equal(b *Tagged) bool {
+	if t.Type != b.Type || t.Ctor != b.Ctor || len(t.Fields) != len(b.Fields) {
+		return false
+	}
+	for i := range t.Fields {
+		if !equal(t.Fields[i], b.Fields[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// canCompare returns true if v can be compared with ==,
+// which panics for Go func values, like those of built-in functions.
+func canCompare(v Value) bool {
+	return v == nil || reflect.TypeOf(v).Comparable()
+}
+
+// -------- CtorPat
+
+type ctorPat struct {
+	Type *ast.TypeDef
+	Ctor string
+	Args []Pattern
+}
+
+func compileCtorPat(n *ast.CtorPat) Pattern {
+	p := &ctorPat{Type: n.Ctor.Type, Ctor: n.Name}
+	for _, a := range n.Args {
+		p.Args = append(p.Args, compilePattern(a))
+	}
+	return p
+}
+
+func (p *ctorPat) Match(m *Machine, v Value) bool {
+	t, ok := v.(*Tagged)
+	if !ok || t.Type != p.Type || t.Ctor != p.Ctor {
+		return false
+	}
+	for i, a := range p.Args {
+		if !a.Match(m, t.Fields[i]) {
+			return false
+		}
+	}
+	return true
+}
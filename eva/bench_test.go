@@ -0,0 +1,110 @@
+package eva
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/internal/bench"
+)
+
+// Benchmarks for the stages after parsing, and evaluation by every backend.
+// Lexing and parsing are benchmarked in packages lex and ast.
+
+func BenchmarkResolve(b *testing.B) {
+	for _, p := range bench.Programs(b) {
+		b.Run(p.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				n := parse(b, p.Src) // resolve modifies the AST
+				b.StartTimer()
+				if _, err := NewLoader().ResolveAST(n, "."); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkCompile(b *testing.B) {
+	for _, p := range bench.Programs(b) {
+		b.Run("tree/"+p.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Compile(strings.NewReader(p.Src)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run("vm/"+p.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := CompileCode(strings.NewReader(p.Src)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkEval(b *testing.B) {
+	for _, p := range bench.Programs(b) {
+		prog, err := Compile(strings.NewReader(p.Src))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run("tree/"+p.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Eval(prog); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		code, err := CompileCode(strings.NewReader(p.Src))
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run("vm/"+p.Name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Run(code); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+// BenchmarkVMLocals shows the cost of boxing: the VM only boxes locals captured by a closure.
+// The programs only differ in whether the locals a and b are captured by g.
+func BenchmarkVMLocals(b *testing.B) {
+	progs := []struct{ name, src string }{
+		{"unboxed", `f = n -> {a = n + 1; b = a * 2; g = x -> x + 1; n <= 0? g(a + b): f(n - 1)}; f(1000)`},
+		{"boxed", `f = n -> {a = n + 1; b = a * 2; g = x -> x + a + b; n <= 0? g(0): f(n - 1)}; f(1000)`},
+	}
+	for _, p := range progs {
+		code, err := unoptimized().CompileCode(parse(b, p.src), ".") // not inlined
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run(p.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := Run(code); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func parse(b *testing.B, src string) ast.Node {
+	n, err := ast.ParseProgram(strings.NewReader(src))
+	if err != nil {
+		b.Fatal(err)
+	}
+	return n
+}
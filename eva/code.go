@@ -0,0 +1,450 @@
+package eva
+
+import (
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/ir"
+)
+
+// Code is a program compiled to bytecode, to be run by a VM.
+// It is an alternative to the tree of Prog values returned by Compile.
+type Code struct {
+	Funcs  []*Func // Funcs[0] is the entry point, without arguments
+	Consts []Value
+}
+
+// Func is the bytecode of a function.
+// Locals are stored unboxed, except for those in Boxed and CapDst,
+// which are shared with closures.
+type Func struct {
+	NumArgs   int
+	NumLocals int
+	Boxed     []int // locals captured by closures, given a new box on entry
+	CapDst    []int // locals where captured variables are stored
+	Instrs    []Instr
+}
+
+// boxed returns true if local l holds a Box.
+func (f *Func) boxed(l int) bool {
+	for _, b := range f.Boxed {
+		if b == l {
+			return true
+		}
+	}
+	for _, d := range f.CapDst {
+		if d == l {
+			return true
+		}
+	}
+	return false
+}
+
+// Instr is a bytecode instruction.
+// The meaning of the operands A and B depends on the opcode.
+type Instr struct {
+	Op   Op
+	A, B int
+}
+
+// Op is a bytecode opcode.
+type Op uint8
+
+const (
+	OpConst      Op = iota // push Consts[A]
+	OpArg                  // push argument A
+	OpLoad                 // push the value of local A
+	OpStore                // pop into local A
+	OpLoadBox              // push the value in the box of local A
+	OpStoreBox             // pop into the box of local A
+	OpBoxLocal             // push the box of local A, to be captured
+	OpClosure              // pop the captured boxes of Funcs[A], push a closure
+	OpCall                 // pop a function and A arguments, pushed last to first, push the result
+	OpRet                  // return the top of the stack
+	OpJump                 // jump to A
+	OpJumpIf               // pop, jump to A if true
+	OpJumpIfNot            // pop, jump to A if false
+	OpDup                  // push the top of the stack
+	OpPop                  // pop and discard
+	OpField                // pop a tagged value, push its field A
+	OpMatchLit             // pop, jump to B if not equal to Consts[A]
+	OpMatchCtor            // pop, jump to B if not tagged like Consts[A]
+	OpNoMatch              // pop A values, fail to match them
+	OpExport               // push a module holding the locals exported by Consts[A]
+	OpSelect               // pop a module or record, push its member named Consts[A]
+	OpImport               // push the module returned by Funcs[A], calling it on the first import only
+	OpList                 // pop A elements, pushed last to first, and a tail list if B is 1, push them as a list
+	OpRecord               // pop the fields named Consts[A], pushed last to first, push a record
+	OpMatchCons            // pop, jump to B if not a non-empty list
+	OpMatchNil             // pop, jump to B if not an empty list
+	OpHead                 // pop a non-empty list, push its first element
+	OpTail                 // pop a non-empty list, push the list without its first element
+	OpMatchField           // pop, jump to B if not a record with a field named Consts[A]
+)
+
+var opString = map[Op]string{
+	OpConst:      "const",
+	OpArg:        "arg",
+	OpLoad:       "load",
+	OpStore:      "store",
+	OpLoadBox:    "loadbox",
+	OpStoreBox:   "storebox",
+	OpBoxLocal:   "boxlocal",
+	OpClosure:    "closure",
+	OpCall:       "call",
+	OpRet:        "ret",
+	OpJump:       "jump",
+	OpJumpIf:     "jumpif",
+	OpJumpIfNot:  "jumpifnot",
+	OpDup:        "dup",
+	OpPop:        "pop",
+	OpField:      "field",
+	OpMatchLit:   "matchlit",
+	OpMatchCtor:  "matchctor",
+	OpNoMatch:    "nomatch",
+	OpExport:     "export",
+	OpSelect:     "select",
+	OpImport:     "import",
+	OpList:       "list",
+	OpRecord:     "record",
+	OpMatchCons:  "matchcons",
+	OpMatchNil:   "matchnil",
+	OpHead:       "head",
+	OpTail:       "tail",
+	OpMatchField: "matchfield",
+}
+
+func (o Op) String() string {
+	if s, ok := opString[o]; ok {
+		return s
+	}
+	return "op?"
+}
+
+// compileCode compiles a resolved program to bytecode,
+// after converting it to the closure-converted IR.
+// Each ir.Func becomes the Func with the same index.
+// Only the variables in ir.Func.Boxed are stored in a box.
+// Imported modules are compiled into the same Code,
+// so that it does not depend on any source files.
+func compileCode(root ast.Node) *Code {
+	p, err := ir.Convert(root)
+	if err != nil {
+		panic(err)
+	}
+
+	var exports []*export
+	for _, m := range p.Modules {
+		exp := &export{Module: &Module{File: m.File, Names: m.Names}}
+		for _, v := range m.Exports {
+			exp.Vars = append(exp.Vars, fromBP{Offset: v.Index})
+		}
+		exports = append(exports, exp)
+	}
+
+	c := &Code{}
+	for _, f := range p.Funcs {
+		// the environment is stored after the locals
+		fn := &Func{NumArgs: f.NumArgs, NumLocals: f.NumLocals + len(f.Env)}
+		for i := range f.Env {
+			fn.CapDst = append(fn.CapDst, f.NumLocals+i)
+		}
+		c.Funcs = append(c.Funcs, fn)
+	}
+	for i, f := range p.Funcs {
+		cd := &coder{code: c, fn: c.Funcs[i], ir: f, prog: p, exports: exports, argBox: make(map[int]int)}
+		cd.boxed()
+		cd.expr(f.Body)
+		cd.emit(OpRet, 0)
+	}
+	return c
+}
+
+// coder compiles the body of a single function.
+type coder struct {
+	code    *Code
+	fn      *Func
+	ir      *ir.Func
+	prog    *ir.Program
+	exports []*export   // by module index
+	argBox  map[int]int // boxed local holding a copy of a captured argument
+}
+
+// boxed marks the locals captured by closures as boxed.
+// Captured arguments are copied into a new boxed local on entry,
+// arguments themselves are never boxed.
+func (c *coder) boxed() {
+	for _, v := range c.ir.Boxed {
+		switch v.Kind {
+		case ir.ArgVar:
+			l := c.scratch()
+			c.argBox[v.Index] = l
+			c.fn.Boxed = append(c.fn.Boxed, l)
+			c.emit(OpArg, v.Index)
+			c.emit(OpStoreBox, l)
+		case ir.LocalVar:
+			c.fn.Boxed = append(c.fn.Boxed, c.local(v))
+		}
+	}
+}
+
+func (c *coder) emit(op Op, a int) int {
+	return c.emit2(op, a, 0)
+}
+
+func (c *coder) emit2(op Op, a, b int) int {
+	c.fn.Instrs = append(c.fn.Instrs, Instr{Op: op, A: a, B: b})
+	return len(c.fn.Instrs) - 1
+}
+
+// pc returns the index of the next instruction.
+func (c *coder) pc() int {
+	return len(c.fn.Instrs)
+}
+
+// patch sets the jump target of instruction i to the next instruction.
+func (c *coder) patch(i int) {
+	switch c.fn.Instrs[i].Op {
+	case OpMatchLit, OpMatchCtor, OpMatchCons, OpMatchNil, OpMatchField:
+		c.fn.Instrs[i].B = c.pc()
+	default:
+		c.fn.Instrs[i].A = c.pc()
+	}
+}
+
+// constant adds v to the constant pool and returns its index.
+func (c *coder) constant(v Value) int {
+	c.code.Consts = append(c.code.Consts, v)
+	return len(c.code.Consts) - 1
+}
+
+// scratch allocates a local variable not used by the source program.
+func (c *coder) scratch() int {
+	c.fn.NumLocals++
+	return c.fn.NumLocals - 1
+}
+
+// load emits code that pushes the value of local l.
+func (c *coder) load(l int) {
+	if c.fn.boxed(l) {
+		c.emit(OpLoadBox, l)
+	} else {
+		c.emit(OpLoad, l)
+	}
+}
+
+// store emits code that pops into local l.
+func (c *coder) store(l int) {
+	if c.fn.boxed(l) {
+		c.emit(OpStoreBox, l)
+	} else {
+		c.emit(OpStore, l)
+	}
+}
+
+// local returns the local that holds v, which must not be an argument.
+func (c *coder) local(v ir.Var) int {
+	switch v.Kind {
+	default:
+		panic(unhandled(v))
+	case ir.LocalVar:
+		return v.Index
+	case ir.EnvVar:
+		return c.ir.NumLocals + v.Index
+	}
+}
+
+// expr emits code that pushes the value of e.
+func (c *coder) expr(e ir.Expr) {
+	switch e := e.(type) {
+	default:
+		panic(unhandled(e))
+	case *ir.Block:
+		for _, a := range e.Assigns {
+			c.expr(a.Value)
+			c.store(c.local(a.Dst))
+		}
+		c.expr(e.Expr)
+	case *ir.Call:
+		// last argument first, like Call.Exec
+		for i := len(e.Args) - 1; i >= 0; i-- {
+			c.expr(e.Args[i])
+		}
+		c.expr(e.F)
+		c.emit(OpCall, len(e.Args))
+	case *ir.Closure:
+		for _, v := range e.Env {
+			if v.Kind == ir.ArgVar {
+				c.emit(OpBoxLocal, c.argBox[v.Index])
+			} else {
+				c.emit(OpBoxLocal, c.local(v))
+			}
+		}
+		c.emit(OpClosure, e.Func)
+	case *ir.Cond:
+		c.expr(e.Test)
+		els := c.emit(OpJumpIfNot, 0)
+		c.expr(e.If)
+		end := c.emit(OpJump, 0)
+		c.patch(els)
+		c.expr(e.Else)
+		c.patch(end)
+	case *ir.Ctor:
+		var v Value = &Tagged{Type: e.Type, Ctor: e.Name}
+		if e.Arity > 0 {
+			v = &Constructor{Type: e.Type, Name: e.Name, Arity: e.Arity}
+		}
+		c.emit(OpConst, c.constant(v))
+	case *ir.Export:
+		c.emit(OpExport, c.constant(c.exports[e.Module]))
+	case *ir.Global:
+		c.emit(OpConst, c.constant(valueOf(compileGlobal(&ast.Ident{Name: e.Name, Pos: e.Pos}))))
+	case *ir.Import:
+		c.emit(OpImport, c.prog.Modules[e.Module].Func)
+	case *ir.List:
+		// tail and elements last to first, like Call
+		tail := 0
+		if e.Tail != nil {
+			c.expr(e.Tail)
+			tail = 1
+		}
+		for i := len(e.Elems) - 1; i >= 0; i-- {
+			c.expr(e.Elems[i])
+		}
+		c.emit2(OpList, len(e.Elems), tail)
+	case *ir.Logic:
+		c.expr(e.X)
+		c.emit(OpDup, 0)
+		op := OpJumpIfNot
+		if e.Or {
+			op = OpJumpIf
+		}
+		end := c.emit(op, 0)
+		c.emit(OpPop, 0)
+		c.expr(e.Y)
+		c.patch(end)
+	case *ir.Match:
+		c.match(e)
+	case *ir.Num:
+		c.emit(OpConst, c.constant(valueOf(compileNum(&ast.Num{Value: e.Value}))))
+	case *ir.Record:
+		for i := len(e.Fields) - 1; i >= 0; i-- {
+			c.expr(e.Fields[i])
+		}
+		c.emit(OpRecord, c.constant(e.Names))
+	case *ir.Str:
+		c.emit(OpConst, c.constant(e.Value))
+	case *ir.Select:
+		c.expr(e.X)
+		c.emit(OpSelect, c.constant(e.Sel))
+	case ir.Var:
+		if e.Kind == ir.ArgVar {
+			c.emit(OpArg, e.Index)
+		} else {
+			c.load(c.local(e))
+		}
+	}
+}
+
+func (c *coder) match(n *ir.Match) {
+	var tmp []int
+	for i, x := range n.X {
+		tmp = append(tmp, c.local(n.Tmp[i]))
+		c.expr(x)
+		c.store(tmp[i])
+	}
+
+	var ends []int
+	for _, cs := range n.Cases {
+		var fails []int
+		for i, t := range tmp {
+			c.load(t)
+			fails = append(fails, c.pattern(cs.Pats[i])...)
+		}
+		if cs.Guard != nil {
+			c.expr(cs.Guard)
+			fails = append(fails, c.emit(OpJumpIfNot, 0))
+		}
+		c.expr(cs.Body)
+		ends = append(ends, c.emit(OpJump, 0))
+		for _, f := range fails {
+			c.patch(f)
+		}
+	}
+
+	for _, t := range tmp {
+		c.load(t)
+	}
+	c.emit(OpNoMatch, len(tmp))
+	for _, e := range ends {
+		c.patch(e)
+	}
+}
+
+// pattern emits code that pops a value and matches it against p.
+// It returns the instructions that jump away if the value does not match,
+// to be patched by the caller.
+func (c *coder) pattern(p ir.Pattern) []int {
+	switch p := p.(type) {
+	default:
+		panic(unhandled(p))
+	case *ir.Wildcard:
+		c.emit(OpPop, 0)
+		return nil
+	case ir.Var:
+		c.store(c.local(p))
+		return nil
+	case *ir.Lit:
+		return []int{c.emit2(OpMatchLit, c.constant(compileLit(&ast.Lit{Value: p.Value}).(lit).v), 0)}
+	case *ir.CtorPat:
+		v := c.scratch()
+		c.emit(OpStore, v)
+		c.emit(OpLoad, v)
+		fails := []int{c.emit2(OpMatchCtor, c.constant(&Tagged{Type: p.Type, Ctor: p.Ctor}), 0)}
+		for i, a := range p.Args {
+			c.emit(OpLoad, v)
+			c.emit(OpField, i)
+			fails = append(fails, c.pattern(a)...)
+		}
+		return fails
+	case *ir.ListPat:
+		// v holds the remainder of the list, after the elements matched so far
+		v := c.scratch()
+		c.emit(OpStore, v)
+		var fails []int
+		for _, e := range p.Elems {
+			c.emit(OpLoad, v)
+			fails = append(fails, c.emit2(OpMatchCons, 0, 0))
+			c.emit(OpLoad, v)
+			c.emit(OpHead, 0)
+			fails = append(fails, c.pattern(e)...)
+			c.emit(OpLoad, v)
+			c.emit(OpTail, 0)
+			c.emit(OpStore, v)
+		}
+		c.emit(OpLoad, v)
+		if p.Tail == nil {
+			return append(fails, c.emit2(OpMatchNil, 0, 0))
+		}
+		return append(fails, c.pattern(p.Tail)...)
+	case *ir.RecordPat:
+		v := c.scratch()
+		c.emit(OpStore, v)
+		var fails []int
+		for i, f := range p.Fields {
+			name := c.constant(p.Names[i])
+			c.emit(OpLoad, v)
+			fails = append(fails, c.emit2(OpMatchField, name, 0))
+			c.emit(OpLoad, v)
+			c.emit(OpSelect, name)
+			fails = append(fails, c.pattern(f)...)
+		}
+		return fails
+	}
+}
+
+// valueOf returns the value of a Prog that does not depend on the stack,
+// like a constant or a built-in function.
+func valueOf(p Prog) Value {
+	var m Machine
+	p.Exec(&m)
+	return m.RA()
+}
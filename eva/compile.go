@@ -6,6 +6,7 @@ import (
 
 	se "github.com/barnex/se-lang"
 	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/lex"
 )
 
 type Prog interface {
@@ -26,19 +27,34 @@ func compileExpr(n ast.Node) Prog {
 		return compileIdent(n)
 	case *ast.Lambda:
 		return compileLambda(n)
+	case *ast.List:
+		return compileList(n)
+	case *ast.Logic:
+		return compileLogic(n)
+	case *ast.Match:
+		return compileMatch(n)
 	case *ast.Num:
 		return compileNum(n)
+	case *ast.Record:
+		return compileRecord(n)
+	case *ast.Select:
+		return compileSelect(n)
+	case *ast.Str:
+		return Const{n.Value}
 	}
 }
 
 // -------- Block
 
 func compileBlock(n *ast.Block) Prog {
-	b := &Block{}
+	b := &Block{Init: compileDecls(n)}
 	for _, stmt := range n.Stmts {
-		if a, ok := stmt.(*ast.Assign); ok {
-			b.Init = append(b.Init, compileAssign(a))
-		} else {
+		switch stmt := stmt.(type) {
+		case *ast.Assign:
+			b.Init = append(b.Init, compileAssign(stmt))
+		case *ast.TypeDef, *ast.Import:
+			// compiled by compileDecls
+		default:
 			if b.Expr != nil {
 				panic(se.Errorf("block has more than 1 expression"))
 			}
@@ -51,6 +67,21 @@ func compileBlock(n *ast.Block) Prog {
 	return b
 }
 
+// compileDecls returns the initialization of a block's constructors and imports,
+// which precedes all other statements as they do not depend on them.
+func compileDecls(n *ast.Block) []Assign {
+	var init []Assign
+	for _, stmt := range n.Stmts {
+		switch stmt := stmt.(type) {
+		case *ast.TypeDef:
+			init = append(init, compileTypeDef(stmt)...)
+		case *ast.Import:
+			init = append(init, compileImport(stmt))
+		}
+	}
+	return init
+}
+
 type Block struct {
 	Init []Assign
 	Expr Prog
@@ -69,9 +100,14 @@ type Assign struct {
 }
 
 func compileAssign(n *ast.Assign) Assign {
+	rhs := compileExpr(n.RHS)
+	if l, ok := rhs.(*LambdaProg); ok {
+		l.Info.Name = n.LHS.Name
+		l.Info.Pos = n.LHS.Pos
+	}
 	return Assign{
 		LHS: compileLocVar(n.LHS.Var.(*ast.LocVar)),
-		RHS: compileExpr(n.RHS),
+		RHS: rhs,
 	}
 }
 
@@ -96,62 +132,157 @@ func compileCond(n *ast.Cond) *Cond {
 
 func (p *Cond) Exec(m *Machine) {
 	p.Test.Exec(m)
-	if m.RA().Get().(bool) {
+	if isTrue(m.RA()) {
 		p.If.Exec(m)
 	} else {
 		p.Else.Exec(m)
 	}
 }
 
+// isTrue returns the value of a condition, which must be a bool.
+func isTrue(v Value) bool {
+	b, ok := v.(bool)
+	if !ok {
+		panic(se.Errorf("condition is not a bool: %v", quote(v)))
+	}
+	return b
+}
+
+// -------- Logic
+
+// Logic evaluates X, and Y only if X does not determine the result.
+type Logic struct {
+	Or   bool // true for ||, false for &&
+	X, Y Prog
+}
+
+func compileLogic(n *ast.Logic) *Logic {
+	return &Logic{
+		Or: n.Op == lex.TOr,
+		X:  compileExpr(n.X),
+		Y:  compileExpr(n.Y),
+	}
+}
+
+func (p *Logic) Exec(m *Machine) {
+	p.X.Exec(m)
+	if isTrue(m.RA()) != p.Or {
+		p.Y.Exec(m)
+	}
+}
+
 // -------- Lambda
 
 func compileLambda(n *ast.Lambda) Prog {
 	p := &LambdaProg{
-		Body:      compileExpr(n.Body),
+		Boxed:     markCaptured(n),
 		NumLocals: n.NumVar,
 	}
+	p.Info = newFuncInfo(n)
+	p.Body = compileExpr(n.Body)
 	for _, c := range n.Caps {
-		p.Caps = append(p.Caps, compileVar(c.Src))
+		p.Caps = append(p.Caps, compileVar(c.Src).(fromBP))
+		p.CapDst = append(p.CapDst, compileLocVar(c.Dst.(*ast.LocVar)))
 	}
 	return p
 }
 
+// markCaptured marks the arguments and locals of n that are captured by nested lambdas,
+// so that they get stored in a Box shared with the closures.
+// Other variables are stored unboxed on the stack.
+// It returns the slots to box on entry: the captured arguments and locals,
+// but not the captured variables of n itself, which are already boxed.
+func markCaptured(n *ast.Lambda) []fromBP {
+	isCap := make(map[ast.Var]bool)
+	for _, c := range n.Caps {
+		isCap[c.Dst] = true
+	}
+	var boxed []fromBP
+	seen := make(map[ast.Var]bool)
+	ast.Walk(n.Body, func(n ast.Node) bool {
+		l, ok := n.(*ast.Lambda)
+		if !ok {
+			return true
+		}
+		for _, c := range l.Caps {
+			if seen[c.Src] {
+				continue
+			}
+			seen[c.Src] = true
+			switch v := c.Src.(type) {
+			case *ast.Arg:
+				v.Captured = true
+			case *ast.LocVar:
+				v.Captured = true
+			}
+			if !isCap[c.Src] {
+				boxed = append(boxed, compileVar(c.Src).(fromBP))
+			}
+		}
+		return false // nested lambdas mark their own variables
+	})
+	return boxed
+}
+
 type LambdaProg struct {
-	Caps      []Prog
+	Caps      []fromBP
+	CapDst    []fromBP // where to store captured values in the new frame
+	Boxed     []fromBP // arguments and locals to box on entry
 	Body      Prog
 	NumLocals int
+	Info      *funcInfo // for the debugger
 }
 
 func (p *LambdaProg) Exec(m *Machine) {
-	v := &LambdaValue{Body: p.Body, NumLocals: p.NumLocals}
+	v := &LambdaValue{Body: p.Body, NumLocals: p.NumLocals, CapDst: p.CapDst, Boxed: p.Boxed, Info: p.Info}
 	for _, c := range p.Caps {
-		c.Exec(m)
-		if m.RA().v == nil {
-			panic("capv==nil")
-		}
-		v.Capv = append(v.Capv, m.RA())
+		v.Capv = append(v.Capv, c.Box(m))
 	}
-	m.SetRA(box(v))
+	m.SetRA(v)
 }
 
 type LambdaValue struct {
 	Capv      []Box
+	CapDst    []fromBP
+	Boxed     []fromBP
 	Body      Prog
 	NumLocals int
+	Info      *funcInfo
 }
 
 var _ Applier = (*LambdaValue)(nil)
 
 func (p *LambdaValue) Apply(m *Machine) {
-	m.Push(box(m.BP()))
+	m.Push(m.BP())
 	m.SetBP(m.SP())
 	m.Grow(p.NumLocals)
+	for _, b := range p.Boxed {
+		v := m.FromBP(b.Offset) // argument value, or nil for a local
+		m.SetFromBP(b.Offset, Box{&v})
+	}
 	for i, c := range p.Capv {
-		m.FromBP(i).Set(c.Get())
+		if d := p.CapDst[i]; d.Boxed {
+			m.SetFromBP(d.Offset, c) // captured again: share the box
+		} else {
+			m.SetFromBP(d.Offset, c.Get())
+		}
+	}
+	if m.hook != nil {
+		m.hook.enter(p, m.BP())
 	}
 	p.Body.Exec(m)
+	if m.hook != nil {
+		m.hook.leave()
+	}
 	m.Grow(-p.NumLocals)
-	m.SetBP(m.Pop().Get().(int))
+	m.SetBP(m.Pop().(int))
+}
+
+func (p *LambdaValue) String() string {
+	if p.Info == nil || p.Info.Name == "" {
+		return "lambda"
+	}
+	return "func " + p.Info.Name
 }
 
 // -------- Call
@@ -159,10 +290,11 @@ func (p *LambdaValue) Apply(m *Machine) {
 type Call struct {
 	F    Prog
 	Args []Prog
+	Pos  se.Position // for the debugger
 }
 
 func compileCall(n *ast.Call) Prog {
-	var c Call
+	c := Call{Pos: callPos(n)}
 	c.F = compileExpr(n.F)
 	for _, a := range n.Args {
 		c.Args = append(c.Args, compileExpr(a))
@@ -171,13 +303,56 @@ func compileCall(n *ast.Call) Prog {
 }
 
 func (p *Call) Exec(m *Machine) {
+	m.steps++
+	if m.maxSteps > 0 && m.steps > m.maxSteps {
+		panic(se.Errorf("exceeded %v steps", m.maxSteps))
+	}
+	if m.hook != nil {
+		m.hook.call(p)
+	}
 	for i := len(p.Args) - 1; i >= 0; i-- {
 		p.Args[i].Exec(m) // eval argument
 		m.Push(m.RA())    // push argument
 	}
-	p.F.Exec(m)                     // eval the function
-	m.RA().Get().(Applier).Apply(m) // apply function to arguments
-	m.Grow(-len(p.Args))            // free arguments stack space
+	p.F.Exec(m) // eval the function
+	f := applier(m.RA())
+	checkArgs(f, len(p.Args))
+	f.Apply(m)           // apply function to arguments
+	m.Grow(-len(p.Args)) // free arguments stack space
+	if m.hook != nil {
+		m.hook.called(p)
+	}
+}
+
+func applier(v Value) Applier {
+	switch f := v.(type) {
+	case Applier:
+		return f
+	case nil:
+		panic(se.Errorf("function called before its definition"))
+	default:
+		panic(se.Errorf("not a function: %v", f))
+	}
+}
+
+// checkArgs fails if f takes a different number of arguments than nargs.
+func checkArgs(f Applier, nargs int) {
+	want := nargs
+	switch f := f.(type) {
+	case fn1, *composed:
+		want = 1
+	case fn2, *atPos, hof2:
+		want = 2
+	case *Constructor:
+		want = f.Arity
+	case *LambdaValue:
+		want = f.Info.NumArgs
+	case *Closure:
+		want = f.Func.NumArgs
+	}
+	if nargs != want {
+		panic(se.Errorf("called with %v arguments, want %v", nargs, want))
+	}
 }
 
 type Applier interface {
@@ -195,9 +370,18 @@ func compileIdent(id *ast.Ident) Prog {
 }
 
 func compileGlobal(id *ast.Ident) Prog {
-	p := prelude.Find(id.Name)
+	p := builtin(id.Name)
+	if positioned[id.Name] {
+		return &atPos{f: p.(fn2), pos: id.Pos}
+	}
+	return p
+}
+
+// builtin returns the built-in with the given name.
+func builtin(name string) Prog {
+	p := prelude.Find(name)
 	if p == nil {
-		panic(se.Errorf("compileIdent: undefined: %q: %#v", id.Name, id))
+		panic(se.Errorf("compileIdent: undefined: %q", name))
 	}
 	return p
 }
@@ -216,23 +400,49 @@ func compileVar(v ast.Var) Prog {
 }
 
 func compileArg(a *ast.Arg) Prog {
-	return fromBP{Offset: -2 - a.Index}
+	return fromBP{Offset: -2 - a.Index, Boxed: a.Captured}
 }
 
 func compileLocVar(a *ast.LocVar) fromBP {
-	return fromBP{Offset: a.Index}
+	return fromBP{Offset: a.Index, Boxed: a.Captured}
 }
 
+// fromBP is a variable stored on the stack, relative to the base pointer.
+// Boxed variables are captured by a closure, and stored in a Box.
 type fromBP struct {
 	Offset int
+	Boxed  bool
 }
 
 func (p fromBP) Exec(m *Machine) {
-	m.SetRA(m.FromBP(p.Offset))
+	m.SetRA(p.Get(m))
+}
+
+func (p fromBP) Get(m *Machine) Value {
+	if p.Boxed {
+		return m.FromBP(p.Offset).(Box).Get()
+	}
+	return m.FromBP(p.Offset)
+}
+
+// Box returns the Box holding a captured variable.
+func (p fromBP) Box(m *Machine) Box {
+	if !p.Boxed {
+		panic(fmt.Sprintf("BUG: capturing unboxed variable %v", p))
+	}
+	return m.FromBP(p.Offset).(Box)
 }
 
 func (p fromBP) SetToRA(m *Machine) {
-	m.FromBP(p.Offset).Set(m.RA().Get())
+	p.Set(m, m.RA())
+}
+
+func (p fromBP) Set(m *Machine, v Value) {
+	if p.Boxed {
+		m.FromBP(p.Offset).(Box).Set(v)
+	} else {
+		m.SetFromBP(p.Offset, v)
+	}
 }
 
 // -------- Const
@@ -242,7 +452,7 @@ type Const struct {
 }
 
 func (c Const) Exec(m *Machine) {
-	m.SetRA(box(c.v))
+	m.SetRA(c.v)
 }
 
 func compileNum(n *ast.Num) Prog {
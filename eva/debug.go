@@ -0,0 +1,406 @@
+package eva
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+)
+
+// A Debugger runs a program step by step.
+// The program pauses before each function call that hits a breakpoint
+// or ends a step, so its frames can be inspected.
+//
+// The program runs in its own goroutine, while the Debugger's methods
+// wait for it to pause. A Debugger must not be used concurrently.
+//
+// Programs should be compiled without optimization (Loader.NoOpt),
+// so that the calls and variables correspond to the source.
+type Debugger struct {
+	prog    Prog
+	bps     map[string]map[int]bool // breakpoint lines, by file
+	m       Machine
+	frames  []*activation // innermost last
+	mode    stepMode
+	depth   int // number of frames when the last step started
+	started bool
+	stopped chan Stop
+	resume  chan stepMode
+	exit    *Stop       // how the program ended, once it did
+	panicv  interface{} // a panic of the program, not being an se.Error
+}
+
+// A Stop tells why the program paused, or how it ended.
+type Stop struct {
+	Reason Reason
+	Pos    se.Position // position of the call about to be executed
+	Value  Value       // result of the program, if it exited
+	Err    error       // error of the program, if it exited
+}
+
+type Reason int
+
+const (
+	Breakpoint Reason = iota + 1 // paused on a breakpoint
+	Step                         // paused after a step
+	Exited                       // the program ended
+)
+
+func (r Reason) String() string {
+	switch r {
+	case Breakpoint:
+		return "breakpoint"
+	case Step:
+		return "step"
+	case Exited:
+		return "exited"
+	}
+	return fmt.Sprint("Reason(", int(r), ")")
+}
+
+// A Frame is the activation of a function, as seen by the debugger.
+type Frame struct {
+	Name string      // name of the function, "lambda" if it is anonymous
+	Pos  se.Position // position of the call being executed in the frame
+	Vars []Variable  // arguments, locals and captured variables, in that order
+}
+
+// Variable is a named argument, local or captured variable in a Frame.
+type Variable struct {
+	Name  string
+	Kind  VarKind
+	Boxed bool  // stored in a Box shared with closures
+	Value Value // nil for a local that has not been assigned yet
+}
+
+// ValueString formats the variable's value for display,
+// as "undefined" for a local that has not been assigned yet.
+func (v Variable) ValueString() string {
+	if v.Value == nil {
+		return "undefined"
+	}
+	return fmt.Sprint(v.Value)
+}
+
+type VarKind int
+
+const (
+	ArgVar VarKind = iota + 1
+	LocalVar
+	CapturedVar
+)
+
+func (k VarKind) String() string {
+	switch k {
+	case ArgVar:
+		return "argument"
+	case LocalVar:
+		return "local"
+	case CapturedVar:
+		return "captured"
+	}
+	return fmt.Sprint("VarKind(", int(k), ")")
+}
+
+// stepMode tells the running program where to pause next.
+type stepMode int
+
+const (
+	runContinue stepMode = iota // only on breakpoints
+	runStepIn                   // on the next call
+	runStepOver                 // on the next call in the same or an outer frame
+	runStepOut                  // on the next call in an outer frame
+	runQuit                     // abort the program
+)
+
+func NewDebugger(p Prog) *Debugger {
+	d := &Debugger{
+		prog:    p,
+		bps:     make(map[string]map[int]bool),
+		stopped: make(chan Stop),
+		resume:  make(chan stepMode),
+	}
+	d.m.hook = d
+	return d
+}
+
+// SetBreakpoint sets a breakpoint on a line in a file,
+// named as in the positions reported by the debugger.
+// The program pauses when it first calls a function on that line,
+// each time the line is reached.
+func (d *Debugger) SetBreakpoint(file string, line int) {
+	file = filepath.Clean(file)
+	if d.bps[file] == nil {
+		d.bps[file] = make(map[int]bool)
+	}
+	d.bps[file][line] = true
+}
+
+// ClearBreakpoints removes all breakpoints in a file.
+func (d *Debugger) ClearBreakpoints(file string) {
+	delete(d.bps, filepath.Clean(file))
+}
+
+// Breakpoints returns the breakpoint lines in a file, sorted.
+func (d *Debugger) Breakpoints(file string) []int {
+	var lines []int
+	for l := range d.bps[filepath.Clean(file)] {
+		lines = append(lines, l)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Continue runs the program until it hits a breakpoint or ends.
+func (d *Debugger) Continue() Stop { return d.run(runContinue) }
+
+// StepIn runs the program until the next function call.
+func (d *Debugger) StepIn() Stop { return d.run(runStepIn) }
+
+// StepOver runs the program until the next function call in the current frame,
+// or in an outer frame if the current function returns.
+func (d *Debugger) StepOver() Stop { return d.run(runStepOver) }
+
+// StepOut runs the program until the next function call
+// after the current function returns.
+func (d *Debugger) StepOut() Stop { return d.run(runStepOut) }
+
+// Quit aborts the program, if it has not yet ended.
+func (d *Debugger) Quit() {
+	if d.started && d.exit == nil {
+		d.run(runQuit)
+	}
+}
+
+// Exited returns true if the program has ended.
+func (d *Debugger) Exited() bool {
+	return d.exit != nil
+}
+
+// Frames returns the frames of the paused program, innermost first.
+func (d *Debugger) Frames() []Frame {
+	if d.exit != nil {
+		return nil
+	}
+	frames := make([]Frame, 0, len(d.frames))
+	for i := len(d.frames) - 1; i >= 0; i-- {
+		frames = append(frames, d.frames[i].inspect(&d.m))
+	}
+	return frames
+}
+
+// Lookup returns the variable with the given name in the i'th frame, 0 being the innermost.
+func (d *Debugger) Lookup(i int, name string) (Variable, bool) {
+	frames := d.Frames()
+	if i < 0 || i >= len(frames) {
+		return Variable{}, false
+	}
+	vars := frames[i].Vars
+	for j := len(vars) - 1; j >= 0; j-- { // the innermost of shadowed locals was declared last
+		if vars[j].Name == name {
+			return vars[j], true
+		}
+	}
+	return Variable{}, false
+}
+
+// run resumes (or starts) the program in the given mode,
+// and waits for it to pause or end.
+func (d *Debugger) run(mode stepMode) Stop {
+	if d.exit != nil {
+		return *d.exit
+	}
+	d.depth = len(d.frames)
+	if !d.started {
+		d.started = true
+		d.mode = mode
+		go d.exec()
+	} else {
+		d.resume <- mode
+	}
+	s := <-d.stopped
+	if d.panicv != nil {
+		panic(d.panicv)
+	}
+	if s.Reason == Exited {
+		d.exit = &s
+		d.frames = nil
+	}
+	return s
+}
+
+// quit is panicked by the program to abort.
+type quit struct{}
+
+// exec runs the program, in its own goroutine.
+func (d *Debugger) exec() {
+	var s Stop
+	defer func() {
+		switch e := recover().(type) {
+		case nil: //OK
+		default:
+			d.panicv = e // re-panicked by run
+		case quit:
+			s.Err = se.Errorf("program aborted")
+		case se.Error:
+			s.Err = e
+		}
+		s.Reason = Exited
+		d.stopped <- s
+	}()
+
+	d.prog.Exec(&d.m)
+	if len(d.m.s) != 0 {
+		s.Err = fmt.Errorf("left dirty stack: %v", d.m.s)
+		return
+	}
+	s.Value = d.m.RA()
+}
+
+// call is called by the program before each function call,
+// and pauses if the debugger should stop there.
+func (d *Debugger) call(p *Call) {
+	if len(d.frames) == 0 {
+		return // the call of the program itself
+	}
+	f := d.frames[len(d.frames)-1]
+	newLine := p.Pos.Line != f.pos.Line || p.Pos.Filename != f.pos.Filename
+	f.pos = p.Pos
+
+	reason := Reason(0)
+	switch {
+	case d.mode == runStepIn,
+		d.mode == runStepOver && len(d.frames) <= d.depth,
+		d.mode == runStepOut && len(d.frames) < d.depth:
+		reason = Step
+	case newLine && d.bps[filepath.Clean(p.Pos.Filename)][p.Pos.Line]:
+		reason = Breakpoint
+	default:
+		return
+	}
+
+	d.stopped <- Stop{Reason: reason, Pos: p.Pos}
+	d.mode = <-d.resume
+	if d.mode == runQuit {
+		panic(quit{})
+	}
+}
+
+func (d *Debugger) called(p *Call) {}
+
+func (d *Debugger) enter(f *LambdaValue, bp int) {
+	d.frames = append(d.frames, &activation{info: f.Info, bp: bp})
+}
+
+func (d *Debugger) leave() {
+	d.frames = d.frames[:len(d.frames)-1]
+}
+
+// activation is a running call of a LambdaValue.
+type activation struct {
+	info *funcInfo
+	bp   int
+	pos  se.Position // of the last call in this frame
+}
+
+func (f *activation) inspect(m *Machine) Frame {
+	fr := Frame{Name: f.info.name(), Pos: f.pos}
+	if f.info == nil {
+		return fr
+	}
+	for _, v := range f.info.Vars {
+		val := m.s[f.bp+v.Slot.Offset]
+		if b, ok := val.(Box); ok {
+			val = b.Get()
+		}
+		fr.Vars = append(fr.Vars, Variable{Name: v.Name, Kind: v.Kind, Boxed: v.Slot.Boxed, Value: val})
+	}
+	return fr
+}
+
+// funcInfo describes a function for the debugger, profiler and test runner.
+type funcInfo struct {
+	Name    string      // set by compileAssign for named functions
+	Pos     se.Position // of the function's name, or else its first identifier
+	NumArgs int
+	Vars    []varInfo
+}
+
+// name returns the name of the function, "lambda" if it is anonymous.
+func (f *funcInfo) name() string {
+	if f == nil || f.Name == "" {
+		return "lambda"
+	}
+	return f.Name
+}
+
+type varInfo struct {
+	Name string
+	Kind VarKind
+	Slot fromBP
+}
+
+// newFuncInfo returns the names and slots of the variables of n.
+// It must be called after markCaptured(n), which decides which slots are boxed.
+func newFuncInfo(n *ast.Lambda) *funcInfo {
+	info := &funcInfo{NumArgs: len(n.Args)}
+	ast.Walk(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && info.Pos.Line == 0 {
+			info.Pos = id.Pos
+		}
+		return info.Pos.Line == 0
+	})
+	for _, a := range n.Args {
+		if arg, ok := a.Var.(*ast.Arg); ok {
+			info.Vars = append(info.Vars, varInfo{Name: a.Name, Kind: ArgVar, Slot: compileArg(arg).(fromBP)})
+		}
+	}
+
+	isCap := make(map[ast.Var]bool)
+	for _, c := range n.Caps {
+		isCap[c.Dst] = true
+	}
+	var locals []*ast.Ident
+	seen := make(map[ast.Var]bool)
+	ast.Walk(n.Body, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Lambda:
+			return false
+		case *ast.Ident:
+			if l, ok := n.Var.(*ast.LocVar); ok && !isCap[l] && !seen[l] {
+				seen[l] = true
+				locals = append(locals, n)
+			}
+		}
+		return true
+	})
+	sort.SliceStable(locals, func(i, j int) bool {
+		return locals[i].Var.(*ast.LocVar).Index < locals[j].Var.(*ast.LocVar).Index
+	})
+	for _, id := range locals {
+		info.Vars = append(info.Vars, varInfo{Name: id.Name, Kind: LocalVar, Slot: compileLocVar(id.Var.(*ast.LocVar))})
+	}
+
+	for _, c := range n.Caps {
+		info.Vars = append(info.Vars, varInfo{Name: c.Name, Kind: CapturedVar, Slot: compileLocVar(c.Dst.(*ast.LocVar))})
+	}
+	return info
+}
+
+// callPos returns the position of a call: that of the function's name or operator,
+// or else of the first identifier in the call.
+func callPos(n *ast.Call) se.Position {
+	if id, ok := n.F.(*ast.Ident); ok {
+		return id.Pos
+	}
+	var pos se.Position
+	ast.Walk(n, func(n ast.Node) bool {
+		if id, ok := n.(*ast.Ident); ok && pos.Line == 0 {
+			pos = id.Pos
+		}
+		return pos.Line == 0
+	})
+	return pos
+}
@@ -0,0 +1,150 @@
+package eva
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+)
+
+const debugSrc = `fib = n ->
+	n < 2? n: fib(n - 1) + fib(n - 2);
+
+adder = x -> {
+	y = x + 1;
+	z -> x + y + z
+};
+
+a = fib(3);
+add2 = adder(a);
+add2(1)
+`
+
+func debugger(t *testing.T, src string) *Debugger {
+	t.Helper()
+	n, err := ast.ParseFile("test.howl", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLoader()
+	l.NoOpt = true
+	prog, err := l.CompileAST(n, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewDebugger(prog)
+}
+
+// checkStop checks that the program paused for reason on line,
+// with the given names of the frames, innermost first.
+func checkStop(t *testing.T, d *Debugger, s Stop, reason Reason, line int, frames ...string) {
+	t.Helper()
+	if s.Reason != reason || s.Pos.Line != line || s.Pos.Filename != "test.howl" {
+		t.Fatalf("stopped: have %v at %v, want %v at test.howl:%v", s.Reason, s.Pos, reason, line)
+	}
+	var have []string
+	for _, f := range d.Frames() {
+		have = append(have, f.Name)
+	}
+	if strings.Join(have, " ") != strings.Join(frames, " ") {
+		t.Fatalf("line %v: frames: have %v, want %v", line, have, frames)
+	}
+}
+
+// checkVar checks the value and kind of a variable in the innermost frame.
+func checkVar(t *testing.T, d *Debugger, name string, kind VarKind, want Value) {
+	t.Helper()
+	v, ok := d.Lookup(0, name)
+	if !ok {
+		t.Fatalf("variable %v not found in %v", name, d.Frames()[0].Vars)
+	}
+	if v.Kind != kind || v.Value != want {
+		t.Errorf("%v: have %v %v, want %v %v", name, v.Kind, v.Value, kind, want)
+	}
+}
+
+func TestDebugStep(t *testing.T) {
+	d := debugger(t, debugSrc)
+	defer d.Quit()
+
+	checkStop(t, d, d.StepIn(), Step, 9, "main")
+	checkVar(t, d, "fib", LocalVar, d.Frames()[0].Vars[0].Value)
+	checkVar(t, d, "a", LocalVar, nil)
+
+	checkStop(t, d, d.StepIn(), Step, 2, "fib", "main")
+	checkVar(t, d, "n", ArgVar, 3)
+	checkVar(t, d, "fib", CapturedVar, d.Frames()[1].Vars[0].Value)
+
+	checkStop(t, d, d.StepOut(), Step, 10, "main")
+	checkVar(t, d, "a", LocalVar, 2)
+
+	checkStop(t, d, d.StepIn(), Step, 5, "adder", "main")
+	checkVar(t, d, "x", ArgVar, 2)
+	checkVar(t, d, "y", LocalVar, nil)
+	if v, _ := d.Lookup(0, "x"); !v.Boxed {
+		t.Errorf("x: captured by a closure, should be boxed")
+	}
+	if v, _ := d.Lookup(0, "y"); v.ValueString() != "undefined" {
+		t.Errorf("y: have %v, want undefined", v.ValueString())
+	}
+
+	checkStop(t, d, d.StepOver(), Step, 11, "main")
+	checkStop(t, d, d.StepIn(), Step, 6, "lambda", "main")
+	checkVar(t, d, "z", ArgVar, 1)
+	checkVar(t, d, "x", CapturedVar, 2)
+	checkVar(t, d, "y", CapturedVar, 3)
+
+	s := d.Continue()
+	if s.Reason != Exited || s.Value != 6 || s.Err != nil {
+		t.Errorf("exit: have %v %v %v, want exited 6", s.Reason, s.Value, s.Err)
+	}
+	if !d.Exited() || d.Frames() != nil {
+		t.Errorf("exited program has frames")
+	}
+}
+
+func TestDebugBreakpoint(t *testing.T) {
+	d := debugger(t, debugSrc)
+	defer d.Quit()
+	d.SetBreakpoint("test.howl", 2)
+
+	// fib(3) calls fib(1) and fib(2), which calls fib(0) and fib(1)
+	// (arguments are evaluated right to left),
+	// each pausing once at the start of line 2.
+	for _, n := range []int{3, 1, 2, 0, 1} {
+		s := d.Continue()
+		if s.Reason != Breakpoint || s.Pos.Line != 2 {
+			t.Fatalf("fib(%v): have %v at %v, want breakpoint at line 2", n, s.Reason, s.Pos)
+		}
+		checkVar(t, d, "n", ArgVar, n)
+	}
+	if frames := d.Frames(); len(frames) != 4 { // fib(1), fib(2), fib(3), main
+		t.Errorf("have %v frames, want 4", len(frames))
+	}
+
+	d.ClearBreakpoints("test.howl")
+	if s := d.Continue(); s.Reason != Exited || s.Value != 6 {
+		t.Errorf("have %v %v, want exited 6", s.Reason, s.Value)
+	}
+}
+
+func TestDebugQuit(t *testing.T) {
+	d := debugger(t, debugSrc)
+	d.SetBreakpoint("test.howl", 5)
+	checkStop(t, d, d.Continue(), Breakpoint, 5, "adder", "main")
+	d.Quit()
+	if !d.Exited() {
+		t.Errorf("program did not quit")
+	}
+	if s := d.Continue(); s.Err == nil {
+		t.Errorf("aborted program: have %v, want error", s.Value)
+	}
+}
+
+func TestDebugError(t *testing.T) {
+	d := debugger(t, "f = 1;\nf(2)")
+	s := d.Continue()
+	if s.Reason != Exited || s.Err == nil {
+		t.Errorf("have %v %v %v, want error", s.Reason, s.Value, s.Err)
+	}
+}
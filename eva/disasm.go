@@ -0,0 +1,265 @@
+package eva
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Disasm returns a textual dump of a compiled program.
+// Each Prog is printed on a line, followed by its children, indented.
+// Variables are printed as offsets from the base pointer, with their meaning, e.g.:
+// 	bp-2 (arg 0)
+// 	bp+1 (L1)
+func Disasm(p Prog) string {
+	var d disasm
+	d.prog("", p)
+	return d.buf.String()
+}
+
+type disasm struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+// line prints a line at the current indentation.
+func (d *disasm) line(format string, x ...interface{}) {
+	fmt.Fprint(&d.buf, strings.Repeat("\t", d.indent))
+	fmt.Fprintf(&d.buf, format, x...)
+	fmt.Fprintln(&d.buf)
+}
+
+// prog prints p, preceded by label (e.g. "body: "), and then its children.
+func (d *disasm) prog(label string, p Prog) {
+	switch p := p.(type) {
+	default:
+		d.line("%v%v", label, leafString(p))
+	case *Block:
+		d.line("%vblock", label)
+		d.indent++
+		for _, a := range p.Init {
+			d.prog(fmt.Sprint(a.LHS, " = "), a.RHS)
+		}
+		d.prog("expr: ", p.Expr)
+		d.indent--
+	case *Call:
+		d.line("%vcall nargs=%v", label, len(p.Args))
+		d.indent++
+		d.prog("func: ", p.F)
+		for i, a := range p.Args {
+			d.prog(fmt.Sprint("arg ", i, ": "), a)
+		}
+		d.indent--
+	case *Cond:
+		d.line("%vcond", label)
+		d.indent++
+		d.prog("test: ", p.Test)
+		d.prog("if: ", p.If)
+		d.prog("else: ", p.Else)
+		d.indent--
+	case *LambdaProg:
+		d.line("%vlambda NumLocals=%v", label, p.NumLocals)
+		d.indent++
+		for i, c := range p.Caps {
+			d.prog(fmt.Sprint("capture ", p.CapDst[i], " <- "), c)
+		}
+		d.prog("body: ", p.Body)
+		d.indent--
+	case *ListProg:
+		d.line("%vlist", label)
+		d.indent++
+		for i, e := range p.Elems {
+			d.prog(fmt.Sprint("elem ", i, ": "), e)
+		}
+		if p.Tail != nil {
+			d.prog("tail: ", p.Tail)
+		}
+		d.indent--
+	case *Logic:
+		op := "&&"
+		if p.Or {
+			op = "||"
+		}
+		d.line("%v%v", label, op)
+		d.indent++
+		d.prog("x: ", p.X)
+		d.prog("y: ", p.Y)
+		d.indent--
+	case *Match:
+		d.line("%vmatch", label)
+		d.indent++
+		for i, x := range p.X {
+			d.prog(fmt.Sprint(p.Tmp[i], " = "), x)
+		}
+		for i, c := range p.Cases {
+			var pats []string
+			for _, pat := range c.Pats {
+				pats = append(pats, patternString(pat))
+			}
+			d.line("case %v: %v", i, strings.Join(pats, ", "))
+			d.indent++
+			if c.Guard != nil {
+				d.prog("if: ", c.Guard)
+			}
+			d.prog("body: ", c.Body)
+			d.indent--
+		}
+		d.indent--
+	case *Module:
+		d.line("%v%v", label, valueString(p))
+	case *RecordProg:
+		d.line("%vrecord", label)
+		d.indent++
+		for i, x := range p.Fields {
+			d.prog(p.Names[i]+": ", x)
+		}
+		d.indent--
+	case *Select:
+		d.line("%vselect %v", label, p.Sel)
+		d.indent++
+		d.prog("x: ", p.X)
+		d.indent--
+	}
+}
+
+// leafString formats a Prog without children.
+func leafString(p Prog) string {
+	switch p := p.(type) {
+	case fromBP:
+		return p.String()
+	case Const:
+		return "const " + valueString(p.v)
+	case *Const:
+		return "const " + valueString(p.v)
+	case fn1, fn2:
+		return "builtin " + builtinName(p)
+	case *atPos:
+		return "builtin " + builtinName(p.f)
+	default:
+		return fmt.Sprintf("%T", p)
+	}
+}
+
+func (p fromBP) String() string {
+	if p.Offset < 0 {
+		return fmt.Sprintf("bp%v (arg %v)", p.Offset, -2-p.Offset)
+	}
+	return fmt.Sprintf("bp+%v (L%v)", p.Offset, p.Offset)
+}
+
+func patternString(p Pattern) string {
+	switch p := p.(type) {
+	case wildcard:
+		return "_"
+	case bind:
+		return p.dst.String()
+	case lit:
+		return valueString(p.v)
+	case *ctorPat:
+		if len(p.Args) == 0 {
+			return p.Ctor
+		}
+		var args []string
+		for _, a := range p.Args {
+			args = append(args, patternString(a))
+		}
+		return fmt.Sprintf("%v(%v)", p.Ctor, strings.Join(args, ", "))
+	case *listPat:
+		var elems []string
+		for _, e := range p.Elems {
+			elems = append(elems, patternString(e))
+		}
+		if p.Tail != nil {
+			return fmt.Sprintf("[%v | %v]", strings.Join(elems, ", "), patternString(p.Tail))
+		}
+		return fmt.Sprintf("[%v]", strings.Join(elems, ", "))
+	case *recordPat:
+		var fields []string
+		for i, f := range p.Fields {
+			fields = append(fields, p.Names[i]+": "+patternString(f))
+		}
+		return fmt.Sprintf("{%v}", strings.Join(fields, ", "))
+	default:
+		return fmt.Sprintf("%T", p)
+	}
+}
+
+// valueString formats a constant.
+func valueString(v Value) string {
+	switch v := v.(type) {
+	case fn1, fn2:
+		return "builtin " + builtinName(v)
+	case *atPos:
+		return "builtin " + builtinName(v.f)
+	case *Closure:
+		return "closure"
+	case *Constructor:
+		return "constructor " + v.Name
+	case *Module:
+		return fmt.Sprintf("module %v (%v)", v, v.File)
+	case *export:
+		return fmt.Sprintf("export %v %v", v.Module, v.Module.Names)
+	case string:
+		return fmt.Sprintf("%q", v)
+	case []string:
+		return "fields " + strings.Join(v, ", ")
+	case Prog:
+		return leafString(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// builtinName returns the name of a built-in function in the prelude,
+// or "?" if not found.
+func builtinName(f interface{}) string {
+	ptr := reflect.ValueOf(f).Pointer()
+	var names []string
+	for name, p := range prelude {
+		if reflect.TypeOf(p).Kind() == reflect.Func && reflect.ValueOf(p).Pointer() == ptr {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return "?"
+	}
+	sort.Strings(names)
+	return names[0]
+}
+
+// String returns a textual dump of the bytecode:
+// each function with its instructions, followed by the constants.
+func (c *Code) String() string {
+	var buf bytes.Buffer
+	for i, f := range c.Funcs {
+		fmt.Fprintf(&buf, "func %v: NumArgs=%v NumLocals=%v Boxed=%v CapDst=%v\n", i, f.NumArgs, f.NumLocals, f.Boxed, f.CapDst)
+		for pc, in := range f.Instrs {
+			fmt.Fprintf(&buf, "\t%4d\t%v", pc, in)
+			switch in.Op {
+			case OpConst, OpMatchLit, OpMatchCtor, OpExport, OpSelect, OpRecord, OpMatchField:
+				fmt.Fprintf(&buf, "\t; %v", valueString(c.Consts[in.A]))
+			}
+			fmt.Fprintln(&buf)
+		}
+	}
+	fmt.Fprintln(&buf, "consts:")
+	for i, v := range c.Consts {
+		fmt.Fprintf(&buf, "\t%4d\t%v\n", i, valueString(v))
+	}
+	return buf.String()
+}
+
+func (in Instr) String() string {
+	switch in.Op {
+	case OpRet, OpDup, OpPop, OpHead, OpTail:
+		return in.Op.String()
+	case OpMatchLit, OpMatchCtor, OpMatchField, OpList:
+		return fmt.Sprintf("%v %v %v", in.Op, in.A, in.B)
+	case OpMatchCons, OpMatchNil:
+		return fmt.Sprintf("%v %v", in.Op, in.B)
+	default:
+		return fmt.Sprintf("%v %v", in.Op, in.A)
+	}
+}
@@ -0,0 +1,92 @@
+package eva
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+)
+
+// unoptimized returns a Loader that does not optimize,
+// so that the output shows the program as written.
+func unoptimized() *Loader {
+	l := NewLoader()
+	l.NoOpt = true
+	return l
+}
+
+func TestDisasm(t *testing.T) {
+	src := `f = x -> y -> x + y; f(1)(2)`
+	want := `call nargs=0
+	func: lambda NumLocals=1
+		body: block
+			bp+0 (L0) = lambda NumLocals=0
+				body: lambda NumLocals=1
+					capture bp+0 (L0) <- bp-2 (arg 0)
+					body: call nargs=2
+						func: builtin add
+						arg 0: bp+0 (L0)
+						arg 1: bp-2 (arg 0)
+			expr: call nargs=1
+				func: call nargs=1
+					func: bp+0 (L0)
+					arg 0: const 1
+				arg 0: const 2
+`
+	n, err := ast.ParseProgram(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	prog, err := unoptimized().CompileAST(n, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := Disasm(prog); have != want {
+		t.Errorf("have:\n%v\nwant:\n%v", have, want)
+	}
+}
+
+func TestCodeString(t *testing.T) {
+	src := `f = x -> y -> x + y; f(1)(2)`
+	want := `func 0: NumArgs=0 NumLocals=0 Boxed=[] CapDst=[]
+	   0	closure 1
+	   1	call 0
+	   2	ret
+func 1: NumArgs=0 NumLocals=1 Boxed=[] CapDst=[]
+	   0	closure 2
+	   1	store 0
+	   2	const 0	; 2
+	   3	const 1	; 1
+	   4	load 0
+	   5	call 1
+	   6	call 1
+	   7	ret
+func 2: NumArgs=1 NumLocals=1 Boxed=[0] CapDst=[]
+	   0	arg 0
+	   1	storebox 0
+	   2	boxlocal 0
+	   3	closure 3
+	   4	ret
+func 3: NumArgs=1 NumLocals=1 Boxed=[] CapDst=[0]
+	   0	arg 0
+	   1	loadbox 0
+	   2	const 2	; builtin add
+	   3	call 2
+	   4	ret
+consts:
+	   0	2
+	   1	1
+	   2	builtin add
+`
+	n, err := ast.ParseProgram(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := unoptimized().CompileCode(n, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have := code.String(); have != want {
+		t.Errorf("have:\n%v\nwant:\n%v", have, want)
+	}
+}
@@ -0,0 +1,454 @@
+package eva
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+)
+
+// CodeExt is the file extension of encoded bytecode.
+const CodeExt = ".sec"
+
+// Bytecode is encoded as JSON, starting with a header
+// that identifies the format and its version.
+// The version must be incremented whenever the instruction set
+// or the encoding changes, as older files can then no longer be run.
+const (
+	codeMagic   = "se-bytecode"
+	CodeVersion = 7
+)
+
+type codeFile struct {
+	Magic   string
+	Version int
+	Funcs   []*Func
+	Consts  []constJSON
+	Types   []string `json:",omitempty"` // names of the sum types, referred to by index
+}
+
+// constJSON encodes a constant. Exactly one field is set,
+// except Pos, which may accompany Builtin.
+type constJSON struct {
+	Int     *int         `json:",omitempty"`
+	Float   *float64     `json:",omitempty"`
+	Bool    *bool        `json:",omitempty"`
+	String  *string      `json:",omitempty"`
+	Builtin string       `json:",omitempty"`
+	Pos     *se.Position `json:",omitempty"` // where Builtin is called, for assert and assertEq
+	Tagged  *tagJSON     `json:",omitempty"` // constructor without fields
+	Ctor    *ctorJSON    `json:",omitempty"`
+	Export  *exportJSON  `json:",omitempty"`
+	Fields  []string     `json:",omitempty"` // names of the fields of a record
+}
+
+// tagJSON and ctorJSON refer to their type by index in codeFile.Types,
+// so that types with the same name remain different.
+type tagJSON struct {
+	Type int
+	Ctor string
+}
+
+type ctorJSON struct {
+	Type  int
+	Name  string
+	Arity int
+}
+
+type exportJSON struct {
+	File   string
+	Names  []string
+	Locals []int
+}
+
+// EncodeCode writes c to w, to be read back by DecodeCode.
+func EncodeCode(w io.Writer, c *Code) error {
+	f := codeFile{Magic: codeMagic, Version: CodeVersion, Funcs: c.Funcs}
+	types := make(map[*ast.TypeDef]int)
+	typeIndex := func(t *ast.TypeDef) int {
+		if i, ok := types[t]; ok {
+			return i
+		}
+		types[t] = len(f.Types)
+		f.Types = append(f.Types, t.Name)
+		return types[t]
+	}
+	for _, v := range c.Consts {
+		x, err := encodeConst(v, typeIndex)
+		if err != nil {
+			return err
+		}
+		f.Consts = append(f.Consts, x)
+	}
+	return json.NewEncoder(w).Encode(&f)
+}
+
+func encodeConst(v Value, typeIndex func(*ast.TypeDef) int) (constJSON, error) {
+	var x constJSON
+	switch v := v.(type) {
+	default:
+		return x, se.Errorf("encode: cannot encode constant %v (%T)", v, v)
+	case int:
+		x.Int = &v
+	case float64:
+		x.Float = &v
+	case bool:
+		x.Bool = &v
+	case string:
+		x.String = &v
+	case fn1, fn2, hof2:
+		x.Builtin = builtinName(v)
+	case *atPos:
+		x.Builtin = builtinName(v.f)
+		x.Pos = &v.pos
+	case *Tagged:
+		x.Tagged = &tagJSON{Type: typeIndex(v.Type), Ctor: v.Ctor}
+	case *Constructor:
+		x.Ctor = &ctorJSON{Type: typeIndex(v.Type), Name: v.Name, Arity: v.Arity}
+	case *export:
+		e := &exportJSON{File: v.Module.File, Names: v.Module.Names}
+		for _, l := range v.Vars {
+			e.Locals = append(e.Locals, l.Offset)
+		}
+		x.Export = e
+	case []string:
+		x.Fields = v
+	}
+	return x, nil
+}
+
+// DecodeCode reads bytecode written by EncodeCode.
+func DecodeCode(r io.Reader) (*Code, error) {
+	var f codeFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, se.Errorf("decode: %v", err)
+	}
+	if f.Magic != codeMagic {
+		return nil, se.Errorf("decode: not se bytecode")
+	}
+	if f.Version != CodeVersion {
+		return nil, se.Errorf("decode: bytecode version %v, want %v", f.Version, CodeVersion)
+	}
+	if len(f.Funcs) == 0 {
+		return nil, se.Errorf("decode: no entry point")
+	}
+
+	// the declarations are not in the bytecode, but stand-ins keep the types apart
+	var types []*ast.TypeDef
+	for _, name := range f.Types {
+		types = append(types, &ast.TypeDef{Name: name})
+	}
+	c := &Code{Funcs: f.Funcs}
+	for _, x := range f.Consts {
+		v, err := decodeConst(x, types)
+		if err != nil {
+			return nil, err
+		}
+		c.Consts = append(c.Consts, v)
+	}
+	if err := c.check(); err != nil {
+		return nil, se.Errorf("decode: %v", err)
+	}
+	return c, nil
+}
+
+func decodeConst(x constJSON, types []*ast.TypeDef) (Value, error) {
+	typ := func(i int) (*ast.TypeDef, error) {
+		if i < 0 || i >= len(types) {
+			return nil, se.Errorf("decode: type %v out of range", i)
+		}
+		return types[i], nil
+	}
+	switch {
+	case x.Int != nil:
+		return *x.Int, nil
+	case x.Float != nil:
+		return *x.Float, nil
+	case x.Bool != nil:
+		return *x.Bool, nil
+	case x.String != nil:
+		return *x.String, nil
+	case x.Builtin != "":
+		p := prelude.Find(x.Builtin)
+		if p == nil {
+			return nil, se.Errorf("decode: undefined builtin: %v", x.Builtin)
+		}
+		if x.Pos != nil {
+			f, ok := p.(fn2)
+			if !ok {
+				return nil, se.Errorf("decode: builtin %v has no position", x.Builtin)
+			}
+			return &atPos{f: f, pos: *x.Pos}, nil
+		}
+		return valueOf(p), nil
+	case x.Tagged != nil:
+		t, err := typ(x.Tagged.Type)
+		if err != nil {
+			return nil, err
+		}
+		return &Tagged{Type: t, Ctor: x.Tagged.Ctor}, nil
+	case x.Ctor != nil:
+		t, err := typ(x.Ctor.Type)
+		if err != nil {
+			return nil, err
+		}
+		return &Constructor{Type: t, Name: x.Ctor.Name, Arity: x.Ctor.Arity}, nil
+	case x.Fields != nil:
+		return x.Fields, nil
+	case x.Export != nil:
+		mod := &Module{File: x.Export.File, Names: x.Export.Names}
+		exp := &export{Module: mod}
+		for _, l := range x.Export.Locals {
+			exp.Vars = append(exp.Vars, fromBP{Offset: l})
+		}
+		return exp, nil
+	default:
+		return nil, se.Errorf("decode: empty constant")
+	}
+}
+
+// check returns an error if c contains instructions that would make the VM crash:
+// out-of-range operands, or a stack that could underflow or mix up boxes and values,
+// see checkStack.
+// Values of the wrong type, like a field of a number, are reported by the VM when it runs.
+func (c *Code) check() error {
+	for i, f := range c.Funcs {
+		if f == nil {
+			return fmt.Errorf("func %v: missing", i) // before closures and imports refer to it
+		}
+	}
+	for i, f := range c.Funcs {
+		for _, d := range f.CapDst {
+			if d < 0 || d >= f.NumLocals {
+				return fmt.Errorf("func %v: capture destination %v out of range", i, d)
+			}
+		}
+		for _, b := range f.Boxed {
+			if b < 0 || b >= f.NumLocals {
+				return fmt.Errorf("func %v: boxed local %v out of range", i, b)
+			}
+		}
+		if len(f.Instrs) == 0 || f.Instrs[len(f.Instrs)-1].Op != OpRet {
+			return fmt.Errorf("func %v: does not end with %v", i, OpRet)
+		}
+		for pc, in := range f.Instrs {
+			if err := c.checkInstr(f, in); err != nil {
+				return fmt.Errorf("func %v: %v: %v: %v", i, pc, in, err)
+			}
+		}
+		if err := c.checkStack(f); err != nil {
+			return fmt.Errorf("func %v: %v", i, err)
+		}
+	}
+	if c.Funcs[0].NumArgs != 0 {
+		return fmt.Errorf("entry point has arguments")
+	}
+	return nil
+}
+
+func (c *Code) checkInstr(f *Func, in Instr) error {
+	inRange := func(x, n int) bool { return x >= 0 && x < n }
+	isConst := func(ok bool) error {
+		if !inRange(in.A, len(c.Consts)) {
+			return fmt.Errorf("constant out of range")
+		}
+		if !ok {
+			return fmt.Errorf("wrong constant type: %T", c.Consts[in.A])
+		}
+		return nil
+	}
+	var ok bool
+	switch in.Op {
+	default:
+		return fmt.Errorf("bad opcode")
+	case OpConst:
+		return isConst(true)
+	case OpMatchLit:
+		if !inRange(in.B, len(f.Instrs)) {
+			return fmt.Errorf("jump out of range")
+		}
+		return isConst(true)
+	case OpMatchCtor:
+		if !inRange(in.B, len(f.Instrs)) {
+			return fmt.Errorf("jump out of range")
+		}
+		if inRange(in.A, len(c.Consts)) {
+			_, ok = c.Consts[in.A].(*Tagged)
+		}
+		return isConst(ok)
+	case OpExport:
+		if inRange(in.A, len(c.Consts)) {
+			var exp *export
+			if exp, ok = c.Consts[in.A].(*export); ok {
+				for _, v := range exp.Vars {
+					ok = ok && inRange(v.Offset, f.NumLocals)
+				}
+			}
+		}
+		return isConst(ok)
+	case OpSelect:
+		if inRange(in.A, len(c.Consts)) {
+			_, ok = c.Consts[in.A].(string)
+		}
+		return isConst(ok)
+	case OpMatchField:
+		if !inRange(in.B, len(f.Instrs)) {
+			return fmt.Errorf("jump out of range")
+		}
+		if inRange(in.A, len(c.Consts)) {
+			_, ok = c.Consts[in.A].(string)
+		}
+		return isConst(ok)
+	case OpRecord:
+		if inRange(in.A, len(c.Consts)) {
+			_, ok = c.Consts[in.A].([]string)
+		}
+		return isConst(ok)
+	case OpMatchCons, OpMatchNil:
+		ok = inRange(in.B, len(f.Instrs))
+	case OpList:
+		ok = in.A >= 0 && (in.B == 0 || in.B == 1)
+	case OpArg:
+		ok = inRange(in.A, f.NumArgs)
+	case OpLoad, OpStore:
+		ok = inRange(in.A, f.NumLocals)
+		if ok && f.boxed(in.A) {
+			return fmt.Errorf("local is boxed")
+		}
+	case OpLoadBox, OpStoreBox, OpBoxLocal:
+		ok = inRange(in.A, f.NumLocals)
+		if ok && !f.boxed(in.A) {
+			return fmt.Errorf("local is not boxed")
+		}
+	case OpClosure:
+		ok = inRange(in.A, len(c.Funcs))
+	case OpImport:
+		ok = inRange(in.A, len(c.Funcs))
+		if ok && (c.Funcs[in.A].NumArgs != 0 || len(c.Funcs[in.A].CapDst) != 0) {
+			return fmt.Errorf("module has arguments")
+		}
+	case OpJump, OpJumpIf, OpJumpIfNot:
+		ok = inRange(in.A, len(f.Instrs))
+	case OpCall, OpNoMatch, OpField:
+		ok = in.A >= 0
+	case OpRet, OpDup, OpPop, OpHead, OpTail:
+		ok = true
+	}
+	if !ok {
+		return fmt.Errorf("operand out of range")
+	}
+	return nil
+}
+
+// checkStack returns an error if the stack of f could underflow,
+// or if a box, to be captured by OpClosure, could be used as a value or vice versa.
+// Following all paths through f, the stack must have the same height and kinds of entries
+// at each instruction, whichever path leads there, like the compiler emits it.
+func (c *Code) checkStack(f *Func) error {
+	// stack above the arguments at each reached instruction: true for a box
+	stacks := make([][]bool, len(f.Instrs))
+	reached := make([]bool, len(f.Instrs))
+	var todo []int
+	flow := func(pc int, s []bool) error {
+		if !reached[pc] {
+			reached[pc] = true
+			stacks[pc] = s
+			todo = append(todo, pc)
+			return nil
+		}
+		if !sameStack(stacks[pc], s) {
+			return fmt.Errorf("%v: stack differs between paths", pc)
+		}
+		return nil
+	}
+	flow(0, nil)
+
+	for len(todo) > 0 {
+		pc := todo[len(todo)-1]
+		todo = todo[:len(todo)-1]
+		in := f.Instrs[pc]
+		s := append([]bool{}, stacks[pc]...)
+
+		// pop n boxes, or values
+		pop := func(n int, box bool) error {
+			if n > len(s) {
+				return fmt.Errorf("%v: %v: stack underflow", pc, in)
+			}
+			for _, b := range s[len(s)-n:] {
+				if b != box {
+					return fmt.Errorf("%v: %v: box and value mixed up", pc, in)
+				}
+			}
+			s = s[:len(s)-n]
+			return nil
+		}
+
+		var err error
+		next := []int{pc + 1}
+		switch in.Op {
+		default:
+			panic(unhandled(in.Op)) // checked by checkInstr
+		case OpConst, OpArg, OpLoad, OpLoadBox, OpExport, OpImport:
+			s = append(s, false)
+		case OpBoxLocal:
+			s = append(s, true)
+		case OpStore, OpStoreBox, OpPop:
+			err = pop(1, false)
+		case OpDup:
+			err = pop(1, false)
+			s = append(s, false, false)
+		case OpClosure:
+			err = pop(len(c.Funcs[in.A].CapDst), true)
+			s = append(s, false)
+		case OpCall:
+			err = pop(in.A+1, false)
+			s = append(s, false)
+		case OpField, OpSelect, OpHead, OpTail:
+			err = pop(1, false)
+			s = append(s, false)
+		case OpList:
+			err = pop(in.A+in.B, false)
+			s = append(s, false)
+		case OpRecord:
+			err = pop(len(c.Consts[in.A].([]string)), false)
+			s = append(s, false)
+		case OpJump:
+			next = []int{in.A}
+		case OpJumpIf, OpJumpIfNot:
+			err = pop(1, false)
+			next = append(next, in.A)
+		case OpMatchLit, OpMatchCtor, OpMatchCons, OpMatchNil, OpMatchField:
+			err = pop(1, false)
+			next = append(next, in.B)
+		case OpRet:
+			err = pop(1, false)
+			next = nil
+		case OpNoMatch:
+			err = pop(in.A, false)
+			next = nil
+		}
+		if err != nil {
+			return err
+		}
+		for _, pc := range next {
+			if err := flow(pc, s); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func sameStack(a, b []bool) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package eva
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeCodeFile(t *testing.T) {
+	code, err := NewLoader().CompileCodeFile("testdata/main.howl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := EncodeCode(&buf, code); err != nil {
+		t.Fatal(err)
+	}
+	code, err = DecodeCode(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := Run(code); err != nil || have != 9 {
+		t.Errorf("have %v, %v, want: %v", have, err, 9)
+	}
+}
+
+func TestDecodeCodeError(t *testing.T) {
+	cases := []string{
+		``,
+		`{}`,
+		`{"Magic": "se-bytecode", "Version": 0, "Funcs": [{"Instrs": [{"Op": 9}]}]}`,
+		`{"Magic": "se-bytecode", "Version": 7}`,
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 9}]}]}`,      // no constant
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 2}, {"Op": 9}]}]}`,      // no local
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 10, "A": 5}]}]}`,        // no ret
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 99}, {"Op": 9}]}]}`,     // bad opcode
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [null]}`,                                    // no func
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 9}]}], "Consts": [{}]}`, // empty constant
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 9}]}], "Consts": [{"Builtin": "nope"}]}`,
+		// type out of range
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 9}]}], "Consts": [{"Tagged": {"Type": 1}}], "Types": ["T"]}`,
+		// position of a built-in that does not report it
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 9}]}], "Consts": [{"Builtin": "not", "Pos": {"Line": 1}}]}`,
+		// ret on empty stack
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 9}]}]}`,
+		// pop on empty stack
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 14}, {"Op": 9}]}]}`,
+		// field on empty stack
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 15}, {"Op": 9}]}]}`,
+		// nomatch on empty stack
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 18, "A": 3}, {"Op": 9}]}]}`,
+		// call with too many args
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 8, "A": 1000}, {"Op": 9}]}], "Consts": [{"Int": 1}]}`,
+		// stack differs after jump
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 0}, {"Op": 11, "A": 4}, {"Op": 0}, {"Op": 9}]}], "Consts": [{"Bool": true}]}`,
+		// value captured as box
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 7, "A": 1}, {"Op": 9}]}, {"NumLocals": 1, "CapDst": [0], "Instrs": [{"Op": 4}, {"Op": 9}]}], "Consts": [{"Int": 1}]}`,
+		// boxed local loaded as value
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"NumLocals": 1, "Boxed": [0], "Instrs": [{"Op": 2}, {"Op": 9}]}]}`,
+		// unboxed local loaded from box
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"NumLocals": 1, "Instrs": [{"Op": 4}, {"Op": 9}]}]}`,
+		// import of a function with arguments
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 21, "A": 1}, {"Op": 9}]}, {"NumArgs": 1, "Instrs": [{"Op": 1}, {"Op": 9}]}]}`,
+		// missing func referred to by an earlier one
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 7, "A": 1}, {"Op": 9}]}, null]}`,
+		// boxed local out of range
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"NumLocals": 1, "Boxed": [1], "Instrs": [{"Op": 0}, {"Op": 9}]}], "Consts": [{"Int": 1}]}`,
+		// list with a bad tail flag
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 22, "B": 2}, {"Op": 9}]}]}`,
+		// list elements on empty stack
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 22, "A": 2}, {"Op": 9}]}]}`,
+		// record without field names
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 23}, {"Op": 9}]}], "Consts": [{"String": "x"}]}`,
+		// record fields on empty stack
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 23}, {"Op": 9}]}], "Consts": [{"Fields": ["x"]}]}`,
+		// matchcons jump out of range
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 22}, {"Op": 24, "B": 5}, {"Op": 22}, {"Op": 9}]}]}`,
+		// matchfield without field name
+		`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 28, "B": 2}, {"Op": 0}, {"Op": 9}]}], "Consts": [{"Int": 1}]}`,
+	}
+	for _, src := range cases {
+		if c, err := DecodeCode(strings.NewReader(src)); err == nil {
+			t.Errorf("%v: expected error, have:\n%v", src, c)
+		}
+	}
+}
+
+// Values of the wrong type are only known when running, but must not crash the VM.
+func TestRunCodeError(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 15}, {"Op": 9}]}], "Consts": [{"Int": 1}]}`, `no field 0 in 1`},
+		{`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 15, "A": 5}, {"Op": 9}]}], "Consts": [{"Tagged": {}}], "Types": ["T"]}`, `no field 5 in `},
+		{`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 20, "A": 1}, {"Op": 9}]}], "Consts": [{"Int": 1}, {"String": "x"}]}`, `selecting x from 1: not a module or record`},
+		{`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 26}, {"Op": 9}]}], "Consts": [{"Int": 1}]}`, `head of 1: not a non-empty list`},
+		{`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 22}, {"Op": 27}, {"Op": 9}]}]}`, `tail of []: not a non-empty list`},
+		{`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0}, {"Op": 22, "B": 1}, {"Op": 9}]}], "Consts": [{"Int": 1}]}`, `list tail 1 is not a list`},
+		{`{"Magic": "se-bytecode", "Version": 7, "Funcs": [{"Instrs": [{"Op": 0, "A": 1}, {"Op": 23}, {"Op": 20, "A": 1}, {"Op": 9}]}], "Consts": [{"Fields": ["x"]}, {"String": "y"}]}`, `selecting y from {x: y}: no such field`},
+	}
+	for _, c := range cases {
+		code, err := DecodeCode(strings.NewReader(c.src))
+		if err != nil {
+			t.Errorf("%v: %v", c.src, err)
+			continue
+		}
+		if v, err := Run(code); err == nil || !strings.HasPrefix(err.Error(), c.want) {
+			t.Errorf("%v: have %v, %v, want error: %v", c.src, v, err, c.want)
+		}
+	}
+}
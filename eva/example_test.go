@@ -0,0 +1,177 @@
+package eva
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+)
+
+// Examples are programs in the documentation and example directories,
+// whose result is given by a comment:
+//
+//	fac(6)  // expect: 720
+//
+// go test -run Examples -update rewrites the comments to the current results,
+// adding them where missing.
+var update = flag.Bool("update", false, "update the expected results of examples")
+
+// expectRE matches the comment with the expected result of an example.
+var expectRE = regexp.MustCompile(`//\s*expect:[ \t]*(.*)`)
+
+// exampleFiles are the .howl files run as examples, relative to package eva.
+var exampleFiles = []string{
+	"../project-euler/*.howl",
+	"../testdata/bench/*.howl",
+}
+
+// exampleDocs are the markdown files whose fenced code blocks are run as examples.
+var exampleDocs = []string{
+	"../README.md",
+}
+
+func TestExamples(t *testing.T) {
+	for _, pattern := range exampleFiles {
+		files, err := filepath.Glob(pattern)
+		if err != nil || len(files) == 0 {
+			t.Fatal("no examples:", pattern, err)
+		}
+		for _, f := range files {
+			src, err := ioutil.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out := checkExample(t, f, filepath.Dir(f), string(src)); out != string(src) {
+				writeExample(t, f, out)
+			}
+		}
+	}
+
+	for _, f := range exampleDocs {
+		doc, err := ioutil.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		blocks := fencedBlocks(string(doc))
+		if len(blocks) == 0 {
+			t.Fatal("no examples:", f)
+		}
+		var out strings.Builder
+		prev := 0
+		for _, b := range blocks {
+			src := string(doc[b[0]:b[1]])
+			name := fmt.Sprint(f, ":", 1+bytes.Count(doc[:b[0]], []byte("\n")))
+			out.Write(doc[prev:b[0]])
+			out.WriteString(checkExample(t, name, filepath.Dir(f), src))
+			prev = b[1]
+		}
+		out.Write(doc[prev:])
+		if out.String() != string(doc) {
+			writeExample(t, f, out.String())
+		}
+	}
+}
+
+// checkExample runs the example src on every backend, with imports relative to dir,
+// and checks its result against the expect comment.
+// It returns the source with the comment updated, if the -update flag is set,
+// or else src unchanged.
+func checkExample(t *testing.T, name, dir, src string) string {
+	t.Helper()
+	var results []string
+	for _, b := range []struct {
+		name string
+		eval func(n ast.Node) (Value, error)
+	}{
+		{"tree", func(n ast.Node) (Value, error) {
+			prog, err := NewLoader("testdata").CompileAST(n, dir)
+			if err != nil {
+				return nil, err
+			}
+			return Eval(prog)
+		}},
+		{"vm", func(n ast.Node) (Value, error) {
+			code, err := NewLoader("testdata").CompileCode(n, dir)
+			if err != nil {
+				return nil, err
+			}
+			return Run(code)
+		}},
+	} {
+		n, err := ast.ParseProgram(strings.NewReader(src))
+		if err != nil {
+			t.Errorf("%v: %v", name, err)
+			return src
+		}
+		v, err := b.eval(n)
+		if err != nil {
+			t.Errorf("%v: %v: %v", name, b.name, err)
+			return src
+		}
+		results = append(results, fmt.Sprint(v))
+	}
+	for _, r := range results[1:] {
+		if r != results[0] {
+			t.Errorf("%v: backends disagree: %q", name, results)
+			return src
+		}
+	}
+	have := results[0]
+
+	m := expectRE.FindAllStringSubmatchIndex(src, -1)
+	switch {
+	case len(m) > 1:
+		t.Errorf("%v: more than one expect comment", name)
+	case len(m) == 1 && strings.TrimSpace(src[m[0][2]:m[0][3]]) == have:
+		// OK
+	case *update && len(m) == 1:
+		return src[:m[0][2]] + have + src[m[0][3]:]
+	case *update:
+		if !strings.HasSuffix(src, "\n") {
+			src += "\n"
+		}
+		return src + "// expect: " + have + "\n"
+	case len(m) == 0:
+		t.Errorf("%v: missing comment // expect: %v", name, have)
+	default:
+		t.Errorf("%v: have %v, want %v", name, have, strings.TrimSpace(src[m[0][2]:m[0][3]]))
+	}
+	return src
+}
+
+// fencedBlocks returns the start and end offsets of the contents
+// of the ``` fenced code blocks in a markdown document.
+func fencedBlocks(doc string) [][2]int {
+	var blocks [][2]int
+	start := -1
+	for i := 0; i < len(doc); {
+		end := strings.IndexByte(doc[i:], '\n') + i + 1
+		if end == i {
+			end = len(doc)
+		}
+		if strings.HasPrefix(doc[i:end], "```") {
+			if start < 0 {
+				start = end
+			} else {
+				blocks = append(blocks, [2]int{start, i})
+				start = -1
+			}
+		}
+		i = end
+	}
+	return blocks
+}
+
+func writeExample(t *testing.T, file, src string) {
+	t.Helper()
+	if err := ioutil.WriteFile(file, []byte(src), 0666); err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("updated %v", file)
+}
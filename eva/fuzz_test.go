@@ -0,0 +1,30 @@
+package eva
+
+import (
+	"strings"
+	"testing"
+)
+
+// fuzzSteps limits the execution of fuzzed programs, which often do not terminate.
+const fuzzSteps = 10000
+
+// FuzzEval compiles and runs arbitrary programs on the tree and bytecode backends,
+// checking that failures are reported as errors rather than panics:
+//
+//	go test ./eva -run FuzzEval -fuzz FuzzEval
+func FuzzEval(f *testing.F) {
+	for _, c := range evalTests {
+		f.Add(c.src)
+	}
+	for _, src := range evalErrors {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		if prog, err := Compile(strings.NewReader(src)); err == nil {
+			EvalLimit(prog, fuzzSteps)
+		}
+		if code, err := CompileCode(strings.NewReader(src)); err == nil {
+			RunLimit(code, fuzzSteps)
+		}
+	})
+}
@@ -0,0 +1,295 @@
+package eva
+
+import (
+	"github.com/barnex/se-lang/ast"
+)
+
+// maxInline is the maximum number of nodes of a local function to be inlined.
+const maxInline = 32
+
+// inline replaces calls of immediately-applied lambdas, e.g.:
+// 	(x -> x*x)(3)
+// and of small, non-recursive functions defined in the same frame, e.g.:
+// 	square = x -> x*x; square(3)
+// or captured from an outer frame, if they do not capture anything themselves,
+// by a block that binds the arguments and evaluates the function body:
+// 	{x' = 3; x'*x'}
+// The variables of the inlined function become new local variables of the caller,
+// captured variables are replaced by the caller's variables they were captured from.
+func inline(root ast.Node) ast.Node {
+	in := &inliner{funcs: make(map[ast.Var]localFunc)}
+	return in.node(root, nil)
+}
+
+type inliner struct {
+	funcs map[ast.Var]localFunc // candidates for inlining, by the variable they are assigned to
+}
+
+// localFunc is a function assigned to a local variable of frame.
+type localFunc struct {
+	fn    *ast.Lambda
+	frame *ast.Lambda
+}
+
+// node inlines calls in n, which is in frame, and returns the result.
+func (in *inliner) node(n ast.Node, frame *ast.Lambda) ast.Node {
+	switch n := n.(type) {
+	default:
+		panic(unhandled(n))
+	case *ast.Assign:
+		n.RHS = in.node(n.RHS, frame)
+	case *ast.Block:
+		for i, s := range n.Stmts {
+			n.Stmts[i] = in.node(s, frame)
+			// A function becomes a candidate only after inlining in its own body,
+			// so that its copies need not be processed again.
+			// So calls that precede the definition are not inlined.
+			if a, ok := s.(*ast.Assign); ok {
+				if fn, ok := a.RHS.(*ast.Lambda); ok && inlinable(fn, a.LHS.Var) {
+					in.funcs[a.LHS.Var] = localFunc{fn, frame}
+				}
+			}
+		}
+	case *ast.Call:
+		n.F = in.node(n.F, frame)
+		for i, a := range n.Args {
+			n.Args[i] = in.node(a, frame)
+		}
+		if frame == nil {
+			break
+		}
+		switch f := n.F.(type) {
+		case *ast.Lambda:
+			if len(f.Args) == len(n.Args) {
+				return expand(f, n.Args, frame)
+			}
+		case *ast.Ident:
+			if lf, ok := in.funcs[f.Var]; ok && lf.frame == frame && len(lf.fn.Args) == len(n.Args) {
+				return expand(lf.fn, n.Args, frame)
+			}
+		}
+	case *ast.Cond:
+		n.Test = in.node(n.Test, frame)
+		n.If = in.node(n.If, frame)
+		n.Else = in.node(n.Else, frame)
+	case *ast.Lambda:
+		// A function that does not capture anything
+		// can also be inlined where it is captured.
+		for _, c := range n.Caps {
+			if lf, ok := in.funcs[c.Src]; ok && len(lf.fn.Caps) == 0 {
+				in.funcs[c.Dst] = localFunc{lf.fn, n}
+			}
+		}
+		n.Body = in.node(n.Body, n)
+	case *ast.List:
+		for i, e := range n.Elems {
+			n.Elems[i] = in.node(e, frame)
+		}
+		if n.Tail != nil {
+			n.Tail = in.node(n.Tail, frame)
+		}
+	case *ast.Logic:
+		n.X = in.node(n.X, frame)
+		n.Y = in.node(n.Y, frame)
+	case *ast.Match:
+		for i, x := range n.X {
+			n.X[i] = in.node(x, frame)
+		}
+		for _, c := range n.Cases {
+			if c.Guard != nil {
+				c.Guard = in.node(c.Guard, frame)
+			}
+			c.Body = in.node(c.Body, frame)
+		}
+	case *ast.Record:
+		for i, x := range n.Fields {
+			n.Fields[i] = in.node(x, frame)
+		}
+	case *ast.Select:
+		n.X = in.node(n.X, frame)
+	case *ast.Ident, *ast.Import, *ast.Num, *ast.Str, *ast.TypeDef:
+		// nothing to do
+	}
+	return n
+}
+
+// inlinable returns true if function fn, assigned to v, is small and does not call itself.
+// Calls through other functions are not a problem,
+// as inlined copies are not inlined again.
+func inlinable(fn *ast.Lambda, v ast.Var) bool {
+	for _, c := range fn.Caps {
+		if c.Src == v {
+			return false
+		}
+	}
+	size := 0
+	ast.Walk(fn, func(ast.Node) bool {
+		size++
+		return true
+	})
+	return size <= maxInline
+}
+
+// expand returns a copy of fn's body, applied to args, to be evaluated in frame.
+func expand(fn *ast.Lambda, args []ast.Node, frame *ast.Lambda) ast.Node {
+	c := &cloner{vars: make(map[ast.Var]ast.Var), frame: frame}
+	var stmts []ast.Node
+	for i := len(fn.Args) - 1; i >= 0; i-- { // last argument first, like Call.Exec
+		a := fn.Args[i]
+		v := frame.NewVariable()
+		c.vars[a.Var] = v
+		stmts = append(stmts, &ast.Assign{LHS: &ast.Ident{Name: a.Name, Var: v, Pos: a.Pos}, RHS: args[i]})
+	}
+	for _, cp := range fn.Caps {
+		c.vars[cp.Dst] = cp.Src
+	}
+	body := c.node(fn.Body)
+	if len(stmts) == 0 {
+		return body
+	}
+	return &ast.Block{Stmts: append(stmts, body)}
+}
+
+// cloner deep-copies the body of a function that is being inlined in frame.
+type cloner struct {
+	vars  map[ast.Var]ast.Var // the function's variables and their replacement in frame
+	frame *ast.Lambda
+	depth int // number of lambdas nested inside the function
+}
+
+// v returns the replacement of variable v.
+// Variables of lambdas nested inside the function are not replaced.
+func (c *cloner) v(v ast.Var) ast.Var {
+	if c.depth > 0 || v == nil {
+		return v
+	}
+	if r, ok := c.vars[v]; ok {
+		return r
+	}
+	r := c.frame.NewVariable()
+	c.vars[v] = r
+	return r
+}
+
+func (c *cloner) ident(id *ast.Ident) *ast.Ident {
+	cp := *id
+	cp.Var = c.v(id.Var)
+	return &cp
+}
+
+func (c *cloner) nodes(list []ast.Node) []ast.Node {
+	var cp []ast.Node
+	for _, n := range list {
+		cp = append(cp, c.node(n))
+	}
+	return cp
+}
+
+func (c *cloner) node(n ast.Node) ast.Node {
+	switch n := n.(type) {
+	default:
+		panic(unhandled(n))
+	case *ast.Assign:
+		return &ast.Assign{LHS: c.ident(n.LHS), RHS: c.node(n.RHS)}
+	case *ast.Block:
+		return &ast.Block{Stmts: c.nodes(n.Stmts)}
+	case *ast.Call:
+		return &ast.Call{F: c.node(n.F), Args: c.nodes(n.Args)}
+	case *ast.Cond:
+		return &ast.Cond{Test: c.node(n.Test), If: c.node(n.If), Else: c.node(n.Else)}
+	case *ast.Ident:
+		return c.ident(n)
+	case *ast.Import:
+		return &ast.Import{Name: c.ident(n.Name), Path: n.Path, Module: n.Module}
+	case *ast.Lambda:
+		return c.lambda(n)
+	case *ast.List:
+		l := &ast.List{Elems: c.nodes(n.Elems)}
+		if n.Tail != nil {
+			l.Tail = c.node(n.Tail)
+		}
+		return l
+	case *ast.Logic:
+		return &ast.Logic{Op: n.Op, X: c.node(n.X), Y: c.node(n.Y)}
+	case *ast.Match:
+		m := &ast.Match{X: c.nodes(n.X), Pos: n.Pos}
+		for _, t := range n.Tmp {
+			m.Tmp = append(m.Tmp, c.v(t))
+		}
+		for _, cs := range n.Cases {
+			cp := &ast.Case{Pat: c.pattern(cs.Pat), Body: c.node(cs.Body)}
+			if cs.Guard != nil {
+				cp.Guard = c.node(cs.Guard)
+			}
+			m.Cases = append(m.Cases, cp)
+		}
+		return m
+	case *ast.Num:
+		return &ast.Num{Value: n.Value}
+	case *ast.Record:
+		return &ast.Record{Names: n.Names, Fields: c.nodes(n.Fields), Pos: n.Pos}
+	case *ast.Str:
+		return &ast.Str{Value: n.Value}
+	case *ast.Select:
+		return &ast.Select{X: c.node(n.X), Sel: n.Sel}
+	case *ast.TypeDef:
+		// the copy declares the same type, only its constructors get new variables
+		t := &ast.TypeDef{Name: n.Name}
+		for _, ctor := range n.Ctors {
+			t.Ctors = append(t.Ctors, &ast.Ctor{Name: c.ident(ctor.Name), Fields: ctor.Fields, Type: ctor.Type})
+		}
+		return t
+	}
+}
+
+// lambda copies a lambda nested in the function.
+// Only the sources of its captures refer to the function's variables.
+func (c *cloner) lambda(n *ast.Lambda) *ast.Lambda {
+	cp := &ast.Lambda{Args: n.Args, NumVar: n.NumVar}
+	for _, cap := range n.Caps {
+		cap.Src = c.v(cap.Src)
+		cp.Caps = append(cp.Caps, cap)
+	}
+	c.depth++
+	cp.Body = c.node(n.Body)
+	c.depth--
+	return cp
+}
+
+func (c *cloner) pattern(p ast.Pattern) ast.Pattern {
+	switch p := p.(type) {
+	default:
+		panic(unhandled(p))
+	case *ast.Wildcard, *ast.Lit:
+		return p
+	case *ast.Ident:
+		return c.ident(p)
+	case *ast.Tuple:
+		t := &ast.Tuple{}
+		for _, e := range p.Elems {
+			t.Elems = append(t.Elems, c.pattern(e))
+		}
+		return t
+	case *ast.CtorPat:
+		cp := &ast.CtorPat{Name: p.Name, Ctor: p.Ctor, Pos: p.Pos}
+		for _, a := range p.Args {
+			cp.Args = append(cp.Args, c.pattern(a))
+		}
+		return cp
+	case *ast.ListPat:
+		l := &ast.ListPat{}
+		for _, e := range p.Elems {
+			l.Elems = append(l.Elems, c.pattern(e))
+		}
+		if p.Tail != nil {
+			l.Tail = c.pattern(p.Tail)
+		}
+		return l
+	case *ast.RecordPat:
+		r := &ast.RecordPat{Names: p.Names, Pos: p.Pos}
+		for _, x := range p.Fields {
+			r.Fields = append(r.Fields, c.pattern(x))
+		}
+		return r
+	}
+}
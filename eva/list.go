@@ -0,0 +1,121 @@
+package eva
+
+import (
+	"bytes"
+	"fmt"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+)
+
+// -------- List
+
+// List is an immutable linked list, e.g.: [1, 2, 3].
+// The empty list has a nil Tail.
+// Lists share their tails: [x | l] does not copy l.
+type List struct {
+	Head Value
+	Tail *List
+}
+
+// prepend returns the list of elems followed by tail,
+// which must be a list.
+func prepend(elems []Value, tail Value) *List {
+	l, ok := tail.(*List)
+	if !ok {
+		panic(se.Errorf("list tail %v is not a list", tail))
+	}
+	for i := len(elems) - 1; i >= 0; i-- {
+		l = &List{Head: elems[i], Tail: l}
+	}
+	return l
+}
+
+func (l *List) String() string {
+	var buf bytes.Buffer
+	fmt.Fprint(&buf, "[")
+	for e := l; e.Tail != nil; e = e.Tail {
+		if e != l {
+			fmt.Fprint(&buf, ", ")
+		}
+		fmt.Fprint(&buf, e.Head)
+	}
+	fmt.Fprint(&buf, "]")
+	return buf.String()
+}
+
+func (l *List) equal(b *List) bool {
+	for ; l.Tail != nil && b.Tail != nil; l, b = l.Tail, b.Tail {
+		if !equal(l.Head, b.Head) {
+			return false
+		}
+	}
+	return l.Tail == nil && b.Tail == nil
+}
+
+// ListProg evaluates a list expression.
+// Like call arguments, the tail and elements are evaluated last to first.
+type ListProg struct {
+	Elems []Prog
+	Tail  Prog // may be nil
+}
+
+func compileList(n *ast.List) Prog {
+	p := &ListProg{}
+	for _, e := range n.Elems {
+		p.Elems = append(p.Elems, compileExpr(e))
+	}
+	if n.Tail != nil {
+		p.Tail = compileExpr(n.Tail)
+	}
+	return p
+}
+
+func (p *ListProg) Exec(m *Machine) {
+	var tail Value = &List{}
+	if p.Tail != nil {
+		p.Tail.Exec(m)
+		tail = m.RA()
+	}
+	elems := make([]Value, len(p.Elems))
+	for i := len(p.Elems) - 1; i >= 0; i-- {
+		p.Elems[i].Exec(m)
+		elems[i] = m.RA()
+	}
+	m.SetRA(prepend(elems, tail))
+}
+
+// -------- ListPat
+
+type listPat struct {
+	Elems []Pattern
+	Tail  Pattern // may be nil
+}
+
+func compileListPat(n *ast.ListPat) Pattern {
+	p := &listPat{}
+	for _, e := range n.Elems {
+		p.Elems = append(p.Elems, compilePattern(e))
+	}
+	if n.Tail != nil {
+		p.Tail = compilePattern(n.Tail)
+	}
+	return p
+}
+
+func (p *listPat) Match(m *Machine, v Value) bool {
+	l, ok := v.(*List)
+	if !ok {
+		return false
+	}
+	for _, e := range p.Elems {
+		if l.Tail == nil || !e.Match(m, l.Head) {
+			return false
+		}
+		l = l.Tail
+	}
+	if p.Tail == nil {
+		return l.Tail == nil
+	}
+	return p.Tail.Match(m, l)
+}
@@ -35,51 +35,58 @@ func (b Box) String() string {
 	}
 }
 
+// Machine is the stack machine that executes Progs.
+// Stack slots and RA hold plain values, except for the slots of
+// variables captured by a closure, which hold a Box shared with the closure.
 type Machine struct {
-	s  []Box
-	ra Box
-	bp int
+	s        []Value
+	ra       Value
+	bp       int
+	steps    int  // executed function calls
+	maxSteps int  // fail after this many steps, if > 0
+	hook     hook // debugger or profiler, if not nil
+}
+
+// A hook is notified by the Machine of calls, and of functions starting and returning,
+// for the Debugger and Profiler.
+type hook interface {
+	call(p *Call)                 // before a call
+	called(p *Call)               // after a call returned
+	enter(f *LambdaValue, bp int) // when f starts, with its base pointer
+	leave()                       // when the last started function returns
 }
 
 func (m *Machine) SP() int {
 	return len(m.s)
 }
 
-func (m *Machine) Push(b Box) {
-	Log("push", b)
-	m.s = append(m.s, b)
+func (m *Machine) Push(v Value) {
+	m.s = append(m.s, v)
 }
 
-func (m *Machine) Pop() Box {
+func (m *Machine) Pop() Value {
 	v := m.s[len(m.s)-1]
-	Log("pop", v)
 	m.s = m.s[:len(m.s)-1]
 	return v
 }
 
-func (m *Machine) FromBP(delta int) Box {
-	v := m.s[m.bp+delta]
-	Log("fromBP", delta, "=", v)
-	return v
+func (m *Machine) FromBP(delta int) Value {
+	return m.s[m.bp+delta]
 }
 
-//func (m *Machine) SetFromBP(delta int, v Value) {
-//	m.s[m.bp+delta] = v
-//	Log("setfromBP", delta, "to", v)
-//}
+func (m *Machine) SetFromBP(delta int, v Value) {
+	m.s[m.bp+delta] = v
+}
 
-func (m *Machine) FromSP(delta int) Box {
-	v := m.s[m.SP()+delta]
-	Log("fromSP", delta, "=", v)
-	return v
+func (m *Machine) FromSP(delta int) Value {
+	return m.s[m.SP()+delta]
 }
 
-func (m *Machine) SetRA(v Box) {
-	Log("ra=", v)
+func (m *Machine) SetRA(v Value) {
 	m.ra = v
 }
 
-func (m *Machine) RA() Box {
+func (m *Machine) RA() Value {
 	return m.ra
 }
 
@@ -91,18 +98,17 @@ func (m *Machine) SetBP(bp int) {
 	m.bp = bp
 }
 
+// Grow adds delta empty slots to the stack, or removes -delta slots if negative.
 func (m *Machine) Grow(delta int) {
-	Log("grow", delta)
 	newl := len(m.s) + delta
-	//if new >= cap(m.s) {
-	//	m.s = append(m.s, make([]Box, 1+new-cap(m.s))...)
-	//}
-	for i := len(m.s) - 1; i < newl; i++ {
-		m.s = append(m.s, Box{new(Value)})
+	if delta < 0 {
+		for i := newl; i < len(m.s); i++ {
+			m.s[i] = nil // don't hold on to garbage
+		}
+		m.s = m.s[:newl]
+		return
+	}
+	for i := 0; i < delta; i++ {
+		m.s = append(m.s, nil)
 	}
-	m.s = m.s[:newl] // in case we shrink
-}
-
-func Log(x ...interface{}) {
-	fmt.Println(x...)
 }
@@ -0,0 +1,144 @@
+package eva
+
+import (
+	"strconv"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+)
+
+// -------- Match
+
+type Match struct {
+	X     []Prog
+	Tmp   []fromBP
+	Cases []Case
+}
+
+type Case struct {
+	Pats  []Pattern // one per matched value
+	Guard Prog      // may be nil
+	Body  Prog
+}
+
+func compileMatch(n *ast.Match) Prog {
+	p := &Match{}
+	for i, x := range n.X {
+		p.X = append(p.X, compileExpr(x))
+		p.Tmp = append(p.Tmp, compileLocVar(n.Tmp[i].(*ast.LocVar)))
+	}
+	for _, c := range n.Cases {
+		p.Cases = append(p.Cases, compileCase(c, len(n.X)))
+	}
+	return p
+}
+
+func compileCase(n *ast.Case, arity int) Case {
+	c := Case{Body: compileExpr(n.Body)}
+	if n.Guard != nil {
+		c.Guard = compileExpr(n.Guard)
+	}
+	if t, ok := n.Pat.(*ast.Tuple); ok {
+		for _, e := range t.Elems {
+			c.Pats = append(c.Pats, compilePattern(e))
+		}
+	} else {
+		for i := 0; i < arity; i++ {
+			c.Pats = append(c.Pats, compilePattern(n.Pat))
+		}
+	}
+	return c
+}
+
+func (p *Match) Exec(m *Machine) {
+	for i, x := range p.X {
+		x.Exec(m)
+		p.Tmp[i].SetToRA(m)
+	}
+	for _, c := range p.Cases {
+		if c.match(m, p.Tmp) {
+			c.Body.Exec(m)
+			return
+		}
+	}
+	var v []Value
+	for _, t := range p.Tmp {
+		v = append(v, t.Get(m))
+	}
+	panic(se.Errorf("match: no case for %v", v))
+}
+
+// match returns true if all patterns match the values stored in tmp,
+// and the guard, if any, evaluates to true.
+func (c *Case) match(m *Machine, tmp []fromBP) bool {
+	for i, p := range c.Pats {
+		if !p.Match(m, tmp[i].Get(m)) {
+			return false
+		}
+	}
+	if c.Guard != nil {
+		c.Guard.Exec(m)
+		return isTrue(m.RA())
+	}
+	return true
+}
+
+// A Pattern tests a value and binds it to variables if it matches.
+type Pattern interface {
+	Match(m *Machine, v Value) bool
+}
+
+func compilePattern(n ast.Pattern) Pattern {
+	switch n := n.(type) {
+	default:
+		panic(unhandled(n))
+	case *ast.Wildcard:
+		return wildcard{}
+	case *ast.Ident:
+		return bind{compileLocVar(n.Var.(*ast.LocVar))}
+	case *ast.Lit:
+		return compileLit(n)
+	case *ast.CtorPat:
+		return compileCtorPat(n)
+	case *ast.ListPat:
+		return compileListPat(n)
+	case *ast.RecordPat:
+		return compileRecordPat(n)
+	}
+}
+
+type wildcard struct{}
+
+func (wildcard) Match(m *Machine, v Value) bool { return true }
+
+type bind struct {
+	dst fromBP
+}
+
+func (p bind) Match(m *Machine, v Value) bool {
+	p.dst.Set(m, v)
+	return true
+}
+
+type lit struct {
+	v Value
+}
+
+func compileLit(n *ast.Lit) Pattern {
+	switch n.Value {
+	case "true":
+		return lit{true}
+	case "false":
+		return lit{false}
+	}
+	if v, err := strconv.Atoi(n.Value); err == nil {
+		return lit{v}
+	}
+	v, err := strconv.ParseFloat(n.Value, 64)
+	if err != nil {
+		panic(se.Errorf("%v", err))
+	}
+	return lit{v}
+}
+
+func (p lit) Match(m *Machine, v Value) bool { return equal(v, p.v) }
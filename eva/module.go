@@ -0,0 +1,362 @@
+package eva
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/ir"
+)
+
+// Ext is the file extension of source files.
+// It may be omitted from import paths.
+const Ext = ".howl"
+
+// A Loader compiles programs and the modules they import.
+// Compiled modules are cached by absolute file name,
+// so each file is compiled only once, however often it is imported.
+type Loader struct {
+	Path    []string       // directories searched for imports, after the importing file's directory
+	Warn    func(ast.Diag) // called for warnings found during compilation, if not nil
+	NoOpt   bool           // disables optimization
+	modules map[string]*Module
+	loading []string // files being compiled, to detect import cycles
+}
+
+func NewLoader(path ...string) *Loader {
+	return &Loader{Path: path, modules: make(map[string]*Module)}
+}
+
+// CompileFile compiles the program in the named file.
+// Imports are searched relative to the file's directory.
+func (l *Loader) CompileFile(name string) (_ Prog, err error) {
+	defer catch(&err)
+	return compileProgram(l.resolveFile(name)), nil
+}
+
+// CompileAST compiles a program,
+// searching its imports relative to directory dir.
+func (l *Loader) CompileAST(root ast.Node, dir string) (_ Prog, err error) {
+	defer catch(&err)
+	return compileProgram(l.resolveProgram(root, dir)), nil
+}
+
+// CompileCodeFile is like CompileFile, but compiles to bytecode.
+func (l *Loader) CompileCodeFile(name string) (_ *Code, err error) {
+	defer catch(&err)
+	return compileCode(l.resolveFile(name)), nil
+}
+
+// CompileCode is like CompileAST, but compiles to bytecode.
+// Imported modules are compiled into the same Code.
+func (l *Loader) CompileCode(root ast.Node, dir string) (_ *Code, err error) {
+	defer catch(&err)
+	return compileCode(l.resolveProgram(root, dir)), nil
+}
+
+// ResolveFile parses, resolves and optimizes the program in the named file,
+// for backends that translate the AST themselves.
+// Like CompileFile, the program is wrapped in a call of a lambda without arguments.
+func (l *Loader) ResolveFile(name string) (_ ast.Node, err error) {
+	defer catch(&err)
+	return l.resolveFile(name), nil
+}
+
+// ResolveAST is like ResolveFile, for a program that has already been parsed.
+func (l *Loader) ResolveAST(root ast.Node, dir string) (_ ast.Node, err error) {
+	defer catch(&err)
+	return l.resolveProgram(root, dir), nil
+}
+
+// catch recovers from a panic with an se.Error, returning it in *err.
+// Other panics are bugs, and are passed on.
+// It must be deferred by the exported methods,
+// which use panics for errors internally.
+func catch(err *error) {
+	switch e := recover().(type) {
+	case nil: //OK
+	default:
+		panic(e)
+	case se.Error:
+		*err = e
+	}
+}
+
+// resolveFile parses and resolves the program in the named file.
+func (l *Loader) resolveFile(name string) ast.Node {
+	file := l.abs(name)
+	l.push(file)
+	defer l.pop()
+	root := parseFile(file)
+	return l.resolveProgram(root, filepath.Dir(file))
+}
+
+// resolveProgram loads the imports of a program and resolves it.
+func (l *Loader) resolveProgram(root ast.Node, dir string) ast.Node {
+	l.loadImports(root, dir)
+
+	// wrap a {block} in a labmda call (()->{block})()
+	// to provide a call frame for local variables.
+	// TODO: this is a hack
+	root = &ast.Call{
+		F: &ast.Lambda{Body: root},
+	}
+	l.resolve(root)
+	if !l.NoOpt {
+		root = optimize(root, nil)
+	}
+	return root
+}
+
+// compileProgram compiles a program wrapped by resolveProgram,
+// naming its frame "main" for the debugger.
+func compileProgram(root ast.Node) Prog {
+	p := compileExpr(root)
+	if c, ok := p.(*Call); ok {
+		if l, ok := c.F.(*LambdaProg); ok {
+			l.Info.Name = "main"
+		}
+	}
+	return p
+}
+
+// loadImports compiles the modules imported by the top-level statements of n,
+// and stores them in the corresponding ast.Import.
+func (l *Loader) loadImports(n ast.Node, dir string) {
+	b, ok := n.(*ast.Block)
+	if !ok {
+		return
+	}
+	for _, stmt := range b.Stmts {
+		if imp, ok := stmt.(*ast.Import); ok {
+			imp.Module = l.load(l.find(imp.Path, dir))
+		}
+	}
+}
+
+// load returns the compiled module in file, from cache if possible.
+func (l *Loader) load(file string) *Module {
+	if mod, ok := l.modules[file]; ok {
+		return mod
+	}
+
+	l.push(file)
+	defer l.pop()
+
+	root := parseFile(file)
+	l.loadImports(root, filepath.Dir(file))
+	mod := l.compileModule(file, root)
+	l.modules[file] = mod
+	return mod
+}
+
+// find returns the file imported by path p, in a file in directory dir.
+func (l *Loader) find(p, dir string) string {
+	name := p
+	if filepath.Ext(name) == "" {
+		name += Ext
+	}
+	if filepath.IsAbs(name) {
+		return name
+	}
+	dirs := append([]string{dir}, l.Path...)
+	for _, d := range dirs {
+		f := filepath.Join(d, name)
+		if _, err := os.Stat(f); err == nil {
+			return l.abs(f)
+		}
+	}
+	panic(se.Errorf("import %q: not found in %v", p, strings.Join(dirs, ", ")))
+}
+
+func (l *Loader) abs(name string) string {
+	file, err := filepath.Abs(name)
+	if err != nil {
+		panic(se.Errorf("%v", err))
+	}
+	return file
+}
+
+// push marks file as being compiled, or panics if that would complete an import cycle.
+func (l *Loader) push(file string) {
+	for i, f := range l.loading {
+		if f == file {
+			cycle := append(l.loading[i:], file)
+			panic(se.Errorf("import cycle: %v", strings.Join(cycle, " -> ")))
+		}
+	}
+	l.loading = append(l.loading, file)
+}
+
+func (l *Loader) pop() {
+	l.loading = l.loading[:len(l.loading)-1]
+}
+
+// resolve resolves the identifiers in n against the prelude.
+// It panics with the errors found, and passes warnings to l.Warn.
+func (l *Loader) resolve(n ast.Node) {
+	diags := ast.Resolve(n, prelude.Names()...)
+	if err := ast.Errors(diags); err != nil {
+		panic(err)
+	}
+	if l.Warn != nil {
+		for _, d := range diags {
+			l.Warn(d)
+		}
+	}
+}
+
+func parseFile(file string) *ast.Block {
+	f, err := os.Open(file)
+	if err != nil {
+		panic(se.Errorf("%v", err))
+	}
+	defer f.Close()
+	root, err := ast.ParseFile(file, bufio.NewReader(f))
+	if err != nil {
+		panic(se.Errorf("%v", err))
+	}
+	return root
+}
+
+// -------- Module
+
+// Module is a compiled source file that can be imported.
+// It exports all of its top-level definitions.
+// Executing a Module evaluates its definitions into a *ModuleValue,
+// the first time only: however often it is imported, it is initialized once.
+type Module struct {
+	File  string
+	Names []string // exported names
+	Init  Prog
+	value Value // result of Init, once executed
+
+	// for backends that translate the AST themselves
+	root    *ast.Lambda
+	exports []*ast.Ident
+}
+
+func (l *Loader) compileModule(file string, b *ast.Block) *Module {
+	root := &ast.Lambda{Body: b}
+	l.resolve(root)
+	if !l.NoOpt {
+		optimize(root, b) // keep exported definitions
+	}
+
+	boxed := markCaptured(root)
+	exp := &export{}
+	mod := &Module{File: file, root: root}
+	exp.Module = mod
+	body := &Block{Init: compileDecls(b)}
+	add := func(id *ast.Ident) {
+		mod.Names = append(mod.Names, id.Name)
+		mod.exports = append(mod.exports, id)
+		exp.Vars = append(exp.Vars, compileLocVar(id.Var.(*ast.LocVar)))
+	}
+
+	for _, stmt := range b.Stmts {
+		switch stmt := stmt.(type) {
+		case *ast.Assign:
+			body.Init = append(body.Init, compileAssign(stmt))
+			add(stmt.LHS)
+		case *ast.TypeDef:
+			for _, c := range stmt.Ctors {
+				add(c.Name)
+			}
+		default:
+			// imports are not exported,
+			// and the result of a program is not used when it is imported
+		}
+	}
+
+	body.Expr = exp
+	info := newFuncInfo(root)
+	info.Name = mod.String()
+	mod.Init = &Call{F: &LambdaProg{Body: body, Boxed: boxed, NumLocals: root.NumVar, Info: info}}
+	return mod
+}
+
+var _ ir.ModuleAST = (*Module)(nil)
+
+// AST returns the module's file, resolved AST and exported definitions.
+func (mod *Module) AST() (file string, root *ast.Lambda, exports []*ast.Ident) {
+	return mod.File, mod.root, mod.exports
+}
+
+func (mod *Module) Exec(m *Machine) {
+	if mod.value == nil {
+		mod.Init.Exec(m)
+		mod.value = m.RA()
+	}
+	m.SetRA(mod.value)
+}
+
+func (mod *Module) String() string {
+	return ast.ModuleName(mod.File)
+}
+
+// export collects a module's definitions into a *ModuleValue.
+type export struct {
+	Module *Module
+	Vars   []fromBP
+}
+
+func (p *export) Exec(m *Machine) {
+	v := &ModuleValue{Module: p.Module}
+	for _, x := range p.Vars {
+		v.Values = append(v.Values, x.Get(m))
+	}
+	m.SetRA(v)
+}
+
+// ModuleValue holds the values of a Module's exported definitions.
+type ModuleValue struct {
+	Module *Module
+	Values []Value
+}
+
+func (v *ModuleValue) Get(name string) Value {
+	for i, n := range v.Module.Names {
+		if n == name {
+			return v.Values[i]
+		}
+	}
+	panic(se.Errorf("undefined: %v.%v", v.Module, name))
+}
+
+func (v *ModuleValue) String() string {
+	return fmt.Sprint("module ", v.Module)
+}
+
+// -------- Import
+
+func compileImport(n *ast.Import) Assign {
+	mod, ok := n.Module.(*Module)
+	if !ok {
+		panic(se.Errorf("import %q: only allowed at the top level of a file", n.Path))
+	}
+	return Assign{
+		LHS: compileLocVar(n.Name.Var.(*ast.LocVar)),
+		RHS: mod,
+	}
+}
+
+// -------- Select
+
+type Select struct {
+	X   Prog
+	Sel string
+}
+
+func compileSelect(n *ast.Select) Prog {
+	return &Select{X: compileExpr(n.X), Sel: n.Sel}
+}
+
+func (p *Select) Exec(m *Machine) {
+	p.X.Exec(m)
+	m.SetRA(member(m.RA(), p.Sel))
+}
@@ -0,0 +1,129 @@
+package eva
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+)
+
+func TestImport(t *testing.T) {
+	cases := []struct {
+		src  string
+		want interface{}
+	}{
+		{`import "testdata/lib/util.howl"; util.square(3)`, 9},
+		{`import "testdata/lib/util"; util.square(3)`, 9},
+		{`import u "testdata/lib/util"; u.square(3)`, 9},
+		{`import "testdata/lib/util"; util.quad(3)`, 12},
+		{`import "testdata/lib/util"; util.twice(util.square)(3)`, 81},
+		{`import "testdata/lib/util"; util.orElse(util.Some(1), 2)`, 1},
+		{`import "testdata/lib/util"; util.orElse(util.None, 2)`, 2},
+		{`import "testdata/lib/util"; f = util.square; f(4)`, 16},
+		{`import "testdata/lib/util"; f = x -> util.square(x); f(4)`, 16},
+		{`import "testdata/lib/prime"; prime.isPrime(7)`, true},
+		{`import "testdata/lib/prime"; prime.isPrime(9)`, false},
+		{`import "prime"; prime.isPrime(13)`, true}, // search path
+		{`import "prime"; import "util"; util.square(2) + (prime.isPrime(2)? 1: 0)`, 5},
+	}
+
+	for _, c := range cases {
+		n, err := ast.ParseProgram(strings.NewReader(c.src))
+		if err != nil {
+			t.Errorf("%v: %v", c.src, err)
+			continue
+		}
+		prog, err := NewLoader("testdata/lib").CompileAST(n, ".")
+		if err != nil {
+			t.Errorf("%v: %v", c.src, err)
+			continue
+		}
+		if have, err := Eval(prog); err != nil || have != c.want {
+			t.Errorf("%v: have %v, %v, want: %v", c.src, have, err, c.want)
+		}
+
+		n, _ = ast.ParseProgram(strings.NewReader(c.src))
+		code, err := NewLoader("testdata/lib").CompileCode(n, ".")
+		if err != nil {
+			t.Errorf("vm: %v: %v", c.src, err)
+			continue
+		}
+		if have, err := Run(code); err != nil || have != c.want {
+			t.Errorf("vm: %v: have %v, %v, want: %v", c.src, have, err, c.want)
+		}
+	}
+}
+
+func TestImportError(t *testing.T) {
+	cases := []string{
+		`import "testdata/nonexistent"; 1`,
+		`import "testdata/cycle/a"; 1`,
+		`import "testdata/lib/util"; util.cube(1)`,
+		`import "testdata/lib/util"; util.x`, // x is not a top-level definition
+		`x = 1; x.y`,
+		`(()->{import "testdata/lib/util"; 1})()`,
+		`import "testdata/lib/util"; type Opt = None | Some(x); util.orElse(Some(1), 2)`, // not util's Opt
+	}
+
+	for _, src := range cases {
+		prog, err := Compile(strings.NewReader(src))
+		if err == nil {
+			var v Value
+			v, err = Eval(prog)
+			if err == nil {
+				t.Errorf("%v: expected error, have: %v", src, v)
+			}
+		}
+	}
+}
+
+func TestCompileFile(t *testing.T) {
+	l := NewLoader()
+	prog, err := l.CompileFile("testdata/main.howl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if have, err := Eval(prog); err != nil || have != 9 {
+		t.Errorf("have %v, %v, want: %v", have, err, 9)
+	}
+
+	// util is imported by main and by prime, but compiled once.
+	if len(l.modules) != 2 {
+		t.Errorf("compiled %v modules, want 2", len(l.modules))
+	}
+}
+
+// util is imported by prime, so importing it as well must not run its definitions again.
+func TestImportOnce(t *testing.T) {
+	steps := func(src string) (tree, vm int) {
+		t.Helper()
+		n, _ := ast.ParseProgram(strings.NewReader(src))
+		prog, err := NewLoader("testdata/lib").CompileAST(n, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, tree, err = EvalSteps(prog); err != nil {
+			t.Fatal(err)
+		}
+		n, _ = ast.ParseProgram(strings.NewReader(src))
+		code, err := NewLoader("testdata/lib").CompileCode(n, ".")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, vm, err = RunSteps(code); err != nil {
+			t.Fatal(err)
+		}
+		return tree, vm
+	}
+
+	tree0, vm0 := steps(`1`)
+	treeUtil, vmUtil := steps(`import "util"; 1`)
+	treePrime, vmPrime := steps(`import "prime"; 1`)
+	treeBoth, vmBoth := steps(`import "util"; import "prime"; 1`)
+	if treeBoth-treePrime >= treeUtil-tree0 {
+		t.Errorf("tree: util initialized twice: %v steps, %v without importing util", treeBoth, treePrime)
+	}
+	if vmBoth-vmPrime >= vmUtil-vm0 {
+		t.Errorf("vm: util initialized twice: %v steps, %v without importing util", vmBoth, vmPrime)
+	}
+}
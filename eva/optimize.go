@@ -0,0 +1,218 @@
+package eva
+
+import (
+	"strconv"
+
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/lex"
+)
+
+// optimize rewrites a resolved AST into an equivalent one that runs faster:
+// small functions are inlined,
+// calls of built-in functions with constant arguments are folded into constants,
+// conditionals with a constant test are replaced by the taken branch,
+// and assignments to variables that are never used are removed, unless they can fail.
+//
+// The assignments of the block keep, if not nil, are not removed
+// (e.g. the exported definitions of a module).
+func optimize(root ast.Node, keep *ast.Block) ast.Node {
+	root = inline(root)
+	root = fold(root)
+	removeUnused(root, keep)
+	return root
+}
+
+// fold folds constant expressions in n, and returns the result.
+func fold(n ast.Node) ast.Node {
+	switch n := n.(type) {
+	default:
+		panic(unhandled(n))
+	case *ast.Assign:
+		n.RHS = fold(n.RHS)
+	case *ast.Block:
+		for i, s := range n.Stmts {
+			n.Stmts[i] = fold(s)
+		}
+	case *ast.Call:
+		n.F = fold(n.F)
+		for i, a := range n.Args {
+			n.Args[i] = fold(a)
+		}
+		if c, ok := foldCall(n); ok {
+			return c
+		}
+	case *ast.Cond:
+		n.Test = fold(n.Test)
+		n.If = fold(n.If)
+		n.Else = fold(n.Else)
+		if t, ok := constValue(n.Test).(bool); ok {
+			if t {
+				return n.If
+			}
+			return n.Else
+		}
+	case *ast.Lambda:
+		n.Body = fold(n.Body)
+	case *ast.List:
+		for i, e := range n.Elems {
+			n.Elems[i] = fold(e)
+		}
+		if n.Tail != nil {
+			n.Tail = fold(n.Tail)
+		}
+	case *ast.Logic:
+		n.X = fold(n.X)
+		n.Y = fold(n.Y)
+		if x, ok := constValue(n.X).(bool); ok {
+			if x == (n.Op == lex.TOr) {
+				return n.X // x determines the result
+			}
+			return n.Y
+		}
+	case *ast.Match:
+		for i, x := range n.X {
+			n.X[i] = fold(x)
+		}
+		for _, c := range n.Cases {
+			if c.Guard != nil {
+				c.Guard = fold(c.Guard)
+			}
+			c.Body = fold(c.Body)
+		}
+	case *ast.Record:
+		for i, x := range n.Fields {
+			n.Fields[i] = fold(x)
+		}
+	case *ast.Select:
+		n.X = fold(n.X)
+	case *ast.Ident, *ast.Import, *ast.Num, *ast.Str, *ast.TypeDef:
+		// nothing to do
+	}
+	return n
+}
+
+// foldCall evaluates a call of a built-in function with constant arguments.
+// It returns false if the call is not constant,
+// or if it fails, so that the error is reported at run time.
+func foldCall(n *ast.Call) (_ ast.Node, ok bool) {
+	id, isIdent := n.F.(*ast.Ident)
+	if !isIdent || id.Var != nil {
+		return nil, false
+	}
+	f, isFunc := prelude.Find(id.Name).(Applier)
+	if !isFunc {
+		return nil, false
+	}
+	var m Machine
+	for i := len(n.Args) - 1; i >= 0; i-- {
+		v := constValue(n.Args[i])
+		if v == nil {
+			return nil, false
+		}
+		m.Push(v)
+	}
+
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+	f.Apply(&m)
+	return constNode(m.RA())
+}
+
+// constValue returns the value of a constant node, or nil if n is not constant.
+func constValue(n ast.Node) Value {
+	switch n := n.(type) {
+	case *ast.Num:
+		return valueOf(compileNum(n))
+	case *ast.Ident:
+		if n.Var == nil && (n.Name == "true" || n.Name == "false") {
+			return n.Name == "true"
+		}
+	}
+	return nil
+}
+
+// constNode returns a node that evaluates to v, if there is one.
+func constNode(v Value) (ast.Node, bool) {
+	var n ast.Node
+	switch v := v.(type) {
+	case bool:
+		n = &ast.Ident{Name: strconv.FormatBool(v)}
+	case int:
+		n = &ast.Num{Value: strconv.Itoa(v)}
+	case float64:
+		n = &ast.Num{Value: strconv.FormatFloat(v, 'g', -1, 64)}
+	default:
+		return nil, false
+	}
+	if constValue(n) != v {
+		return nil, false // e.g. float with integer value would become int
+	}
+	return n, true
+}
+
+// removeUnused removes assignments to unused variables from all blocks but keep,
+// if evaluating them cannot fail, see removable.
+// Removing an assignment may make other variables unused,
+// so this is repeated until there is nothing left to remove.
+func removeUnused(root ast.Node, keep *ast.Block) {
+	for {
+		used := uses(root)
+		removed := false
+		ast.Walk(root, func(n ast.Node) bool {
+			b, ok := n.(*ast.Block)
+			if !ok || b == keep {
+				return true
+			}
+			stmts := b.Stmts[:0]
+			for _, s := range b.Stmts {
+				if a, ok := s.(*ast.Assign); ok && !used[a.LHS.Var] && removable(a.RHS) {
+					removed = true
+					continue
+				}
+				stmts = append(stmts, s)
+			}
+			b.Stmts = stmts
+			return true
+		})
+		if !removed {
+			return
+		}
+	}
+}
+
+// removable returns true if evaluating n cannot fail or have an effect,
+// so that an unused assignment of n can be removed.
+// Calls can: e.g. 1/0 or assert(false, "").
+func removable(n ast.Node) bool {
+	switch n.(type) {
+	case *ast.Lambda, *ast.Num, *ast.Str, *ast.Ident:
+		return true
+	}
+	return false
+}
+
+// uses returns the variables referred to in n,
+// directly or by being captured.
+func uses(n ast.Node) map[ast.Var]bool {
+	used := make(map[ast.Var]bool)
+	var visit func(n ast.Node) bool
+	visit = func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Assign:
+			ast.Walk(n.RHS, visit) // LHS is a declaration, not a use
+			return false
+		case *ast.Ident:
+			used[n.Var] = true
+		case *ast.Lambda:
+			for _, c := range n.Caps {
+				used[c.Src] = true
+			}
+		}
+		return true
+	}
+	ast.Walk(n, visit)
+	return used
+}
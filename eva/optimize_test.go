@@ -0,0 +1,147 @@
+package eva
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+)
+
+func TestOptimize(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{`1+2*3%4`, `{3;}`},
+		{`1<2`, `{true??;}`},
+		{`-(2-5)`, `{3;}`},
+		{`!(1==1)`, `{false??;}`},
+		{`x = 2; x*3`, `{x:L0=2;mul??(x:L0, 3);}`},
+		{`f = x -> x*(2+3); f(1)`, `{{x:L1=1;mul??(x:L1, 5);};}`},
+		{`true? 1: 2`, `{1;}`},
+		{`1>2? 1: 2`, `{2;}`},
+		{`f = x -> x; 1<2? f(1): f(2)`, `{{x:L1=1;x:L1;};}`},
+		{`f = x -> x; 1<2 || f(true)`, `{true??;}`},
+		{`x = 1; 1>2 || x > 0`, `{x:L0=1;gt??(x:L0, 0);}`},
+		{`x = 1; y = x; 2`, `{2;}`},
+		{`x = 1; f = () -> x; g = () -> f(); 2`, `{2;}`},
+		{`x = 1; f = () -> x; f()`, `{x:L0=1;x:L0;}`},
+		{`(x -> x)(1)`, `{{x:L0=1;x:L0;};}`},
+		{`(x -> y -> x+y)(1)`, `{{x:L0=1;((y:$0)->[{x L0 L0},]add??(x:L0, y:$0));};}`},
+		{`f = x -> {y = x; y}; f(1)`, `{{x:L1=1;{y:L2=x:L1;y:L2;};};}`},
+		{`f = x -> f(x); f(1)`, `{f:L0=((x:$0)->[{f L0 L0},]f:L0(x:$0));f:L0(1);}`},                                                                    // recursive
+		{`f = x -> x; f(1, 2)`, `{f:L0=((x:$0)->x:$0);f:L0(1, 2);}`},                                                                                   // wrong arity
+		{`f = x -> x; g = y -> f(y); g`, `{f:L0=((x:$0)->x:$0);g:L1=((y:$0)->[{f L0 L0},]{x:L1=y:$0;x:L1;});g:L1;}`},                                   // captured
+		{`a = 1; f = x -> x+a; g = y -> f(y); g`, `{a:L0=1;f:L1=((x:$0)->[{a L0 L0},]add??(x:$0, a:L0));g:L2=((y:$0)->[{f L1 L0},]f:L0(y:$0));g:L2;}`}, // captured, with captures
+		{`1%0`, `{mod??(1, 0);}`}, // fails at run time
+	}
+
+	for _, c := range cases {
+		n, err := ast.ParseProgram(strings.NewReader(c.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := NewLoader().resolveProgram(n, ".")
+		have := ast.ToString(root.(*ast.Call).F.(*ast.Lambda).Body)
+		if have != c.want {
+			t.Errorf("%v: have %v, want %v", c.src, have, c.want)
+		}
+	}
+}
+
+// The optimizer must not change what a program does:
+// on every backend, it gives the same value, or fails, like unoptimized.
+func TestOptimizeSame(t *testing.T) {
+	cases := []string{
+		`{x = 1 / 0; 2}`,
+		`{x = 1 % 0; y = x; 2}`,
+		`f = x -> {c = assert(x > 0, "positive"); x}; f(-1)`,
+		`ok = assertEq(1, 2); true`,
+		`(x -> 2)(1 / 0)`,
+		`f = x -> 2; f(1 / 0)`,
+		`{x = y; y = 2; 3}`,
+		`{x = 1; f = () -> x; 2}`,
+	}
+	var noopt func(string) (Value, error)
+	for _, b := range backends {
+		if b.name == "noopt" {
+			noopt = b.eval
+		}
+	}
+	for _, src := range cases {
+		want, wantErr := noopt(src)
+		for _, b := range backends {
+			// error messages differ between backends, e.g. in positions
+			if have, err := b.eval(src); have != want || (err == nil) != (wantErr == nil) {
+				t.Errorf("%v: %v: have %v, %v, unoptimized: %v, %v", b.name, src, have, err, want, wantErr)
+			}
+		}
+	}
+}
+
+// Arguments are evaluated last to first, also when inlined,
+// so that every backend reports the same error if several arguments fail.
+func TestOptimizeArgOrder(t *testing.T) {
+	cases := []string{
+		`f = (a, b) -> a; f(assert(false, "a"), assert(false, "b"))`,
+		`((a, b) -> a)(assert(false, "a"), assert(false, "b"))`,
+		`f = (a, b, c) -> a; f(assert(false, "a"), assert(false, "b"), 1)`,
+	}
+	for _, src := range cases {
+		for _, b := range backends {
+			if have, err := b.eval(src); err == nil || !strings.HasSuffix(err.Error(), "assertion failed: b") {
+				t.Errorf("%v: %v: have %v, %v, want error: assertion failed: b", b.name, src, have, err)
+			}
+		}
+	}
+}
+
+// benchInline is dominated by calls of small functions, which are inlined.
+const benchInline = `
+	square = x -> x*x;
+	plus = (a, b) -> a + b;
+	loop = (n, acc) -> n == 0? acc: loop(n-1, plus(acc, square(n)%7));
+	loop(1000, 0)`
+
+func BenchmarkInline(b *testing.B) {
+	for _, noOpt := range []bool{true, false} {
+		l := NewLoader()
+		l.NoOpt = noOpt
+		name := "opt"
+		if noOpt {
+			name = "noopt"
+		}
+
+		n, err := ast.ParseProgram(strings.NewReader(benchInline))
+		if err != nil {
+			b.Fatal(err)
+		}
+		prog, err := l.CompileAST(n, ".")
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run("tree/"+name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := Eval(prog); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+
+		n, err = ast.ParseProgram(strings.NewReader(benchInline))
+		if err != nil {
+			b.Fatal(err)
+		}
+		code, err := l.CompileCode(n, ".")
+		if err != nil {
+			b.Fatal(err)
+		}
+		b.Run("vm/"+name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := Run(code); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package eva
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// WritePprof writes the profile in the gzipped protocol buffer format
+// read by 'go tool pprof', with the steps as sample values.
+// See https://github.com/google/pprof/blob/master/proto/profile.proto
+func (p *Profile) WritePprof(w io.Writer) error {
+	var b protobuf
+	strs := map[string]int{}
+	var table []string
+	str := func(s string) uint64 {
+		if i, ok := strs[s]; ok {
+			return uint64(i)
+		}
+		strs[s] = len(table)
+		table = append(table, s)
+		return uint64(len(table) - 1)
+	}
+	str("") // string 0 must be empty
+
+	valueType := func(field int, typ, unit string) {
+		b.message(field, func(b *protobuf) {
+			b.uint64(1, str(typ))
+			b.uint64(2, str(unit))
+		})
+	}
+	valueType(1, "steps", "count") // sample_type
+
+	// functions and locations are numbered from 1, in order of appearance
+	funcs := map[*funcInfo]uint64{}
+	var funcList []*funcInfo
+	locs := map[location]uint64{}
+	var locList []location
+	for _, n := range p.samples {
+		var ids []uint64
+		for a := n; a.parent != nil; a = a.parent {
+			if _, ok := funcs[a.loc.fn]; !ok {
+				funcList = append(funcList, a.loc.fn)
+				funcs[a.loc.fn] = uint64(len(funcList))
+			}
+			if _, ok := locs[a.loc]; !ok {
+				locList = append(locList, a.loc)
+				locs[a.loc] = uint64(len(locList))
+			}
+			ids = append(ids, locs[a.loc])
+		}
+		b.message(2, func(b *protobuf) { // sample
+			b.uint64s(1, ids) // location_id, innermost first
+			b.uint64s(2, []uint64{uint64(n.self)})
+		})
+	}
+	for i, l := range locList {
+		b.message(4, func(b *protobuf) { // location
+			b.uint64(1, uint64(i+1))
+			b.message(4, func(b *protobuf) { // line
+				b.uint64(1, funcs[l.fn])
+				b.uint64(2, uint64(l.line))
+			})
+		})
+	}
+	for i, info := range funcList {
+		name, file, line := info.name(), "", 0
+		if info != nil {
+			file, line = info.Pos.Filename, info.Pos.Line
+		}
+		b.message(5, func(b *protobuf) { // function
+			b.uint64(1, uint64(i+1))
+			b.uint64(2, str(name))
+			b.uint64(3, str(name)) // system_name
+			b.uint64(4, str(file))
+			b.uint64(5, uint64(line))
+		})
+	}
+	valueType(11, "steps", "count") // period_type
+	b.uint64(12, 1)                 // period
+	for _, s := range table {
+		b.bytes(6, []byte(s)) // string_table, after all strings are known
+	}
+
+	z := gzip.NewWriter(w)
+	if _, err := z.Write(b.Bytes()); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// protobuf encodes protocol buffer messages.
+type protobuf struct {
+	bytes.Buffer
+}
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		b.WriteByte(byte(x) | 0x80)
+		x >>= 7
+	}
+	b.WriteByte(byte(x))
+}
+
+// uint64 writes a varint field. Zero, the default, is omitted.
+func (b *protobuf) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+// uint64s writes a packed repeated varint field.
+func (b *protobuf) uint64s(field int, xs []uint64) {
+	var p protobuf
+	for _, x := range xs {
+		p.varint(x)
+	}
+	b.bytes(field, p.Bytes())
+}
+
+// bytes writes a length-delimited field: bytes, a string or a packed repeated field.
+func (b *protobuf) bytes(field int, x []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(x)))
+	b.Write(x)
+}
+
+func (b *protobuf) message(field int, f func(*protobuf)) {
+	var m protobuf
+	f(&m)
+	b.bytes(field, m.Bytes())
+}
@@ -1,22 +1,45 @@
 package eva
 
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+
+	se "github.com/barnex/se-lang"
+)
+
 var prelude = pkg{
-	"add":   fn2(add),
-	"sub":   fn2(sub),
-	"and":   fn2(and),
-	"eq":    fn2(eq),
-	"false": &Const{false},
-	"ge":    fn2(ge),
-	"gt":    fn2(gt),
-	"le":    fn2(le),
-	"lt":    fn2(lt),
-	"mod":   fn2(mod),
-	"mul":   fn2(mul),
-	"neg":   fn1(neg),
-	"neq":   fn2(neq),
-	"not":   fn1(not),
-	"or":    fn2(or),
-	"true":  &Const{true},
+	"add":      fn2(add),
+	"andThen":  hof2(andThen),
+	"assert":   fn2(assertTrue),
+	"assertEq": fn2(assertEqual),
+	"sub":      fn2(sub),
+	"and":      fn2(and),
+	"compose":  hof2(compose),
+	"div":      fn2(div),
+	"eq":       fn2(eq),
+	"false":    &Const{false},
+	"ge":       fn2(ge),
+	"gt":       fn2(gt),
+	"le":       fn2(le),
+	"lt":       fn2(lt),
+	"mod":      fn2(mod),
+	"mul":      fn2(mul),
+	"neg":      fn1(neg),
+	"neq":      fn2(neq),
+	"not":      fn1(not),
+	"or":       fn2(or),
+	"pipe":     hof2(pipe),
+	"true":     &Const{true},
+}
+
+// Builtins returns the names of the built-in functions and constants, sorted.
+// They are in scope in every program.
+func Builtins() []string {
+	return prelude.Names()
 }
 
 type pkg map[string]Prog
@@ -25,15 +48,26 @@ func (p pkg) Find(name string) Prog {
 	return p[name]
 }
 
+// Names returns the names defined in the package, sorted.
+func (p pkg) Names() []string {
+	var names []string
+	for n := range p {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
 type fn1 func(a Value) Value
 
 func (f fn1) Exec(m *Machine) {
-	m.SetRA(box(f))
+	m.SetRA(f)
 }
 
 func (f fn1) Apply(m *Machine) {
-	a := m.FromSP(-1).Get()
-	m.SetRA(box(f(a)))
+	a := m.FromSP(-1)
+	defer badOperands(a)
+	m.SetRA(f(a))
 }
 
 func neg(a Value) Value { return -a.(int) }
@@ -42,24 +76,162 @@ func not(a Value) Value { return !a.(bool) }
 type fn2 func(a, b Value) Value
 
 func (f fn2) Exec(m *Machine) {
-	m.SetRA(box(f))
+	m.SetRA(f)
 }
 
 func (f fn2) Apply(m *Machine) {
-	a := m.FromSP(-1).Get()
-	b := m.FromSP(-2).Get()
-	m.SetRA(box(f(a, b)))
+	a := m.FromSP(-1)
+	b := m.FromSP(-2)
+	defer badOperands(a, b)
+	m.SetRA(f(a, b))
+}
+
+// hof2 is a built-in function of two arguments that calls function values,
+// for which it needs the Machine.
+type hof2 func(m *Machine, a, b Value) Value
+
+func (f hof2) Exec(m *Machine) {
+	m.SetRA(f)
+}
+
+func (f hof2) Apply(m *Machine) {
+	a := m.FromSP(-1)
+	b := m.FromSP(-2)
+	m.SetRA(f(m, a, b))
+}
+
+// badOperands reports a built-in applied to values of the wrong type,
+// which panic with a failed type assertion, as an se.Error.
+func badOperands(args ...Value) {
+	switch e := recover().(type) {
+	case nil: //OK
+	default:
+		panic(e)
+	case *runtime.TypeAssertionError:
+		var s []string
+		for _, a := range args {
+			s = append(s, quote(a))
+		}
+		panic(se.Errorf("bad operands: %v", strings.Join(s, ", ")))
+	}
 }
 
 func add(a, b Value) Value { return a.(int) + b.(int) }
 func and(a, b Value) Value { return a.(bool) && b.(bool) }
-func eq(a, b Value) Value  { return a == b }
+func eq(a, b Value) Value  { return equal(a, b) }
 func ge(a, b Value) Value  { return a.(int) >= b.(int) }
 func gt(a, b Value) Value  { return a.(int) > b.(int) }
 func le(a, b Value) Value  { return a.(int) <= b.(int) }
 func lt(a, b Value) Value  { return a.(int) < b.(int) }
 func mul(a, b Value) Value { return a.(int) * b.(int) }
-func neq(a, b Value) Value { return a != b }
+func neq(a, b Value) Value { return !equal(a, b) }
 func or(a, b Value) Value  { return a.(bool) || b.(bool) }
 func sub(a, b Value) Value { return a.(int) - b.(int) }
-func mod(a, b Value) Value { return a.(int) % b.(int) }
+func div(a, b Value) Value { return a.(int) / nonZero(b) }
+func mod(a, b Value) Value { return a.(int) % nonZero(b) }
+
+// pipe implements x |> f: f(x).
+func pipe(m *Machine, x, f Value) Value {
+	return call(m, f, x)
+}
+
+// compose implements g << f, and andThen f >> g:
+// the function that applies f and then g.
+func compose(m *Machine, g, f Value) Value {
+	return &composed{g: applier(g), f: applier(f)}
+}
+
+func andThen(m *Machine, f, g Value) Value {
+	return compose(m, g, f)
+}
+
+type composed struct {
+	g, f Applier
+}
+
+var _ Applier = (*composed)(nil)
+
+func (c *composed) Apply(m *Machine) {
+	x := m.FromSP(-1)
+	m.SetRA(call(m, c.g, call(m, c.f, x)))
+}
+
+func (c *composed) String() string {
+	return "lambda"
+}
+
+// call calls function value f with args, from a built-in function.
+func call(m *Machine, f Value, args ...Value) Value {
+	a := applier(f)
+	checkArgs(a, len(args))
+	for i := len(args) - 1; i >= 0; i-- {
+		m.Push(args[i])
+	}
+	a.Apply(m)
+	m.Grow(-len(args))
+	return m.RA()
+}
+
+// nonZero returns the divisor b, or fails if it is zero.
+func nonZero(b Value) int {
+	if b.(int) == 0 {
+		panic(se.Errorf("division by zero"))
+	}
+	return b.(int)
+}
+
+// assertTrue and assertEqual implement assert and assertEq,
+// which fail the program if their condition does not hold, for tests run by 'se test'.
+// They return true, so they can be combined with &&.
+func assertTrue(cond, msg Value) Value {
+	if !cond.(bool) {
+		panic(se.Errorf("assertion failed: %v", msg))
+	}
+	return true
+}
+
+func assertEqual(have, want Value) Value {
+	if !equal(have, want) {
+		panic(se.Errorf("have %v, want %v", quote(have), quote(want)))
+	}
+	return true
+}
+
+// quote formats a value, quoting strings to tell them apart from other values.
+func quote(v Value) string {
+	if s, ok := v.(string); ok {
+		return strconv.Quote(s)
+	}
+	return fmt.Sprint(v)
+}
+
+// positioned are the built-ins whose errors report where they are called.
+var positioned = map[string]bool{
+	"assert":   true,
+	"assertEq": true,
+}
+
+// atPos is a built-in that prefixes its errors with the position of its identifier,
+// which is usually the position of the call.
+// In bytecode, it is a constant encoded with its position.
+type atPos struct {
+	f   fn2
+	pos se.Position
+}
+
+func (p *atPos) Exec(m *Machine) {
+	m.SetRA(p)
+}
+
+func (p *atPos) Apply(m *Machine) {
+	defer func() {
+		switch e := recover().(type) {
+		case nil: //OK
+		default:
+			panic(e)
+		case se.Error:
+			panic(se.Errorf("%v:%v:%v: %v", filepath.Base(p.pos.Filename), p.pos.Line, p.pos.Column, e))
+		}
+	}()
+	p.f.Apply(m)
+}
@@ -0,0 +1,272 @@
+package eva
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+
+	se "github.com/barnex/se-lang"
+)
+
+// EvalProfile is like Eval, but counts the steps (function calls) of the program
+// per function and per source line.
+// The profile is returned even if the program fails.
+//
+// Programs compiled with optimization may have small functions inlined
+// into their callers, which then do not show up in the profile.
+func EvalProfile(p Prog) (_ Value, prof *Profile, err error) {
+	pr := &profiler{calls: make(map[*funcInfo]int)}
+	pr.root.nodes = new(int)
+	defer func() {
+		prof = pr.profile()
+		switch e := recover().(type) {
+		case nil: //OK
+		default:
+			panic(e)
+		case se.Error:
+			err = e
+		}
+	}()
+
+	m := Machine{hook: pr}
+	p.Exec(&m)
+	if len(m.s) != 0 {
+		return nil, nil, fmt.Errorf("left dirty stack: %v", m.s)
+	}
+	return m.RA(), nil, nil
+}
+
+// A Profile holds the steps executed by a program, see EvalProfile.
+type Profile struct {
+	Steps int         // total number of steps
+	Funcs []FuncCount // by decreasing total steps
+	Lines []LineCount // by decreasing total steps
+
+	samples []*stackNode // stacks that executed steps, for WritePprof
+}
+
+// FuncCount holds the calls and steps of a function.
+// Total counts the steps of recursive calls only once.
+type FuncCount struct {
+	Name  string
+	Pos   se.Position
+	Calls int // times the function was called
+	Self  int // steps in the function's own body
+	Total int // steps in the function and the functions it called
+}
+
+// LineCount holds the steps of a source line.
+type LineCount struct {
+	File  string
+	Line  int
+	Self  int // calls made on the line
+	Total int // steps of the calls made on the line, including the steps of the called functions
+}
+
+// profiler is the Machine hook that records the stacks executing each step.
+type profiler struct {
+	root   stackNode
+	frames []*stackNode // callers of the running functions, innermost last
+	infos  []*funcInfo  // the running functions, innermost last
+	active []*stackNode // stacks of the calls being executed, innermost last
+	calls  map[*funcInfo]int
+}
+
+// location is a source line in a function.
+type location struct {
+	fn   *funcInfo
+	file string
+	line int
+}
+
+// stackNode is a call stack, as a node in the tree of all stacks,
+// with the number of steps executed with exactly that stack.
+type stackNode struct {
+	parent   *stackNode // nil for the root: no stack
+	loc      location   // innermost location
+	id       int        // order of creation
+	self     int
+	children map[location]*stackNode
+	nodes    *int // number of nodes in the tree, shared by all nodes
+}
+
+func (n *stackNode) child(loc location) *stackNode {
+	if c, ok := n.children[loc]; ok {
+		return c
+	}
+	if n.children == nil {
+		n.children = make(map[location]*stackNode)
+	}
+	*n.nodes++
+	c := &stackNode{parent: n, loc: loc, id: *n.nodes, nodes: n.nodes}
+	n.children[loc] = c
+	return c
+}
+
+func (p *profiler) call(c *Call) {
+	if len(p.frames) == 0 {
+		p.active = append(p.active, nil) // the call of the program itself
+		return
+	}
+	i := len(p.frames) - 1
+	n := p.frames[i].child(location{p.infos[i], c.Pos.Filename, c.Pos.Line})
+	n.self++
+	p.active = append(p.active, n)
+}
+
+func (p *profiler) called(c *Call) {
+	p.active = p.active[:len(p.active)-1]
+}
+
+func (p *profiler) enter(f *LambdaValue, bp int) {
+	callers := &p.root
+	if len(p.active) > 0 && p.active[len(p.active)-1] != nil {
+		callers = p.active[len(p.active)-1]
+	}
+	p.frames = append(p.frames, callers)
+	p.infos = append(p.infos, f.Info)
+	p.calls[f.Info]++
+}
+
+func (p *profiler) leave() {
+	p.frames = p.frames[:len(p.frames)-1]
+	p.infos = p.infos[:len(p.infos)-1]
+}
+
+// profile sums the steps of all stacks per function and per line.
+func (p *profiler) profile() *Profile {
+	prof := &Profile{}
+	funcs := make(map[*funcInfo]*FuncCount)
+	fn := func(info *funcInfo) *FuncCount {
+		if f, ok := funcs[info]; ok {
+			return f
+		}
+		f := &FuncCount{Name: info.name()}
+		if info != nil {
+			f.Pos = info.Pos
+		}
+		funcs[info] = f
+		return f
+	}
+	type fileLine struct {
+		file string
+		line int
+	}
+	lines := make(map[fileLine]*LineCount)
+
+	var walk func(n *stackNode)
+	walk = func(n *stackNode) {
+		for _, c := range n.children {
+			walk(c)
+		}
+		if n.self == 0 {
+			return
+		}
+		prof.Steps += n.self
+		prof.samples = append(prof.samples, n)
+		fn(n.loc.fn).Self += n.self
+		countedF := make(map[*FuncCount]bool)
+		countedL := make(map[*LineCount]bool)
+		for a := n; a.parent != nil; a = a.parent {
+			fl := fileLine{a.loc.file, a.loc.line}
+			l, ok := lines[fl]
+			if !ok {
+				l = &LineCount{File: fl.file, Line: fl.line}
+				lines[fl] = l
+			}
+			if a == n {
+				l.Self += n.self
+			}
+			if f := fn(a.loc.fn); !countedF[f] {
+				countedF[f] = true
+				f.Total += n.self
+			}
+			if !countedL[l] {
+				countedL[l] = true
+				l.Total += n.self
+			}
+		}
+	}
+	walk(&p.root)
+
+	for info, n := range p.calls {
+		fn(info).Calls = n
+	}
+	for _, f := range funcs {
+		prof.Funcs = append(prof.Funcs, *f)
+	}
+	for _, l := range lines {
+		prof.Lines = append(prof.Lines, *l)
+	}
+	sort.Slice(prof.Funcs, func(i, j int) bool {
+		a, b := prof.Funcs[i], prof.Funcs[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		if a.Self != b.Self {
+			return a.Self > b.Self
+		}
+		return a.Pos.String()+a.Name < b.Pos.String()+b.Name
+	})
+	sort.Slice(prof.Lines, func(i, j int) bool {
+		a, b := prof.Lines[i], prof.Lines[j]
+		if a.Total != b.Total {
+			return a.Total > b.Total
+		}
+		if a.Self != b.Self {
+			return a.Self > b.Self
+		}
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		return a.Line < b.Line
+	})
+	// stacks in a deterministic order, for WritePprof
+	sort.Slice(prof.samples, func(i, j int) bool {
+		return prof.samples[i].id < prof.samples[j].id
+	})
+	return prof
+}
+
+// WriteReport writes a flat report of the functions and lines, by decreasing total steps:
+//
+//	20 steps (function calls)
+//
+//	  calls  self  self%  total  total%  function
+//	      1     3  15.0%     20  100.0%  main  fib.howl:1
+//	      5    15  75.0%     15   75.0%  fib  fib.howl:1
+//	...
+//
+//	    self  self%  total  total%  line
+//	       1   5.0%     16   80.0%  fib.howl:9
+//	...
+func (p *Profile) WriteReport(w io.Writer) error {
+	pct := func(n int) string {
+		if p.Steps == 0 {
+			return "-"
+		}
+		return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(p.Steps))
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "%v steps (function calls)\n\n", p.Steps)
+	fmt.Fprintln(tw, "calls\tself\tself%\ttotal\ttotal%\t  function")
+	for _, f := range p.Funcs {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t  %v  %v\n", f.Calls, f.Self, pct(f.Self), f.Total, pct(f.Total), f.Name, shortPos(f.Pos.Filename, f.Pos.Line))
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprintln(tw, "\tself\tself%\ttotal\ttotal%\t  line")
+	for _, l := range p.Lines {
+		fmt.Fprintf(tw, "\t%v\t%v\t%v\t%v\t  %v\n", l.Self, pct(l.Self), l.Total, pct(l.Total), shortPos(l.File, l.Line))
+	}
+	return tw.Flush()
+}
+
+// shortPos formats a position as the file's base name and line.
+func shortPos(file string, line int) string {
+	if line == 0 {
+		return "-"
+	}
+	return fmt.Sprint(filepath.Base(file), ":", line)
+}
@@ -0,0 +1,105 @@
+package eva
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+)
+
+func TestProfile(t *testing.T) {
+	src := `fib = n ->
+	n <= 2? 1: fib(n - 1) + fib(n - 2);
+fib(10)`
+	n, err := ast.ParseFile("test.howl", strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := NewLoader()
+	l.NoOpt = true
+	prog, err := l.CompileAST(n, ".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, prof, err := EvalProfile(prog)
+	if v != 55 || err != nil {
+		t.Fatalf("have %v, %v, want 55", v, err)
+	}
+	// all steps but the call of the program itself, see TestSteps
+	if prof.Steps != 380 {
+		t.Errorf("have %v steps, want 380", prof.Steps)
+	}
+
+	wantFuncs := []FuncCount{
+		{Name: "main", Calls: 1, Self: 1, Total: 380},
+		{Name: "fib", Calls: 109, Self: 379, Total: 379},
+	}
+	if len(prof.Funcs) != len(wantFuncs) {
+		t.Fatalf("have %v, want %v", prof.Funcs, wantFuncs)
+	}
+	for i, w := range wantFuncs {
+		f := prof.Funcs[i]
+		f.Pos = w.Pos
+		if f != w {
+			t.Errorf("have %+v, want %+v", f, w)
+		}
+	}
+	if pos := prof.Funcs[1].Pos; pos.Line != 1 || pos.Filename != "test.howl" {
+		t.Errorf("fib: have position %v, want test.howl:1", pos)
+	}
+
+	wantLines := []LineCount{
+		{File: "test.howl", Line: 3, Self: 1, Total: 380},
+		{File: "test.howl", Line: 2, Self: 379, Total: 379},
+	}
+	if len(prof.Lines) != len(wantLines) {
+		t.Fatalf("have %v, want %v", prof.Lines, wantLines)
+	}
+	for i, w := range wantLines {
+		if prof.Lines[i] != w {
+			t.Errorf("have %+v, want %+v", prof.Lines[i], w)
+		}
+	}
+
+	var report bytes.Buffer
+	if err := prof.WriteReport(&report); err != nil {
+		t.Fatal(err)
+	}
+	want := "109 379 99.7% 379 99.7% fib test.howl:1"
+	if !strings.Contains(strings.Join(strings.Fields(report.String()), " "), want) {
+		t.Errorf("report does not contain %q:\n%v", want, report.String())
+	}
+
+	var pprof bytes.Buffer
+	if err := prof.WritePprof(&pprof); err != nil {
+		t.Fatal(err)
+	}
+	z, err := gzip.NewReader(&pprof)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := ioutil.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"steps", "count", "main", "fib", "test.howl"} {
+		if !bytes.Contains(pb, []byte(s)) {
+			t.Errorf("pprof profile does not contain %q", s)
+		}
+	}
+}
+
+func TestProfileError(t *testing.T) {
+	prog, err := Compile(strings.NewReader(`f = x -> x(1); f(2)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, prof, err := EvalProfile(prog)
+	if err == nil || prof == nil || prof.Steps == 0 {
+		t.Errorf("have %v, %v, want error and the steps before it", prof, err)
+	}
+}
@@ -0,0 +1,122 @@
+package eva
+
+import (
+	"bytes"
+	"fmt"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+)
+
+// -------- Record
+
+// Record is a value with named fields, e.g.: {x: 1, y: 2}.
+// Records with the same fields are equal, regardless of their order.
+type Record struct {
+	Names  []string
+	Values []Value
+}
+
+// Get returns the value of the field with given name, if any.
+func (r *Record) Get(name string) (Value, bool) {
+	for i, n := range r.Names {
+		if n == name {
+			return r.Values[i], true
+		}
+	}
+	return nil, false
+}
+
+func (r *Record) String() string {
+	var buf bytes.Buffer
+	fmt.Fprint(&buf, "{")
+	for i, n := range r.Names {
+		if i != 0 {
+			fmt.Fprint(&buf, ", ")
+		}
+		fmt.Fprint(&buf, n, ": ", r.Values[i])
+	}
+	fmt.Fprint(&buf, "}")
+	return buf.String()
+}
+
+func (r *Record) equal(b *Record) bool {
+	if len(r.Names) != len(b.Names) {
+		return false
+	}
+	for i, n := range r.Names {
+		v, ok := b.Get(n)
+		if !ok || !equal(r.Values[i], v) {
+			return false
+		}
+	}
+	return true
+}
+
+// member returns member sel of v, which must be a module or a record.
+func member(v Value, sel string) Value {
+	switch v := v.(type) {
+	case *ModuleValue:
+		return v.Get(sel)
+	case *Record:
+		if x, ok := v.Get(sel); ok {
+			return x
+		}
+		panic(se.Errorf("selecting %v from %v: no such field", sel, v))
+	default:
+		panic(se.Errorf("selecting %v from %v: not a module or record", sel, v))
+	}
+}
+
+// RecordProg evaluates a record expression.
+// Like call arguments, the fields are evaluated last to first.
+type RecordProg struct {
+	Names  []string
+	Fields []Prog
+}
+
+func compileRecord(n *ast.Record) Prog {
+	p := &RecordProg{Names: n.Names}
+	for _, x := range n.Fields {
+		p.Fields = append(p.Fields, compileExpr(x))
+	}
+	return p
+}
+
+func (p *RecordProg) Exec(m *Machine) {
+	r := &Record{Names: p.Names, Values: make([]Value, len(p.Fields))}
+	for i := len(p.Fields) - 1; i >= 0; i-- {
+		p.Fields[i].Exec(m)
+		r.Values[i] = m.RA()
+	}
+	m.SetRA(r)
+}
+
+// -------- RecordPat
+
+type recordPat struct {
+	Names  []string
+	Fields []Pattern
+}
+
+func compileRecordPat(n *ast.RecordPat) Pattern {
+	p := &recordPat{Names: n.Names}
+	for _, x := range n.Fields {
+		p.Fields = append(p.Fields, compilePattern(x))
+	}
+	return p
+}
+
+func (p *recordPat) Match(m *Machine, v Value) bool {
+	r, ok := v.(*Record)
+	if !ok {
+		return false
+	}
+	for i, n := range p.Names {
+		x, ok := r.Get(n)
+		if !ok || !p.Fields[i].Match(m, x) {
+			return false
+		}
+	}
+	return true
+}
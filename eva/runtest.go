@@ -0,0 +1,66 @@
+package eva
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	se "github.com/barnex/se-lang"
+)
+
+// TestSuffix is the file name suffix of test files, run by 'se test'.
+const TestSuffix = "_test" + Ext
+
+// TestPrefix is the name prefix of test functions.
+const TestPrefix = "test_"
+
+// A TestResult is the outcome of a test function.
+type TestResult struct {
+	Name     string
+	Err      error // nil if the test passed
+	Duration time.Duration
+}
+
+// RunTests compiles a test file and calls its top-level functions named test_*,
+// in order of definition and without arguments,
+// skipping those whose name does not match (if match is not nil).
+// A test fails if it returns an error, typically from assert or assertEq.
+// An error is returned if the file does not compile or its definitions fail.
+func (l *Loader) RunTests(file string, match func(name string) bool) (_ []TestResult, err error) {
+	defer func() {
+		switch e := recover().(type) {
+		case nil: //OK
+		default:
+			panic(e)
+		case se.Error:
+			err = e
+		}
+	}()
+
+	mod := l.load(l.abs(file))
+	v, err := Eval(mod)
+	if err != nil {
+		return nil, err
+	}
+	defs := v.(*ModuleValue)
+
+	var results []TestResult
+	for i, name := range mod.Names {
+		if !strings.HasPrefix(name, TestPrefix) || (match != nil && !match(name)) {
+			continue
+		}
+		start := time.Now()
+		err := runTest(defs.Values[i])
+		results = append(results, TestResult{Name: name, Err: err, Duration: time.Since(start)})
+	}
+	return results, nil
+}
+
+// runTest calls test function f.
+func runTest(f Value) error {
+	if l, ok := f.(*LambdaValue); ok && l.Info != nil && l.Info.NumArgs != 0 {
+		return fmt.Errorf("test function must not take arguments")
+	}
+	_, err := Eval(&Call{F: Const{f}})
+	return err
+}
@@ -0,0 +1,78 @@
+package eva
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+)
+
+func TestRunTests(t *testing.T) {
+	results, err := NewLoader().RunTests("testdata/runtest/fib_test.howl", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"test_fib: <nil>",
+		"test_wrong: fib_test.howl:7:2: have 55, want 54",
+		`test_assert: fib_test.howl:9:58: assertion failed: fib(2)`,
+		`test_string: fib_test.howl:11:21: have "fib", want "fob"`,
+		"test_args: test function must not take arguments",
+		"test_assign: fib_test.howl:15:27: have 2, want 3",
+	}
+	var have []string
+	for _, r := range results {
+		have = append(have, fmt.Sprint(r.Name, ": ", r.Err))
+	}
+	if fmt.Sprint(have) != fmt.Sprint(want) {
+		t.Errorf("have:\n%q\nwant:\n%q", have, want)
+	}
+}
+
+func TestRunTestsMatch(t *testing.T) {
+	results, err := NewLoader().RunTests("testdata/runtest/fib_test.howl", func(name string) bool { return name == "test_fib" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Name != "test_fib" || results[0].Err != nil {
+		t.Errorf("have %v", results)
+	}
+}
+
+// The VM reports the same positions, also after encoding the bytecode.
+func TestRunTestsVM(t *testing.T) {
+	cases := []struct {
+		test string
+		want string
+	}{
+		{"test_wrong", "fib_test.howl:7:2: have 55, want 54"},
+		{"test_assert", "fib_test.howl:9:58: assertion failed: fib(2)"},
+		{"test_assign", "fib_test.howl:15:27: have 2, want 3"},
+	}
+	for _, c := range cases {
+		src := `import "fib_test"; fib_test.` + c.test + `()`
+		root, err := ast.ParseProgram(strings.NewReader(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		code, err := NewLoader().CompileCode(root, "testdata/runtest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := EncodeCode(&buf, code); err != nil {
+			t.Fatal(err)
+		}
+		decoded, err := DecodeCode(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, code := range []*Code{code, decoded} {
+			if _, err := Run(code); fmt.Sprint(err) != c.want {
+				t.Errorf("%v: have %v, want %v", c.test, err, c.want)
+			}
+		}
+	}
+}
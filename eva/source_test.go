@@ -0,0 +1,32 @@
+package eva
+
+import (
+	"math/rand"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/internal/astgen"
+)
+
+// Random expressions must evaluate to the same value
+// as their source text printed by ast.Source, on every backend.
+func TestSourceEval(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		n := astgen.RandomExpr(r, 5)
+		src := ast.Source(n) // before compiling, which resolves n
+		prog, err := CompileAST(n)
+		if err != nil {
+			t.Fatalf("%v: %v", src, err)
+		}
+		want, err := Eval(prog)
+		if err != nil {
+			t.Fatalf("%v: %v", src, err)
+		}
+		for _, b := range backends {
+			if have, err := b.eval(src); have != want || err != nil {
+				t.Errorf("%v: %v: have %v, %v, want %v", b.name, src, have, err, want)
+			}
+		}
+	}
+}
@@ -4,16 +4,47 @@ import (
 	"fmt"
 	"io"
 
+	se "github.com/barnex/se-lang"
 	"github.com/barnex/se-lang/ast"
 )
 
 func Eval(p Prog) (Value, error) {
-	var m Machine
+	v, _, err := EvalSteps(p)
+	return v, err
+}
+
+// EvalSteps is like Eval, but also returns the number of executed steps:
+// the number of function calls.
+func EvalSteps(p Prog) (_ Value, steps int, err error) {
+	return eval(p, 0)
+}
+
+// EvalLimit is like Eval, but fails if the program takes more than maxSteps steps,
+// for running untrusted programs.
+func EvalLimit(p Prog, maxSteps int) (Value, error) {
+	v, _, err := eval(p, maxSteps)
+	return v, err
+}
+
+// eval executes p, with at most maxSteps steps if maxSteps > 0.
+func eval(p Prog, maxSteps int) (_ Value, steps int, err error) {
+	m := Machine{maxSteps: maxSteps}
+	defer func() {
+		switch e := recover().(type) {
+		case nil: //OK
+		default:
+			panic(e)
+		case se.Error:
+			err = e
+			steps = m.steps // up to the error
+		}
+	}()
+
 	p.Exec(&m)
 	if len(m.s) != 0 {
-		return nil, fmt.Errorf("left dirty stack: %v", m.s)
+		return nil, m.steps, fmt.Errorf("left dirty stack: %v", m.s)
 	}
-	return m.RA().Get(), nil
+	return m.RA(), m.steps, nil
 }
 
 func Compile(src io.Reader) (Prog, error) {
@@ -24,27 +55,19 @@ func Compile(src io.Reader) (Prog, error) {
 	return CompileAST(n)
 }
 
-func CompileAST(root ast.Node) (_ Prog, err error) {
-	//defer func() {
-	//	switch e := recover().(type) {
-	//	case nil: //OK
-	//	default:
-	//		panic(e)
-	//	case se.Error:
-	//		err = e
-	//	}
-	//}()
-
-	// wrap a {block} in a labmda call (()->{block})()
-	// to provide a call frame for local variables.
-	// TODO: this is a hack
-	//if _, ok := root.(*ast.Block); ok {
-	root = &ast.Call{
-		F: &ast.Lambda{Body: root},
+// CompileAST compiles a program.
+// Imports are searched relative to the working directory.
+func CompileAST(root ast.Node) (Prog, error) {
+	return NewLoader().CompileAST(root, ".")
+}
+
+// CompileCode is like Compile, but compiles to bytecode, to be executed by Run.
+func CompileCode(src io.Reader) (*Code, error) {
+	n, err := ast.ParseProgram(src)
+	if err != nil {
+		return nil, err
 	}
-	//}
-	ast.Resolve(root)
-	return compileExpr(root), nil
+	return NewLoader().CompileCode(n, ".")
 }
 
 func assert(x bool) {
@@ -0,0 +1,296 @@
+package eva
+
+import (
+	"fmt"
+
+	se "github.com/barnex/se-lang"
+)
+
+// VM runs bytecode in a loop.
+// Unlike Prog.Exec, calls between bytecode functions do not recurse in Go,
+// so the depth of se-lang recursion is not limited by the Go stack.
+type VM struct {
+	code     *Code
+	stack    []Value
+	frames   []frame
+	steps    int             // executed instructions
+	maxSteps int             // fail after this many instructions, if > 0
+	imported map[*Func]Value // module values, by the Func that exported them
+}
+
+// frame is the activation record of a bytecode function.
+type frame struct {
+	fn     *Func
+	pc     int
+	base   int     // stack index of the last argument, arguments are pushed in reverse order
+	locals []Value // a Box for boxed locals, see Func
+}
+
+// Closure is a bytecode function value, with its captured variables.
+type Closure struct {
+	Code *Code
+	Func *Func
+	Capv []Box
+}
+
+var _ Applier = (*Closure)(nil)
+
+// Apply calls the closure from Prog code, e.g. when it is passed to a function in an imported module.
+// The steps of the nested VM count towards the limit of the Machine.
+func (c *Closure) Apply(m *Machine) {
+	vm := &VM{code: c.Code, steps: m.steps, maxSteps: m.maxSteps}
+	for i := c.Func.NumArgs - 1; i >= 0; i-- {
+		vm.push(m.FromSP(-1 - i))
+	}
+	m.SetRA(vm.run(c))
+	m.steps = vm.steps
+}
+
+// Run executes the program and returns its value.
+func Run(c *Code) (Value, error) {
+	v, _, err := RunSteps(c)
+	return v, err
+}
+
+// RunSteps is like Run, but also returns the number of executed instructions.
+func RunSteps(c *Code) (_ Value, steps int, err error) {
+	return run(c, 0)
+}
+
+// RunLimit is like Run, but fails if the program executes more than maxSteps instructions,
+// for running untrusted programs.
+func RunLimit(c *Code, maxSteps int) (Value, error) {
+	v, _, err := run(c, maxSteps)
+	return v, err
+}
+
+// run executes c, with at most maxSteps instructions if maxSteps > 0.
+func run(c *Code, maxSteps int) (_ Value, steps int, err error) {
+	vm := &VM{code: c, maxSteps: maxSteps}
+	defer func() {
+		switch e := recover().(type) {
+		case nil: //OK
+		default:
+			panic(e)
+		case se.Error:
+			err = e
+			steps = vm.steps // up to the error
+		}
+	}()
+
+	v := vm.run(&Closure{Code: c, Func: c.Funcs[0]})
+	if len(vm.stack) != 0 {
+		return nil, vm.steps, fmt.Errorf("left dirty stack: %v", vm.stack)
+	}
+	return v, vm.steps, nil
+}
+
+// run calls closure f with the arguments on top of the stack,
+// and returns its result.
+func (vm *VM) run(f *Closure) Value {
+	vm.enter(f)
+	depth := len(vm.frames)
+
+	for {
+		fr := &vm.frames[len(vm.frames)-1]
+		in := fr.fn.Instrs[fr.pc]
+		fr.pc++
+		vm.steps++
+		if vm.maxSteps > 0 && vm.steps > vm.maxSteps {
+			panic(se.Errorf("exceeded %v steps", vm.maxSteps))
+		}
+
+		switch in.Op {
+		default:
+			panic(unhandled(in.Op))
+		case OpConst:
+			vm.push(vm.code.Consts[in.A])
+		case OpArg:
+			vm.push(vm.stack[fr.base+fr.fn.NumArgs-1-in.A])
+		case OpLoad:
+			vm.push(fr.locals[in.A])
+		case OpStore:
+			fr.locals[in.A] = vm.pop()
+		case OpLoadBox:
+			vm.push(fr.locals[in.A].(Box).Get())
+		case OpStoreBox:
+			fr.locals[in.A].(Box).Set(vm.pop())
+		case OpBoxLocal:
+			vm.push(fr.locals[in.A])
+		case OpClosure:
+			fn := vm.code.Funcs[in.A]
+			c := &Closure{Code: vm.code, Func: fn, Capv: make([]Box, len(fn.CapDst))}
+			for i := len(c.Capv) - 1; i >= 0; i-- {
+				c.Capv[i] = vm.pop().(Box)
+			}
+			vm.push(c)
+		case OpCall:
+			vm.call(vm.pop(), in.A)
+		case OpRet:
+			v := vm.pop()
+			vm.stack = vm.stack[:fr.base] // free arguments
+			vm.frames = vm.frames[:len(vm.frames)-1]
+			if len(vm.frames) < depth {
+				return v
+			}
+			vm.push(v)
+		case OpJump:
+			fr.pc = in.A
+		case OpJumpIf:
+			if isTrue(vm.pop()) {
+				fr.pc = in.A
+			}
+		case OpJumpIfNot:
+			if !isTrue(vm.pop()) {
+				fr.pc = in.A
+			}
+		case OpDup:
+			vm.push(vm.stack[len(vm.stack)-1])
+		case OpPop:
+			vm.pop()
+		case OpField:
+			v := vm.pop()
+			t, ok := v.(*Tagged)
+			if !ok || in.A >= len(t.Fields) {
+				panic(se.Errorf("no field %v in %v", in.A, v))
+			}
+			vm.push(t.Fields[in.A])
+		case OpMatchLit:
+			if !equal(vm.pop(), vm.code.Consts[in.A]) {
+				fr.pc = in.B
+			}
+		case OpMatchCtor:
+			want := vm.code.Consts[in.A].(*Tagged)
+			t, ok := vm.pop().(*Tagged)
+			if !ok || t.Type != want.Type || t.Ctor != want.Ctor {
+				fr.pc = in.B
+			}
+		case OpList:
+			elems := make([]Value, in.A)
+			for i := range elems {
+				elems[i] = vm.pop()
+			}
+			var tail Value = &List{}
+			if in.B == 1 {
+				tail = vm.pop()
+			}
+			vm.push(prepend(elems, tail))
+		case OpRecord:
+			names := vm.code.Consts[in.A].([]string)
+			r := &Record{Names: names, Values: make([]Value, len(names))}
+			for i := range r.Values {
+				r.Values[i] = vm.pop()
+			}
+			vm.push(r)
+		case OpMatchCons:
+			if l, ok := vm.pop().(*List); !ok || l.Tail == nil {
+				fr.pc = in.B
+			}
+		case OpMatchNil:
+			if l, ok := vm.pop().(*List); !ok || l.Tail != nil {
+				fr.pc = in.B
+			}
+		case OpHead, OpTail:
+			v := vm.pop()
+			l, ok := v.(*List)
+			if !ok || l.Tail == nil {
+				panic(se.Errorf("%v of %v: not a non-empty list", in.Op, v))
+			}
+			if in.Op == OpHead {
+				vm.push(l.Head)
+			} else {
+				vm.push(l.Tail)
+			}
+		case OpMatchField:
+			r, ok := vm.pop().(*Record)
+			if ok {
+				_, ok = r.Get(vm.code.Consts[in.A].(string))
+			}
+			if !ok {
+				fr.pc = in.B
+			}
+		case OpNoMatch:
+			v := append([]Value{}, vm.stack[len(vm.stack)-in.A:]...)
+			panic(se.Errorf("match: no case for %v", v))
+		case OpExport:
+			exp := vm.code.Consts[in.A].(*export)
+			v := &ModuleValue{Module: exp.Module}
+			for _, x := range exp.Vars {
+				l := fr.locals[x.Offset]
+				if fr.fn.boxed(x.Offset) {
+					l = l.(Box).Get()
+				}
+				v.Values = append(v.Values, l)
+			}
+			if vm.imported == nil {
+				vm.imported = make(map[*Func]Value)
+			}
+			vm.imported[fr.fn] = v
+			vm.push(v)
+		case OpSelect:
+			vm.push(member(vm.pop(), vm.code.Consts[in.A].(string)))
+		case OpImport:
+			fn := vm.code.Funcs[in.A]
+			if v, ok := vm.imported[fn]; ok {
+				vm.push(v)
+			} else {
+				vm.enter(&Closure{Code: vm.code, Func: fn})
+			}
+		}
+	}
+}
+
+// enter pushes a frame for closure f,
+// whose arguments are on top of the stack.
+func (vm *VM) enter(f *Closure) {
+	fr := frame{
+		fn:     f.Func,
+		base:   len(vm.stack) - f.Func.NumArgs,
+		locals: make([]Value, f.Func.NumLocals),
+	}
+	for _, b := range f.Func.Boxed {
+		fr.locals[b] = Box{new(Value)}
+	}
+	for i, c := range f.Capv {
+		fr.locals[f.Func.CapDst[i]] = c
+	}
+	vm.frames = append(vm.frames, fr)
+}
+
+// call calls f with the nargs arguments on top of the stack.
+// Bytecode functions are entered, to be continued by the run loop.
+// Other functions are applied on a Machine.
+func (vm *VM) call(f Value, nargs int) {
+	if c, ok := f.(*Closure); ok && c.Code == vm.code {
+		if nargs != c.Func.NumArgs {
+			panic(se.Errorf("called with %v arguments, want %v", nargs, c.Func.NumArgs))
+		}
+		vm.enter(c)
+		return
+	}
+
+	a := applier(f)
+	checkArgs(a, nargs)
+	args := vm.stack[len(vm.stack)-nargs:]
+	m := Machine{steps: vm.steps, maxSteps: vm.maxSteps} // e.g. pipe calls a closure
+	for _, a := range args {
+		if a == nil {
+			panic(se.Errorf("value used before its definition"))
+		}
+		m.Push(a)
+	}
+	a.Apply(&m)
+	vm.steps = m.steps
+	vm.stack = vm.stack[:len(vm.stack)-nargs]
+	vm.push(m.RA())
+}
+
+func (vm *VM) push(v Value) {
+	vm.stack = append(vm.stack, v)
+}
+
+func (vm *VM) pop() Value {
+	v := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return v
+}
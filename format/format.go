@@ -0,0 +1,181 @@
+/*
+Package format formats se-lang source text in a canonical style.
+
+Only white space is changed: comments and line breaks are kept,
+with at most one empty line in a row.
+Lines are indented with a tab per open brace, bracket or parenthesis,
+and continuation lines, e.g. a lambda body on the next line, get an extra tab:
+
+	fac = n ->
+		n == 1? 1: n * fac(n - 1);
+
+	fac(6) // 720
+
+Binary operators are surrounded by spaces, the ? and : of a condition
+are attached to the preceding expression.
+*/
+package format
+
+import (
+	"bytes"
+	"strings"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/lex"
+)
+
+// Source formats the program in src.
+// It returns an error if src does not parse.
+func Source(src []byte) (_ []byte, err error) {
+	defer func() {
+		switch e := recover().(type) {
+		case nil: //OK
+		default:
+			panic(e)
+		case se.Error:
+			err = e
+		}
+	}()
+
+	if _, err := ast.ParseProgram(bytes.NewReader(src)); err != nil {
+		return nil, err
+	}
+	toks := tokenize(src)
+	out := format(toks)
+
+	// formatting must only change white space
+	if !sameTokens(toks, tokenize(out)) {
+		panic(se.Errorf("BUG: format: changed tokens:\n%s", out))
+	}
+	return out, nil
+}
+
+// token is a lexical token, with the lines it spans.
+type token struct {
+	lex.Token
+	line, endLine int
+	unary         bool // unary minus
+}
+
+// tokenize splits src in tokens, including comments, up to and excluding EOF.
+func tokenize(src []byte) []token {
+	l := lex.NewLexer(bytes.NewReader(src))
+	l.KeepComments()
+	var toks []token
+	prev := lex.TError // previous token that is not a comment
+	for {
+		t := l.Next()
+		if t.TType == lex.TEOF {
+			return toks
+		}
+		line := l.Position().Line
+		toks = append(toks, token{
+			Token:   t,
+			line:    line,
+			endLine: line + strings.Count(t.Value, "\n"),
+			unary:   t.TType == lex.TMinus && !endsOperand(prev),
+		})
+		if t.TType != lex.TComment {
+			prev = t.TType
+		}
+	}
+}
+
+// endsOperand returns true for tokens that can end an operand,
+// so that a minus sign after them is a binary operator.
+func endsOperand(t lex.TType) bool {
+	switch t {
+	case lex.TIdent, lex.TNum, lex.TString, lex.TRParen, lex.TRBrace, lex.TRBrack:
+		return true
+	}
+	return false
+}
+
+func format(toks []token) []byte {
+	var buf bytes.Buffer
+	depth := 0         // open braces, brackets and parentheses
+	code := lex.TError // previous token that is not a comment
+	var prev *token    // previous token
+	for i := range toks {
+		t := &toks[i]
+		if t.TType == lex.TRBrace || t.TType == lex.TRBrack || t.TType == lex.TRParen {
+			depth--
+		}
+		if prev != nil {
+			if nl := t.line - prev.endLine; nl > 0 {
+				if nl > 2 {
+					nl = 2
+				}
+				buf.WriteString(strings.Repeat("\n", nl))
+				indent := depth
+				if continues(code, t.TType) {
+					indent++
+				}
+				buf.WriteString(strings.Repeat("\t", indent))
+			} else if space(prev, t) {
+				buf.WriteByte(' ')
+			}
+		}
+		buf.WriteString(t.Value)
+		if t.TType == lex.TLBrace || t.TType == lex.TLBrack || t.TType == lex.TLParen {
+			depth++
+		}
+		prev = t
+		if t.TType != lex.TComment {
+			code = t.TType
+		}
+	}
+	if len(toks) > 0 {
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+// continues returns true if a line starting with token t,
+// after code token prev, continues the expression of the previous line.
+func continues(prev, t lex.TType) bool {
+	switch prev {
+	case lex.TError, lex.TSemicol, lex.TLBrace, lex.TLBrack, lex.TLParen, lex.TComma:
+		return false
+	}
+	switch t {
+	case lex.TRBrace, lex.TRBrack, lex.TRParen, lex.TSemicol:
+		return false
+	}
+	return true
+}
+
+// space returns true if a and b, on the same line, are separated by a space.
+func space(a, b *token) bool {
+	if a.TType == lex.TComment || b.TType == lex.TComment {
+		return true
+	}
+	switch b.TType {
+	case lex.TComma, lex.TSemicol, lex.TRParen, lex.TRBrace, lex.TRBrack, lex.TDot, lex.TQuestion, lex.TColon:
+		return false
+	case lex.TLParen:
+		if a.TType == lex.TIdent || a.TType == lex.TRParen {
+			return false // call
+		}
+	}
+	switch a.TType {
+	case lex.TLParen, lex.TLBrace, lex.TLBrack, lex.TDot, lex.TNot:
+		return false
+	case lex.TMinus:
+		return !a.unary
+	}
+	return true
+}
+
+func sameTokens(a, b []token) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Token != b[i].Token {
+			return false
+		}
+	}
+	return true
+}
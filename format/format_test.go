@@ -0,0 +1,63 @@
+package format
+
+import (
+	"testing"
+)
+
+func TestSource(t *testing.T) {
+	cases := []struct {
+		src, want string
+	}{
+		{`1`, "1\n"},
+		{`1+2*3`, "1 + 2 * 3\n"},
+		{`x=-1;y = x- -2;  -x`, "x = -1; y = x - -2; -x\n"},
+		{`!true||false&&x`, "!true || false && x\n"},
+		{`max=(x,y)->x>y?x:y;max(1,2)`, "max = (x, y) -> x > y? x: y; max(1, 2)\n"},
+		{`f=()->1;f( )`, "f = () -> 1; f()\n"},
+		{`(x->x*x) (3)`, "(x -> x * x)(3)\n"},
+		{`{a=1 ; a}`, "{a = 1; a}\n"},
+		{`lib.f(1)`, "lib.f(1)\n"},
+		{`import  u "lib/util.howl";u.square(2)`, "import u \"lib/util.howl\"; u.square(2)\n"},
+		{`type List = Nil|Cons(h,t); Nil`, "type List = Nil | Cons(h, t); Nil\n"},
+		{"infixl 6 <> =f;a<>b`max`-c", "infixl 6 <> = f; a <> b `max` -c\n"},
+		{`match x,y {(0,_) if y<0->1;Some(z)->-z}`, "match x, y {(0, _) if y < 0 -> 1; Some(z) -> -z}\n"},
+		{`l=[ 1,-2|t ];match l {[x|_]->x;[ ]->0}`, "l = [1, -2 | t]; match l {[x | _] -> x; [] -> 0}\n"},
+		{`p={x:1 , y:[]}.y;match p {{x:0}->-1;{y:y}->y}`, "p = {x: 1, y: []}.y; match p {{x: 0} -> -1; {y: y} -> y}\n"},
+		{"l = [1,\n2]", "l = [1,\n\t2]\n"},
+
+		// line breaks, indentation and comments
+		{"\n\nx = 1;\n\n\n\ny = 2;// two\nx+y  \n\n", "x = 1;\n\ny = 2; // two\nx + y\n"},
+		{"fac = n ->\nn==1? 1:\n      n*fac(n-1);\nfac(6)", "fac = n ->\n\tn == 1? 1:\n\tn * fac(n - 1);\nfac(6)\n"},
+		{"abs = x -> match x {\ny if y < 0 -> -y;\n  // positive\n  y -> y\n    };\nabs(-3)",
+			"abs = x -> match x {\n\ty if y < 0 -> -y;\n\t// positive\n\ty -> y\n};\nabs(-3)\n"},
+		{"isPrime = n -> {\niter = d ->\nd > n? true:\niter(d+1);\niter(2)\n}",
+			"isPrime = n -> {\n\titer = d ->\n\t\td > n? true:\n\t\titer(d + 1);\n\titer(2)\n}\n"},
+		{"f(1,\n2\n)", "f(1,\n\t2\n)\n"},
+		{"f = x ->\n  x\n  ;\nf(1)", "f = x ->\n\tx\n;\nf(1)\n"},
+		{"/* a */ x /* b\nc */", "/* a */ x /* b\nc */\n"},
+	}
+
+	for _, c := range cases {
+		have, err := Source([]byte(c.src))
+		if err != nil {
+			t.Errorf("%q: %v", c.src, err)
+			continue
+		}
+		if string(have) != c.want {
+			t.Errorf("%q:\nhave: %q\nwant: %q", c.src, have, c.want)
+			continue
+		}
+		again, err := Source(have)
+		if err != nil || string(again) != string(have) {
+			t.Errorf("%q: not idempotent: %q, %v", c.src, again, err)
+		}
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	for _, src := range []string{`1+`, `x = `, `(1`, `$`} {
+		if have, err := Source([]byte(src)); err == nil {
+			t.Errorf("%q: expected error, have %q", src, have)
+		}
+	}
+}
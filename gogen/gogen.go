@@ -0,0 +1,511 @@
+/*
+Package gogen translates se programs into standalone Go programs.
+
+Functions become Go func literals, taking and returning values of type V (interface{}),
+and captured variables are captured by the Go closures themselves.
+Built-in operators become Go operators, with a type assertion
+only where the type of an operand is not known statically.
+The generated program prints the value of the se program,
+or the error and exits with status 1 if it fails.
+
+Programs that import modules are not supported yet.
+*/
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io"
+	"strconv"
+	"strings"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/lex"
+)
+
+// Generate writes a Go program that evaluates root,
+// a program resolved by eva.Loader.ResolveFile.
+func Generate(w io.Writer, root ast.Node) (err error) {
+	defer func() {
+		switch e := recover().(type) {
+		case nil: //OK
+		default:
+			panic(e)
+		case se.Error:
+			err = e
+		}
+	}()
+
+	g := &gen{buf: new(bytes.Buffer), types: make(map[*ast.TypeDef]string)}
+	g.printf("// Code generated by se gogen. DO NOT EDIT.\n\n")
+	g.printf("package main\n\n")
+	g.printf("import (\n\t\"fmt\"\n\t\"os\"\n)\n\n")
+	g.printf("func program() V {\n")
+	g.tail(root, "return ")
+	g.printf("}\n")
+	g.printf("%s", runtime)
+
+	src, err := format.Source(g.buf.Bytes())
+	if err != nil {
+		panic(fmt.Sprintf("BUG: gogen: %v", err)) // invalid Go code generated
+	}
+	_, err = w.Write(src)
+	return err
+}
+
+// typ is the static type of a generated Go expression.
+type typ int
+
+const (
+	tV    typ = iota // V, type not known
+	tInt             // int
+	tBool            // bool
+)
+
+var typName = map[typ]string{tV: "V", tInt: "int", tBool: "bool"}
+
+// expr is a generated Go expression.
+type expr struct {
+	code string
+	t    typ
+}
+
+// op is a built-in function that translates to a Go operator.
+type op struct {
+	op       string
+	arg, res typ
+}
+
+var ops = map[string]op{
+	"add": {"+", tInt, tInt},
+	"sub": {"-", tInt, tInt},
+	"mul": {"*", tInt, tInt},
+	"div": {"/", tInt, tInt},
+	"mod": {"%", tInt, tInt},
+	"lt":  {"<", tInt, tBool},
+	"le":  {"<=", tInt, tBool},
+	"gt":  {">", tInt, tBool},
+	"ge":  {">=", tInt, tBool},
+	"and": {"&&", tBool, tBool},
+	"or":  {"||", tBool, tBool},
+	"eq":  {"==", tV, tBool},
+	"neq": {"!=", tV, tBool},
+	"neg": {"-", tInt, tInt},
+	"not": {"!", tBool, tBool},
+}
+
+// unary are the built-in functions with one argument, the others have two.
+var unary = map[string]bool{"neg": true, "not": true}
+
+// funcs are the built-in functions that are Go functions in the runtime,
+// of type func(V, V) V.
+var funcs = map[string]bool{
+	"andThen":  true,
+	"assert":   true,
+	"assertEq": true,
+	"compose":  true,
+	"pipe":     true,
+}
+
+type gen struct {
+	buf    *bytes.Buffer
+	fn     *frame // function being generated
+	nextID int    // for unique lambda names
+	types  map[*ast.TypeDef]string
+}
+
+// frame holds the Go names of the variables of a lambda.
+type frame struct {
+	id    int
+	names map[ast.Var]string
+}
+
+func (g *gen) printf(format string, x ...interface{}) {
+	fmt.Fprintf(g.buf, format, x...)
+}
+
+// name returns the Go name of a variable of the current function.
+func (g *gen) name(v ast.Var) string {
+	if n, ok := g.fn.names[v]; ok {
+		return n
+	}
+	l, ok := v.(*ast.LocVar)
+	if !ok {
+		panic(fmt.Sprintf("BUG: gogen: unknown variable %v", v))
+	}
+	return localName(g.fn.id, l.Index)
+}
+
+func localName(fn, index int) string { return fmt.Sprint("l", fn, "_", index) }
+
+// tail generates statements that evaluate n and hand the result to dst,
+// e.g. "return " or "l0_1 = ".
+func (g *gen) tail(n ast.Node, dst string) {
+	switch n := n.(type) {
+	default:
+		g.printf("%v%v\n", dst, g.expr(n).code)
+	case *ast.Block:
+		g.block(n, dst)
+	case *ast.Cond:
+		g.printf("if %v {\n", as(g.expr(n.Test), tBool))
+		g.tail(n.If, dst)
+		g.printf("} else {\n")
+		g.tail(n.Else, dst)
+		g.printf("}\n")
+	case *ast.Match:
+		g.match(n, dst)
+	}
+}
+
+func (g *gen) block(n *ast.Block, dst string) {
+	// constructors first, like eva
+	for _, s := range n.Stmts {
+		if t, ok := s.(*ast.TypeDef); ok {
+			g.typeDef(t)
+		}
+	}
+	var x ast.Node
+	for _, s := range n.Stmts {
+		switch s := s.(type) {
+		case *ast.Assign:
+			g.tail(s.RHS, g.name(s.LHS.Var)+" = ")
+		case *ast.TypeDef:
+			// done above
+		case *ast.Import:
+			panic(se.Errorf("gogen: import is not supported"))
+		default:
+			if x != nil {
+				panic(se.Errorf("block has more than 1 expression"))
+			}
+			x = s
+		}
+	}
+	if x == nil {
+		panic(se.Errorf("block has no expression"))
+	}
+	g.tail(x, dst)
+}
+
+// typeName returns the runtime name of the sum type declared by t.
+// Types declared in different places get different names, even if they are called the same.
+func (g *gen) typeName(t *ast.TypeDef) string {
+	if n, ok := g.types[t]; ok {
+		return n
+	}
+	g.types[t] = fmt.Sprint(t.Name, "#", len(g.types))
+	return g.types[t]
+}
+
+func (g *gen) typeDef(n *ast.TypeDef) {
+	for _, c := range n.Ctors {
+		tag := fmt.Sprintf("&Tagged{Type: %q, Ctor: %q", g.typeName(c.Type), c.Name.Name)
+		if len(c.Fields) == 0 {
+			g.printf("%v = %v}\n", g.name(c.Name.Var), tag)
+			continue
+		}
+		var args []string
+		for i := range c.Fields {
+			args = append(args, fmt.Sprint("f", i))
+		}
+		list := strings.Join(args, ", ")
+		g.printf("%v = func(%v V) V { return %v, Fields: []V{%v}} }\n", g.name(c.Name.Var), list, tag, list)
+	}
+}
+
+// match generates a loop with a nest of if statements for each case.
+// The loop is left by the first case that matches, unless it returns,
+// and panics if none does.
+func (g *gen) match(n *ast.Match, dst string) {
+	var tmp []string
+	for i, x := range n.X {
+		tmp = append(tmp, g.name(n.Tmp[i]))
+		g.tail(x, tmp[i]+" = ")
+	}
+	g.printf("for {\n")
+	for _, c := range n.Cases {
+		pats := []ast.Pattern{c.Pat}
+		if t, ok := c.Pat.(*ast.Tuple); ok {
+			pats = t.Elems
+		}
+		nest := 0
+		for i, t := range tmp {
+			p := pats[0]
+			if len(pats) == len(tmp) {
+				p = pats[i]
+			}
+			nest += g.pattern(p, t)
+		}
+		if c.Guard != nil {
+			g.printf("if %v {\n", as(g.expr(c.Guard), tBool))
+			nest++
+		}
+		g.tail(c.Body, dst)
+		if dst != "return " {
+			g.printf("break\n")
+		}
+		g.printf("%v", strings.Repeat("}\n", nest))
+	}
+	g.printf("panic(noMatch(%v))\n", strings.Join(tmp, ", "))
+	g.printf("}\n")
+}
+
+// pattern generates the code that matches value x against p.
+// It opens a number of if statements, to be closed by the caller.
+func (g *gen) pattern(p ast.Pattern, x string) (nest int) {
+	switch p := p.(type) {
+	default:
+		panic(fmt.Sprintf("BUG: gogen: unhandled pattern %T", p))
+	case *ast.Wildcard:
+		return 0
+	case *ast.Ident:
+		g.printf("%v = %v\n", g.name(p.Var), x)
+		return 0
+	case *ast.Lit:
+		g.printf("if equal(%v, %v) {\n", x, literal(p.Value).code)
+		return 1
+	case *ast.CtorPat:
+		g.printf("if isCtor(%v, %q, %q) {\n", x, g.typeName(p.Ctor.Type), p.Name)
+		nest = 1
+		for i, a := range p.Args {
+			nest += g.pattern(a, fmt.Sprintf("field(%v, %v)", x, i))
+		}
+		return nest
+	case *ast.ListPat:
+		for _, e := range p.Elems {
+			g.printf("if isCons(%v) {\n", x)
+			nest++
+			nest += g.pattern(e, fmt.Sprintf("head(%v)", x))
+			x = fmt.Sprintf("tail(%v)", x)
+		}
+		if p.Tail == nil {
+			g.printf("if isNil(%v) {\n", x)
+			return nest + 1
+		}
+		return nest + g.pattern(p.Tail, x)
+	case *ast.RecordPat:
+		for i, f := range p.Fields {
+			g.printf("if hasField(%v, %q) {\n", x, p.Names[i])
+			nest++
+			nest += g.pattern(f, fmt.Sprintf("member(%v, %q)", x, p.Names[i]))
+		}
+		return nest
+	}
+}
+
+// expr returns a Go expression that evaluates n.
+func (g *gen) expr(n ast.Node) expr {
+	switch n := n.(type) {
+	default:
+		panic(fmt.Sprintf("BUG: gogen: unhandled node %T", n))
+	case *ast.Block, *ast.Cond, *ast.Match:
+		return expr{g.closure(nil, func() { g.tail(n, "return ") }) + "()", tV}
+	case *ast.Call:
+		return g.call(n)
+	case *ast.Ident:
+		return g.ident(n)
+	case *ast.Lambda:
+		return expr{g.lambda(n), tV}
+	case *ast.Logic:
+		return g.logic(n)
+	case *ast.Num:
+		return literal(n.Value)
+	case *ast.Str:
+		return expr{fmt.Sprintf("V(%v)", strconv.Quote(n.Value)), tV}
+	case *ast.List:
+		tail := "&List{}"
+		if n.Tail != nil {
+			tail = g.expr(n.Tail).code
+		}
+		args := append([]string{tail}, g.codes(n.Elems)...)
+		return expr{fmt.Sprintf("list(%v)", strings.Join(args, ", ")), tV}
+	case *ast.Record:
+		var names []string
+		for _, name := range n.Names {
+			names = append(names, strconv.Quote(name))
+		}
+		fields := strings.Join(g.codes(n.Fields), ", ")
+		return expr{fmt.Sprintf("&Record{Names: []string{%v}, Values: []V{%v}}", strings.Join(names, ", "), fields), tV}
+	case *ast.Select:
+		// only records: module values do not exist, see Import
+		return expr{fmt.Sprintf("member(%v, %q)", g.expr(n.X).code, n.Sel), tV}
+	case *ast.Import:
+		panic(se.Errorf("gogen: import is not supported"))
+	}
+}
+
+// codes returns the code of the expressions that evaluate nodes.
+func (g *gen) codes(nodes []ast.Node) []string {
+	var codes []string
+	for _, n := range nodes {
+		codes = append(codes, g.expr(n).code)
+	}
+	return codes
+}
+
+// as returns the code of x, converted to type t.
+func as(x expr, t typ) string {
+	switch {
+	case x.t == t || t == tV:
+		return x.code
+	case x.t == tV:
+		return fmt.Sprintf("%v.(%v)", x.code, typName[t])
+	default:
+		return fmt.Sprintf("V(%v).(%v)", x.code, typName[t]) // fails at run time, like eva
+	}
+}
+
+func literal(s string) expr {
+	switch s {
+	case "true", "false":
+		return expr{s, tBool}
+	}
+	if _, err := strconv.Atoi(s); err == nil {
+		return expr{s, tInt}
+	}
+	if _, err := strconv.ParseFloat(s, 64); err != nil {
+		panic(se.Errorf("%v", err))
+	}
+	return expr{fmt.Sprintf("V(%v)", s), tV} // float64, for which there are no operators
+}
+
+func (g *gen) ident(id *ast.Ident) expr {
+	if id.Var != nil {
+		return expr{g.name(id.Var), tV}
+	}
+	switch id.Name {
+	case "true", "false":
+		return expr{id.Name, tBool}
+	}
+	if funcs[id.Name] {
+		return expr{fmt.Sprintf("V(%v)", id.Name), tV}
+	}
+	o, ok := ops[id.Name]
+	if !ok {
+		panic(se.Errorf("undefined: %v", id.Name))
+	}
+	// built-in function as a value
+	x, y := expr{"x", tV}, expr{"y", tV}
+	if unary[id.Name] {
+		return expr{fmt.Sprintf("func(x V) V { return %v }", o.apply(x)), tV}
+	}
+	return expr{fmt.Sprintf("func(x, y V) V { return %v }", o.apply(x, y)), tV}
+}
+
+// apply returns the Go expression that applies o to args.
+func (o op) apply(args ...expr) string {
+	switch {
+	case len(args) == 1:
+		return fmt.Sprintf("(%v%v)", o.op, as(args[0], o.arg))
+	case o.op == "==":
+		return fmt.Sprintf("equal(%v, %v)", args[0].code, args[1].code)
+	case o.op == "!=":
+		return fmt.Sprintf("!equal(%v, %v)", args[0].code, args[1].code)
+	case o.op == "/" || o.op == "%":
+		// not a Go operator: a constant division by zero would not compile
+		fn := map[string]string{"/": "div", "%": "mod"}[o.op]
+		return fmt.Sprintf("%v(%v, %v)", fn, as(args[0], o.arg), as(args[1], o.arg))
+	default:
+		return fmt.Sprintf("(%v %v %v)", as(args[0], o.arg), o.op, as(args[1], o.arg))
+	}
+}
+
+func (g *gen) call(n *ast.Call) expr {
+	var args []expr
+	for _, a := range n.Args {
+		args = append(args, g.expr(a))
+	}
+	if id, ok := n.F.(*ast.Ident); ok && id.Var == nil {
+		if o, ok := ops[id.Name]; ok && len(args) <= 2 && unary[id.Name] == (len(args) == 1) {
+			return expr{o.apply(args...), o.res}
+		}
+	}
+
+	var list []string
+	for _, a := range args {
+		list = append(list, a.code)
+	}
+	if l, ok := n.F.(*ast.Lambda); ok && len(l.Args) == len(args) {
+		return expr{fmt.Sprintf("%v(%v)", g.lambda(l), strings.Join(list, ", ")), tV}
+	}
+	f := fmt.Sprintf("%v.(%v)", g.expr(n.F).code, funcType(len(args)))
+	return expr{fmt.Sprintf("%v(%v)", f, strings.Join(list, ", ")), tV}
+}
+
+// funcType returns the Go type of functions with n arguments.
+func funcType(n int) string {
+	return "func(" + strings.TrimSuffix(strings.Repeat("V, ", n), ", ") + ") V"
+}
+
+func (g *gen) logic(n *ast.Logic) expr {
+	or := n.Op == lex.TOr
+	o := ops["and"]
+	if or {
+		o = ops["or"]
+	}
+	x := g.expr(n.X)
+	y := g.expr(n.Y)
+	if y.t == tBool {
+		return expr{o.apply(x, y), tBool}
+	}
+	// Y may not be a bool, it is returned as is, like eva does.
+	return expr{g.closure(nil, func() {
+		if or {
+			g.printf("if %v {\n", as(x, tBool))
+		} else {
+			g.printf("if !%v {\n", as(x, tBool))
+		}
+		g.printf("return %v\n", or)
+		g.printf("}\n")
+		g.printf("return %v\n", y.code)
+	}) + "()", tV}
+}
+
+func (g *gen) lambda(n *ast.Lambda) string {
+	parent := g.fn
+	fn := &frame{id: g.nextID, names: make(map[ast.Var]string)}
+	g.nextID++
+	var args []string
+	for i, a := range n.Args {
+		fn.names[a.Var] = fmt.Sprint("a", fn.id, "_", i)
+		args = append(args, fn.names[a.Var])
+	}
+	captured := make(map[int]bool)
+	for _, c := range n.Caps {
+		fn.names[c.Dst] = g.name(c.Src) // captured by the Go closure
+		captured[c.Dst.(*ast.LocVar).Index] = true
+	}
+	return g.closure(args, func() {
+		g.fn = fn
+		defer func() { g.fn = parent }()
+		var locals []string
+		for i := 0; i < n.NumVar; i++ {
+			if !captured[i] {
+				locals = append(locals, localName(fn.id, i))
+			}
+		}
+		if len(locals) > 0 {
+			list := strings.Join(locals, ", ")
+			g.printf("var %v V\n", list)
+			g.printf("%v = %v\n", strings.TrimSuffix(strings.Repeat("_, ", len(locals)), ", "), list)
+		}
+		g.tail(n.Body, "return ")
+	})
+}
+
+// closure returns a Go func literal with arguments args, whose body is generated by body.
+func (g *gen) closure(args []string, body func()) string {
+	outer := g.buf
+	g.buf = new(bytes.Buffer)
+	if len(args) > 0 {
+		g.printf("func(%v V) V {\n", strings.Join(args, ", "))
+	} else {
+		g.printf("func() V {\n")
+	}
+	body()
+	g.printf("}")
+	code := g.buf.String()
+	g.buf = outer
+	return code
+}
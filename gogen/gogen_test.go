@@ -0,0 +1,155 @@
+package gogen
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/eva"
+)
+
+// Programs are translated to Go and run with 'go run',
+// which must print the same value as eva.Eval.
+func TestGenerate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the go tool")
+	}
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	cases := []string{
+		`1`,
+		`1+2*3`,
+		`x = 2; y = x*x; y-x`,
+		`-(2-5)`,
+		`1<2 && 2<1`,
+		`1==1 || 1%0 == 0`,
+		`false || 3`,
+		`1<2? 3: 4`,
+		`fac = n -> n <= 1? 1: n*fac(n-1); fac(10)`,
+		`fib = n -> n <= 2? 1: fib(n-1) + fib(n-2); fib(15)`,
+		`square = x -> x*x; twice = f -> (x -> f(f(x))); (twice(square))(3)`,
+		`((f, a) -> f(f(a)))((x -> x*x), 3)`,
+		`f = x -> y -> x + y; g = f(1); g(2)`,
+		`isEven = n -> n == 0 || isOdd(n-1); isOdd = n -> n != 0 && isEven(n-1); isEven(10)`,
+		`f = n -> {g = m -> m <= 0? 0: f(m-1) + 1; g(n)}; f(5)`,
+		`apply = (f, x) -> f(x); apply(neg, 3)`,
+		`abs = x -> match x {y if y < 0 -> -y; y -> y}; abs(-3) + abs(4)`,
+		`max = (x, y) -> match x, y {(a, b) if a > b -> a; (_, b) -> b}; max(1, 2) + max(4, 3)`,
+		`x = match 1 {0 -> 0; _ -> 2}; x + 1`,
+		`f = n -> match n {0 -> 0; _ -> match n%2 {0 -> 1; _ -> 2}}; f(3)`,
+		`(match 3 {x -> y -> x + y})(4)`,
+		`type List = Nil | Cons(h, t); sum = l -> match l {Nil -> 0; Cons(h, t) -> h + sum(t)}; sum(Cons(1, Cons(2, Cons(3, Nil))))`,
+		`type List = Nil | Cons(h, t); Cons(1, Cons(2, Nil))`,
+		`type T = A | B(x); B(A) == B(A)`,
+		`type Opt = None | Some(x); get = (o, d) -> match o {Some(x) -> x; None -> d}; get(Some(1), 2) + get(None, 2)`,
+		`f = () -> {type T = A; A}; g = x -> {type T = A; match x {A -> 1; _ -> 2}}; g(f())`,
+		`f = () -> {type T = A(x); A(1)}; f() == f()`,
+		`match 1 {2 -> 3}`, // fails
+		`1 % 0`,            // fails
+		`id = x -> x; id(id)(2)`,
+		`inc = x -> x + 1; square = x -> x*x; 3 |> inc >> square |> (inc << neg)`,
+		`f = pipe; f(2, neg)`,
+		`assert(1 < 2, "less") && assertEq("a", "a")`,
+		`assertEq(1 + 1, 3)`,         // fails
+		`f = assert; f(false, "no")`, // fails
+		`sum = l -> match l {[] -> 0; [x | t] -> x + sum(t)}; sum([1, 2 | [3]])`,
+		`match [1, 2, 3] {[x, y] -> 0; [1, _ | [t]] -> t; _ -> 1}`,
+		`[1, [2, true], {x: []}]`,
+		`[1, [2]] == [1, [2]] && [1] != [1, 2]`,
+		`p = {x: 1, y: {z: 2}}; p.x + p.y.z`,
+		`norm = p -> match p {{x: x, y: y} -> x*x + y*y; _ -> 0}; norm({y: 2, x: 1}) + norm({x: 1})`,
+		`{x: 1, y: 2} == {y: 2, x: 1}`,
+		`[1 | 2]`,  // fails
+		`{x: 1}.y`, // fails
+	}
+
+	dir, err := ioutil.TempDir("", "gogen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, src := range cases {
+		want := evaluate(src)
+
+		root, err := resolve(src)
+		if err != nil {
+			t.Errorf("%v: %v", src, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := Generate(&buf, root); err != nil {
+			t.Errorf("%v: %v", src, err)
+			continue
+		}
+		file := filepath.Join(dir, fmt.Sprint("main", i, ".go"))
+		if err := ioutil.WriteFile(file, buf.Bytes(), 0666); err != nil {
+			t.Fatal(err)
+		}
+
+		out, err := exec.Command(gotool, "run", file).Output()
+		have := strings.TrimSpace(string(out))
+		if err != nil {
+			if _, ok := err.(*exec.ExitError); !ok {
+				t.Fatal(err)
+			}
+			have = "error"
+		}
+		if have != want {
+			t.Errorf("%v: have %v, want %v\n%s", src, have, want, buf.Bytes())
+		}
+	}
+}
+
+func TestGenerateError(t *testing.T) {
+	cases := []string{
+		`import "testdata/lib.howl"; 1`,
+		`import "testdata/lib.howl"; lib.square(2)`,
+	}
+	for _, src := range cases {
+		root, err := resolve(src)
+		if err != nil {
+			t.Errorf("%v: %v", src, err)
+			continue
+		}
+		want := "gogen: import is not supported"
+		if err := Generate(ioutil.Discard, root); err == nil || err.Error() != want {
+			t.Errorf("%v: have %v, want error: %v", src, err, want)
+		}
+	}
+}
+
+func resolve(src string) (ast.Node, error) {
+	n, err := ast.ParseProgram(strings.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	return eva.NewLoader().ResolveAST(n, ".")
+}
+
+// evaluate returns the value of src, as printed by se, or "error".
+func evaluate(src string) (result string) {
+	defer func() {
+		if recover() != nil {
+			result = "error" // Go run-time errors are not recovered by eva.Eval
+		}
+	}()
+	prog, err := eva.Compile(strings.NewReader(src))
+	if err != nil {
+		return "error"
+	}
+	v, err := eva.Eval(prog)
+	if err != nil {
+		return "error"
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,229 @@
+package gogen
+
+// runtime is appended to every generated program.
+// It mirrors the parts of package eva that generated code needs.
+const runtime = `
+// -------- runtime
+
+func main() {
+	defer func() {
+		if e := recover(); e != nil {
+			fmt.Fprintln(os.Stderr, e)
+			os.Exit(1)
+		}
+	}()
+	fmt.Printf("%v\n", program())
+}
+
+// V is a value of any type.
+type V = interface{}
+
+// Tagged is a value of a sum type, e.g.: Rect(1, 2).
+type Tagged struct {
+	Type   string // unique for each declaration, even if types have the same name
+	Ctor   string
+	Fields []V
+}
+
+func (t *Tagged) String() string {
+	if len(t.Fields) == 0 {
+		return t.Ctor
+	}
+	s := t.Ctor + "("
+	for i, f := range t.Fields {
+		if i != 0 {
+			s += ", "
+		}
+		s += fmt.Sprint(f)
+	}
+	return s + ")"
+}
+
+// List is an immutable linked list, e.g.: [1, 2, 3].
+// The empty list has a nil Tail.
+type List struct {
+	Head V
+	Tail *List
+}
+
+func (l *List) String() string {
+	s := "["
+	for e := l; e.Tail != nil; e = e.Tail {
+		if e != l {
+			s += ", "
+		}
+		s += fmt.Sprint(e.Head)
+	}
+	return s + "]"
+}
+
+// Record is a value with named fields, e.g.: {x: 1, y: 2}.
+type Record struct {
+	Names  []string
+	Values []V
+}
+
+func (r *Record) String() string {
+	s := "{"
+	for i, n := range r.Names {
+		if i != 0 {
+			s += ", "
+		}
+		s += n + ": " + fmt.Sprint(r.Values[i])
+	}
+	return s + "}"
+}
+
+func (r *Record) get(name string) (V, bool) {
+	for i, n := range r.Names {
+		if n == name {
+			return r.Values[i], true
+		}
+	}
+	return nil, false
+}
+
+// equal compares values, structurally in case of Tagged values, lists and records.
+func equal(a, b V) bool {
+	switch a := a.(type) {
+	case *Tagged:
+		b, ok := b.(*Tagged)
+		if !ok || a.Type != b.Type || a.Ctor != b.Ctor || len(a.Fields) != len(b.Fields) {
+			return false
+		}
+		for i := range a.Fields {
+			if !equal(a.Fields[i], b.Fields[i]) {
+				return false
+			}
+		}
+		return true
+	case *List:
+		b, ok := b.(*List)
+		if !ok {
+			return false
+		}
+		for ; a.Tail != nil && b.Tail != nil; a, b = a.Tail, b.Tail {
+			if !equal(a.Head, b.Head) {
+				return false
+			}
+		}
+		return a.Tail == nil && b.Tail == nil
+	case *Record:
+		b, ok := b.(*Record)
+		if !ok || len(a.Names) != len(b.Names) {
+			return false
+		}
+		for i, n := range a.Names {
+			v, ok := b.get(n)
+			if !ok || !equal(a.Values[i], v) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a == b
+	}
+}
+
+func isCtor(v V, typ, ctor string) bool {
+	t, ok := v.(*Tagged)
+	return ok && t.Type == typ && t.Ctor == ctor
+}
+
+func field(v V, i int) V {
+	return v.(*Tagged).Fields[i]
+}
+
+// list returns the list of elems followed by rest, which must be a list.
+func list(rest V, elems ...V) V {
+	l := rest.(*List)
+	for i := len(elems) - 1; i >= 0; i-- {
+		l = &List{Head: elems[i], Tail: l}
+	}
+	return l
+}
+
+func isCons(v V) bool {
+	l, ok := v.(*List)
+	return ok && l.Tail != nil
+}
+
+func isNil(v V) bool {
+	l, ok := v.(*List)
+	return ok && l.Tail == nil
+}
+
+func head(v V) V {
+	return v.(*List).Head
+}
+
+func tail(v V) V {
+	return v.(*List).Tail
+}
+
+func hasField(v V, name string) bool {
+	r, ok := v.(*Record)
+	if ok {
+		_, ok = r.get(name)
+	}
+	return ok
+}
+
+// member returns the field of a record, selected with r.name.
+func member(r V, name string) V {
+	v, ok := r.(*Record).get(name)
+	if !ok {
+		panic(fmt.Sprintf("selecting %v from %v: no such field", name, r))
+	}
+	return v
+}
+
+func div(a, b int) int {
+	return a / b
+}
+
+func mod(a, b int) int {
+	return a % b
+}
+
+// pipe, compose and andThen implement x |> f, g << f and f >> g.
+func pipe(x, f V) V {
+	return f.(func(V) V)(x)
+}
+
+func compose(g, f V) V {
+	g1, f1 := g.(func(V) V), f.(func(V) V)
+	return func(x V) V { return g1(f1(x)) }
+}
+
+func andThen(f, g V) V {
+	return compose(g, f)
+}
+
+// assert and assertEq fail the program if their condition does not hold.
+func assert(cond, msg V) V {
+	if !cond.(bool) {
+		panic(fmt.Sprintf("assertion failed: %v", msg))
+	}
+	return true
+}
+
+func assertEq(have, want V) V {
+	if !equal(have, want) {
+		panic(fmt.Sprintf("have %v, want %v", quote(have), quote(want)))
+	}
+	return true
+}
+
+// quote formats a value, quoting strings to tell them apart from other values.
+func quote(v V) string {
+	if s, ok := v.(string); ok {
+		return fmt.Sprintf("%q", s)
+	}
+	return fmt.Sprint(v)
+}
+
+func noMatch(v ...V) string {
+	return fmt.Sprintf("match: no case for %v", v)
+}
+`
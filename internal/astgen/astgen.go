@@ -0,0 +1,122 @@
+// Package astgen generates random syntax trees for property-based tests.
+package astgen
+
+import (
+	"fmt"
+	"math/rand"
+
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/lex"
+)
+
+// RandomExpr returns a random expression that evaluates to an int without failing,
+// nested at most depth levels deep.
+// It uses the operators, literals, conditions, lambdas, calls and blocks
+// with definitions, and only variables that are in scope.
+// Like a parsed expression, it is not resolved.
+func RandomExpr(r *rand.Rand, depth int) ast.Node {
+	g := &generator{r: r}
+	return g.int(depth)
+}
+
+// generator builds random well-typed expressions.
+type generator struct {
+	r     *rand.Rand
+	vars  []string // int variables in scope
+	names int      // for unique variable names
+}
+
+// int returns an expression that evaluates to an int.
+func (g *generator) int(depth int) ast.Node {
+	if depth <= 0 {
+		return g.intLeaf()
+	}
+	d := depth - 1
+	switch g.r.Intn(10) {
+	default:
+		return g.intLeaf()
+	case 0, 1:
+		ops := []string{"add", "sub", "mul"}
+		return op(ops[g.r.Intn(len(ops))], g.int(d), g.int(d))
+	case 2:
+		// divide by a non-zero constant, so that evaluation never fails
+		ops := []string{"div", "mod"}
+		return op(ops[g.r.Intn(len(ops))], g.int(d), &ast.Num{Value: fmt.Sprint(1 + g.r.Intn(9))})
+	case 3:
+		return op("neg", g.int(d))
+	case 4:
+		return &ast.Cond{Test: g.bool(d), If: g.int(d), Else: g.int(d)}
+	case 5:
+		// ((x, y) -> body)(args)
+		args := []ast.Node{} // as parsed
+		var params []*ast.Ident
+		for i := g.r.Intn(3); i > 0; i-- {
+			args = append(args, g.int(d))
+			params = append(params, &ast.Ident{Name: g.newName()})
+		}
+		body := g.scope(params, func() ast.Node { return g.int(d) })
+		return &ast.Call{F: &ast.Lambda{Args: params, Body: body}, Args: args}
+	case 6:
+		// {x = expr; y = expr; body}
+		n := len(g.vars)
+		defer func() { g.vars = g.vars[:n] }()
+		b := &ast.Block{}
+		for i := g.r.Intn(3); i > 0; i-- {
+			rhs := g.int(d)
+			lhs := &ast.Ident{Name: g.newName()}
+			g.vars = append(g.vars, lhs.Name)
+			b.Stmts = append(b.Stmts, &ast.Assign{LHS: lhs, RHS: rhs})
+		}
+		b.Stmts = append(b.Stmts, g.int(d))
+		return b
+	}
+}
+
+func (g *generator) intLeaf() ast.Node {
+	if len(g.vars) > 0 && g.r.Intn(2) == 0 {
+		return &ast.Ident{Name: g.vars[g.r.Intn(len(g.vars))]}
+	}
+	return &ast.Num{Value: fmt.Sprint(g.r.Intn(10))}
+}
+
+// bool returns an expression that evaluates to a bool.
+func (g *generator) bool(depth int) ast.Node {
+	if depth <= 0 {
+		return &ast.Ident{Name: fmt.Sprint(g.r.Intn(2) == 0)}
+	}
+	d := depth - 1
+	switch g.r.Intn(7) {
+	default:
+		ops := []string{"eq", "neq", "lt", "le", "gt", "ge"}
+		return op(ops[g.r.Intn(len(ops))], g.int(d), g.int(d))
+	case 0:
+		return op("not", g.bool(d))
+	case 1:
+		return &ast.Logic{Op: [...]lex.TType{lex.TAnd, lex.TOr}[g.r.Intn(2)], X: g.bool(d), Y: g.bool(d)}
+	case 2:
+		return &ast.Cond{Test: g.bool(d), If: g.bool(d), Else: g.bool(d)}
+	case 3:
+		strs := []string{"", "a", "b"}
+		return op("eq", &ast.Str{Value: strs[g.r.Intn(3)]}, &ast.Str{Value: strs[g.r.Intn(3)]})
+	}
+}
+
+// scope calls f with params in scope.
+func (g *generator) scope(params []*ast.Ident, f func() ast.Node) ast.Node {
+	n := len(g.vars)
+	for _, p := range params {
+		g.vars = append(g.vars, p.Name)
+	}
+	defer func() { g.vars = g.vars[:n] }()
+	return f()
+}
+
+func (g *generator) newName() string {
+	g.names++
+	return fmt.Sprint("v", g.names)
+}
+
+// op returns a call of a built-in operator function.
+func op(name string, args ...ast.Node) *ast.Call {
+	return &ast.Call{F: &ast.Ident{Name: name}, Args: args}
+}
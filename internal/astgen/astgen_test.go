@@ -0,0 +1,34 @@
+package astgen
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+)
+
+// Printing random trees and parsing them back must give the same tree.
+func TestSourceRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		want := RandomExpr(r, 6)
+		src := ast.Source(want)
+		have, err := ast.ParseExpr(strings.NewReader(src))
+		if err != nil {
+			t.Fatalf("%v: %v", src, err)
+		}
+		// random trees have no source positions
+		ast.Walk(have, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				id.Pos = se.Position{}
+			}
+			return true
+		})
+		if !reflect.DeepEqual(have, want) {
+			t.Fatalf("%v: have %v, want %v", src, ast.ToString(have), ast.ToString(want))
+		}
+	}
+}
@@ -0,0 +1,35 @@
+// Package bench provides the programs used by the benchmarks of several packages.
+package bench
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Program is a representative program for benchmarks.
+type Program struct {
+	Name string
+	Src  string
+}
+
+// Programs returns the programs in testdata/bench, and project euler problem 1.
+// Paths are relative to a package directory in the repository root,
+// where the go tool runs its benchmarks.
+func Programs(b *testing.B) []Program {
+	files, err := filepath.Glob("../testdata/bench/*.howl")
+	if err != nil {
+		b.Fatal(err)
+	}
+	files = append(files, "../project-euler/problem01.howl")
+	var progs []Program
+	for _, f := range files {
+		src, err := ioutil.ReadFile(f)
+		if err != nil {
+			b.Fatal(err)
+		}
+		progs = append(progs, Program{strings.TrimSuffix(filepath.Base(f), ".howl"), string(src)})
+	}
+	return progs
+}
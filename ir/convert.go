@@ -0,0 +1,314 @@
+package ir
+
+import (
+	"fmt"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/lex"
+)
+
+// ModuleAST is implemented by the modules that a loader stores in ast.Import.Module.
+type ModuleAST interface {
+	// AST returns the module's file, its resolved AST, whose body is a block,
+	// and the identifiers of its exported definitions.
+	AST() (file string, root *ast.Lambda, exports []*ast.Ident)
+}
+
+// Convert converts a resolved program, like returned by eva.Loader.ResolveAST.
+func Convert(root ast.Node) (_ *Program, err error) {
+	defer func() {
+		switch e := recover().(type) {
+		case nil: //OK
+		default:
+			panic(e)
+		case se.Error:
+			err = e
+		}
+	}()
+
+	c := &converter{prog: &Program{}, modules: make(map[ModuleAST]int)}
+	main := c.newFunc("main", nil)
+	main.fn.Body = c.expr(root)
+	return c.prog, nil
+}
+
+type converter struct {
+	prog    *Program
+	fn      *frame // function being converted
+	modules map[ModuleAST]int
+}
+
+// frame maps the variables of a lambda to those of its Func.
+type frame struct {
+	fn     *Func
+	vars   map[ast.Var]Var // arguments and captured variables
+	locals map[int]Var     // by ast.LocVar index, numbered in order of appearance
+}
+
+// newFunc adds a Func for lambda n (nil for the entry point),
+// with its arguments and environment.
+func (c *converter) newFunc(name string, n *ast.Lambda) *frame {
+	f := &frame{fn: &Func{Name: name}, vars: make(map[ast.Var]Var), locals: make(map[int]Var)}
+	c.prog.Funcs = append(c.prog.Funcs, f.fn)
+	if n != nil {
+		f.fn.NumArgs = len(n.Args)
+		for i, a := range n.Args {
+			f.vars[a.Var] = Var{ArgVar, i}
+		}
+		for i, cp := range n.Caps {
+			f.vars[cp.Dst] = Var{EnvVar, i}
+			f.fn.Env = append(f.fn.Env, cp.Name)
+		}
+	}
+	c.fn = f
+	return f
+}
+
+// v returns the Var that v refers to in the current function.
+func (c *converter) v(v ast.Var) Var {
+	f := c.fn
+	if x, ok := f.vars[v]; ok {
+		return x
+	}
+	l, ok := v.(*ast.LocVar)
+	if !ok {
+		panic(fmt.Sprintf("BUG: ir: unknown variable %v", v))
+	}
+	if x, ok := f.locals[l.Index]; ok {
+		return x
+	}
+	x := Var{LocalVar, f.fn.NumLocals}
+	f.fn.NumLocals++
+	f.locals[l.Index] = x
+	return x
+}
+
+func (c *converter) expr(n ast.Node) Expr {
+	switch n := n.(type) {
+	default:
+		panic(unhandled(n))
+	case *ast.Block:
+		return c.block(n, nil)
+	case *ast.Call:
+		call := &Call{F: c.expr(n.F)}
+		for _, a := range n.Args {
+			call.Args = append(call.Args, c.expr(a))
+		}
+		return call
+	case *ast.Cond:
+		return &Cond{Test: c.expr(n.Test), If: c.expr(n.If), Else: c.expr(n.Else)}
+	case *ast.Ident:
+		if n.Var == nil {
+			return &Global{Name: n.Name, Pos: n.Pos}
+		}
+		return c.v(n.Var)
+	case *ast.Lambda:
+		return c.lambda("", n)
+	case *ast.List:
+		l := &List{}
+		for _, e := range n.Elems {
+			l.Elems = append(l.Elems, c.expr(e))
+		}
+		if n.Tail != nil {
+			l.Tail = c.expr(n.Tail)
+		}
+		return l
+	case *ast.Logic:
+		return &Logic{Or: n.Op == lex.TOr, X: c.expr(n.X), Y: c.expr(n.Y)}
+	case *ast.Match:
+		return c.match(n)
+	case *ast.Num:
+		return &Num{Value: n.Value}
+	case *ast.Record:
+		r := &Record{Names: n.Names}
+		for _, x := range n.Fields {
+			r.Fields = append(r.Fields, c.expr(x))
+		}
+		return r
+	case *ast.Str:
+		return &Str{Value: n.Value}
+	case *ast.Select:
+		return &Select{X: c.expr(n.X), Sel: n.Sel}
+	}
+}
+
+// block converts a block.
+// The block of a module returns export instead of its expression.
+func (c *converter) block(n *ast.Block, export *Export) Expr {
+	b := &Block{}
+	// constructors and imports first, like eva
+	for _, s := range n.Stmts {
+		switch s := s.(type) {
+		case *ast.TypeDef:
+			for _, ctor := range s.Ctors {
+				b.Assigns = append(b.Assigns, Assign{
+					Dst:   c.v(ctor.Name.Var),
+					Value: &Ctor{Type: ctor.Type, Name: ctor.Name.Name, Arity: len(ctor.Fields)},
+				})
+			}
+		case *ast.Import:
+			b.Assigns = append(b.Assigns, Assign{
+				Dst:   c.v(s.Name.Var),
+				Value: &Import{Module: c.module(s)},
+			})
+		}
+	}
+	for _, s := range n.Stmts {
+		switch s := s.(type) {
+		case *ast.Assign:
+			name := ""
+			if _, ok := s.RHS.(*ast.Lambda); ok {
+				name = s.LHS.Name
+			}
+			// allocate the destination first, so that locals are numbered in order of definition
+			dst := c.v(s.LHS.Var)
+			b.Assigns = append(b.Assigns, Assign{Dst: dst, Value: c.named(name, s.RHS)})
+		case *ast.TypeDef, *ast.Import:
+			// done above
+		default:
+			if export != nil {
+				continue // the result of a program is not used when it is imported
+			}
+			if b.Expr != nil {
+				panic(se.Errorf("block has more than 1 expression"))
+			}
+			b.Expr = c.expr(s)
+		}
+	}
+	if export != nil {
+		b.Expr = export
+	}
+	if b.Expr == nil {
+		panic(se.Errorf("block has no expression"))
+	}
+	return b
+}
+
+// named converts n, naming it if it is a lambda.
+func (c *converter) named(name string, n ast.Node) Expr {
+	if l, ok := n.(*ast.Lambda); ok {
+		return c.lambda(name, l)
+	}
+	return c.expr(n)
+}
+
+// lambda lifts n to a new Func, and returns a Closure with its environment.
+func (c *converter) lambda(name string, n *ast.Lambda) Expr {
+	parent := c.fn
+	cl := &Closure{Func: len(c.prog.Funcs)}
+	for _, cp := range n.Caps {
+		src := c.v(cp.Src)
+		cl.Env = append(cl.Env, src)
+		parent.box(src)
+	}
+
+	c.newFunc(name, n)
+	c.fn.fn.Body = c.expr(n.Body)
+	c.fn = parent
+	return cl
+}
+
+// box records that v is captured.
+func (f *frame) box(v Var) {
+	if v.Kind == EnvVar {
+		return // already in a box
+	}
+	for _, b := range f.fn.Boxed {
+		if b == v {
+			return
+		}
+	}
+	f.fn.Boxed = append(f.fn.Boxed, v)
+}
+
+// module returns the index of the module imported by n,
+// converting it if needed.
+func (c *converter) module(n *ast.Import) int {
+	m, ok := n.Module.(ModuleAST)
+	if !ok {
+		panic(se.Errorf("import %q: only allowed at the top level of a file", n.Path))
+	}
+	if i, ok := c.modules[m]; ok {
+		return i
+	}
+	file, root, exports := m.AST()
+	mod := &Module{File: file, Func: len(c.prog.Funcs)}
+	c.prog.Modules = append(c.prog.Modules, mod)
+	index := len(c.prog.Modules) - 1
+	c.modules[m] = index
+
+	parent := c.fn
+	c.newFunc(ast.ModuleName(file), root)
+	c.fn.fn.Body = c.block(root.Body.(*ast.Block), &Export{Module: index})
+	for _, id := range exports {
+		mod.Names = append(mod.Names, id.Name)
+		mod.Exports = append(mod.Exports, c.v(id.Var))
+	}
+	c.fn = parent
+	return index
+}
+
+func (c *converter) match(n *ast.Match) Expr {
+	m := &Match{}
+	for i, x := range n.X {
+		m.X = append(m.X, c.expr(x))
+		m.Tmp = append(m.Tmp, c.v(n.Tmp[i]))
+	}
+	for _, cs := range n.Cases {
+		mc := &Case{}
+		if t, ok := cs.Pat.(*ast.Tuple); ok {
+			for _, e := range t.Elems {
+				mc.Pats = append(mc.Pats, c.pattern(e))
+			}
+		} else {
+			for range n.X {
+				mc.Pats = append(mc.Pats, c.pattern(cs.Pat))
+			}
+		}
+		if cs.Guard != nil {
+			mc.Guard = c.expr(cs.Guard)
+		}
+		mc.Body = c.expr(cs.Body)
+		m.Cases = append(m.Cases, mc)
+	}
+	return m
+}
+
+func (c *converter) pattern(p ast.Pattern) Pattern {
+	switch p := p.(type) {
+	default:
+		panic(unhandled(p))
+	case *ast.Wildcard:
+		return &Wildcard{}
+	case *ast.Ident:
+		return c.v(p.Var)
+	case *ast.Lit:
+		return &Lit{Value: p.Value}
+	case *ast.CtorPat:
+		cp := &CtorPat{Type: p.Ctor.Type, Ctor: p.Name}
+		for _, a := range p.Args {
+			cp.Args = append(cp.Args, c.pattern(a))
+		}
+		return cp
+	case *ast.ListPat:
+		lp := &ListPat{}
+		for _, e := range p.Elems {
+			lp.Elems = append(lp.Elems, c.pattern(e))
+		}
+		if p.Tail != nil {
+			lp.Tail = c.pattern(p.Tail)
+		}
+		return lp
+	case *ast.RecordPat:
+		rp := &RecordPat{Names: p.Names}
+		for _, x := range p.Fields {
+			rp.Fields = append(rp.Fields, c.pattern(x))
+		}
+		return rp
+	}
+}
+
+func unhandled(x interface{}) string {
+	return fmt.Sprintf("BUG: unhandled case: %T", x)
+}
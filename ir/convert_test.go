@@ -0,0 +1,92 @@
+package ir
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/barnex/se-lang/ast"
+)
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		src  string
+		want string
+	}{
+		{`x = 1; x`, `
+f0 main(args=0 locals=0 env=[] boxed=[]): closure(f1)()
+f1 (args=0 locals=1 env=[] boxed=[]): {loc0 = 1; loc0}`},
+
+		// captured argument
+		{`f = x -> y -> x + y; f(1)(2)`, `
+f0 main(args=0 locals=0 env=[] boxed=[]): closure(f1)()
+f1 (args=0 locals=1 env=[] boxed=[]): {loc0 = closure(f2); loc0(1)(2)}
+f2 f(args=1 locals=0 env=[] boxed=[arg0]): closure(f3, arg0)
+f3 (args=1 locals=0 env=[x] boxed=[]): add(env0, arg0)`},
+
+		// recursion: captures itself
+		{`fac = n -> n < 1? 1: n*fac(n-1); fac(3)`, `
+f0 main(args=0 locals=0 env=[] boxed=[]): closure(f1)()
+f1 (args=0 locals=1 env=[] boxed=[loc0]): {loc0 = closure(f2, loc0); loc0(3)}
+f2 fac(args=1 locals=0 env=[fac] boxed=[]): (lt(arg0, 1)? 1: mul(arg0, env0(sub(arg0, 1))))`},
+
+		// captured through an intermediate lambda
+		{`a = 1; f = () -> (() -> a); f()()`, `
+f0 main(args=0 locals=0 env=[] boxed=[]): closure(f1)()
+f1 (args=0 locals=2 env=[] boxed=[loc0]): {loc0 = 1; loc1 = closure(f2, loc0); loc1()()}
+f2 f(args=0 locals=0 env=[a] boxed=[]): closure(f3, env0)
+f3 (args=0 locals=0 env=[a] boxed=[]): env0`},
+
+		// forward reference
+		{`isEven = n -> n == 0 || isOdd(n-1); isOdd = n -> n != 0 && isEven(n-1); isEven(2)`, `
+f0 main(args=0 locals=0 env=[] boxed=[]): closure(f1)()
+f1 (args=0 locals=2 env=[] boxed=[loc1 loc0]): {loc0 = closure(f2, loc1); loc1 = closure(f3, loc0); loc0(2)}
+f2 isEven(args=1 locals=0 env=[isOdd] boxed=[]): (eq(arg0, 0) || env0(sub(arg0, 1)))
+f3 isOdd(args=1 locals=0 env=[isEven] boxed=[]): (neq(arg0, 0) && env0(sub(arg0, 1)))`},
+
+		// captured pattern variable
+		{`match 3 {x -> y -> x + y}`, `
+f0 main(args=0 locals=0 env=[] boxed=[]): closure(f1)()
+f1 (args=0 locals=2 env=[] boxed=[loc1]): {match loc0=3 {loc1 -> closure(f2, loc1)}}
+f2 (args=1 locals=0 env=[x] boxed=[]): add(env0, arg0)`},
+
+		// constructors
+		{`type List = Nil | Cons(h, t); sum = l -> match l {Nil -> 0; Cons(h, t) -> h + sum(t)}; sum(Cons(1, Nil))`, `
+f0 main(args=0 locals=0 env=[] boxed=[]): closure(f1)()
+f1 (args=0 locals=3 env=[] boxed=[loc2]): {loc0 = ctor List.Nil/0; loc1 = ctor List.Cons/2; loc2 = closure(f2, loc2); loc2(loc1(1, loc0))}
+f2 sum(args=1 locals=3 env=[sum] boxed=[]): match loc0=arg0 {Nil -> 0; Cons(loc1, loc2) -> add(loc1, env0(loc2))}`},
+
+		// tuple patterns
+		{`max = (x, y) -> match x, y {(a, b) if a > b -> a; (_, b) -> b}; max`, `
+f0 main(args=0 locals=0 env=[] boxed=[]): closure(f1)()
+f1 (args=0 locals=1 env=[] boxed=[]): {loc0 = closure(f2); loc0}
+f2 max(args=2 locals=5 env=[] boxed=[]): match loc0=arg0, loc1=arg1 {loc2, loc3 if gt(loc2, loc3) -> loc2; _, loc4 -> loc4}`},
+
+		// lists and records
+		{`f = l -> match l {[] -> {n: 0}; [x | t] -> {n: x, t: [1, x | t]}}; f([2]).n`, `
+f0 main(args=0 locals=0 env=[] boxed=[]): closure(f1)()
+f1 (args=0 locals=1 env=[] boxed=[]): {loc0 = closure(f2); loc0([2]).n}
+f2 f(args=1 locals=3 env=[] boxed=[]): match loc0=arg0 {[] -> {n: 0}; [loc1 | loc2] -> {n: loc1, t: [1, loc1 | loc2]}}`},
+		{`match {a: 1} {{a: 0, b: _} -> 0; {a: x} -> x}`, `
+f0 main(args=0 locals=0 env=[] boxed=[]): closure(f1)()
+f1 (args=0 locals=2 env=[] boxed=[]): {match loc0={a: 1} {{a: 0, b: _} -> 0; {a: loc1} -> loc1}}`},
+	}
+
+	for _, c := range cases {
+		n, err := ast.ParseProgram(strings.NewReader(c.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		root := &ast.Call{F: &ast.Lambda{Body: n}} // like eva.Loader
+		if err := ast.Errors(ast.Resolve(root, "add", "eq", "gt", "lt", "mul", "neq", "sub")); err != nil {
+			t.Fatal(err)
+		}
+		p, err := Convert(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		have := strings.TrimSpace(p.String())
+		if want := strings.TrimSpace(c.want); have != want {
+			t.Errorf("%v:\nhave:\n%v\nwant:\n%v", c.src, have, want)
+		}
+	}
+}
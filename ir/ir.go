@@ -0,0 +1,358 @@
+/*
+Package ir is a closure-converted intermediate representation of resolved programs,
+for backends that do not want to deal with nested lambdas.
+
+Every lambda is lifted to a top-level Func of a Program.
+Where the lambda was, a Closure expression pairs the Func with an explicit environment:
+the variables of the enclosing function that it captures.
+Inside a Func, a variable is an argument, a local, or an entry of its environment.
+
+Variables are captured by reference, so that a function can refer to itself,
+or to a function defined later in the same block.
+The variables that are captured are listed in Func.Boxed:
+they must be stored in a box that is shared with the closures that capture them.
+
+So far, only the bytecode compiler in package eva is built on ir.
+The tree-walking evaluator and package gogen still work on the resolved AST,
+and handle captured variables themselves.
+*/
+package ir
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+)
+
+// Program is a closure-converted program.
+type Program struct {
+	Funcs   []*Func   // Funcs[0] is the entry point, without arguments or environment
+	Modules []*Module // imported modules
+}
+
+// Func is a lambda, lifted to the top level.
+type Func struct {
+	Name      string   // name the lambda is assigned to, if any, for debugging
+	NumArgs   int      //
+	NumLocals int      //
+	Env       []string // names of the captured variables, stored in the environment
+	Boxed     []Var    // arguments and locals captured by closures
+	Body      Expr
+}
+
+// Module is an imported source file.
+// Its Func, without arguments or environment, evaluates the definitions
+// and returns them with an Export expression.
+type Module struct {
+	File    string
+	Func    int
+	Names   []string // exported names
+	Exports []Var    // locals of Func holding the exported values
+}
+
+// Expr is an expression.
+type Expr interface {
+	String() string
+}
+
+// VarKind tells where a variable is stored.
+type VarKind int
+
+const (
+	ArgVar   VarKind = iota // argument of the function
+	LocalVar                // local variable of the function
+	EnvVar                  // captured variable, in the function's environment
+)
+
+// Var is a variable of the function being evaluated.
+type Var struct {
+	Kind  VarKind
+	Index int
+}
+
+func (v Var) String() string {
+	return fmt.Sprint([]string{"arg", "loc", "env"}[v.Kind], v.Index)
+}
+
+// Num is a number literal, e.g.: 1, 2.5
+type Num struct {
+	Value string
+}
+
+func (n *Num) String() string { return n.Value }
+
+// Str is a string literal, e.g.: "hello"
+type Str struct {
+	Value string // unquoted
+}
+
+func (n *Str) String() string { return strconv.Quote(n.Value) }
+
+// Global is a built-in, e.g.: add, true
+type Global struct {
+	Name string
+	Pos  se.Position // of the identifier, reported by assert and assertEq
+}
+
+func (n *Global) String() string { return n.Name }
+
+// Closure creates a function value from Funcs[Func],
+// with an environment holding (references to) the variables Env.
+type Closure struct {
+	Func int
+	Env  []Var
+}
+
+func (n *Closure) String() string {
+	return fmt.Sprintf("closure(f%v%v)", n.Func, prefixList(", ", n.Env))
+}
+
+// Ctor is a constructor of a sum type: a function with Arity arguments
+// that returns a tagged value, or the tagged value itself if Arity is 0.
+type Ctor struct {
+	Type  *ast.TypeDef // identifies the type, which may share its name with others
+	Name  string
+	Arity int
+}
+
+func (n *Ctor) String() string { return fmt.Sprintf("ctor %v.%v/%v", n.Type.Name, n.Name, n.Arity) }
+
+type Call struct {
+	F    Expr
+	Args []Expr
+}
+
+func (n *Call) String() string { return fmt.Sprintf("%v(%v)", n.F, list(n.Args)) }
+
+type Cond struct {
+	Test, If, Else Expr
+}
+
+func (n *Cond) String() string { return fmt.Sprintf("(%v? %v: %v)", n.Test, n.If, n.Else) }
+
+// Logic is a short-circuiting boolean operator.
+// Y is only evaluated if X does not determine the result.
+type Logic struct {
+	Or   bool // true for ||, false for &&
+	X, Y Expr
+}
+
+func (n *Logic) String() string {
+	op := "&&"
+	if n.Or {
+		op = "||"
+	}
+	return fmt.Sprintf("(%v %v %v)", n.X, op, n.Y)
+}
+
+// Block evaluates the assignments in order, and then Expr.
+// Constructors and imports come first, as they may be used before their definition.
+type Block struct {
+	Assigns []Assign
+	Expr    Expr
+}
+
+// Assign stores a value in a local variable.
+type Assign struct {
+	Dst   Var
+	Value Expr
+}
+
+func (n *Block) String() string {
+	var s []string
+	for _, a := range n.Assigns {
+		s = append(s, fmt.Sprint(a.Dst, " = ", a.Value))
+	}
+	s = append(s, n.Expr.String())
+	return "{" + strings.Join(s, "; ") + "}"
+}
+
+// Import evaluates Modules[Module], returning a module value.
+type Import struct {
+	Module int
+}
+
+func (n *Import) String() string { return fmt.Sprint("import m", n.Module) }
+
+// Export returns the module value of Modules[Module],
+// from the local variables of the module's function.
+type Export struct {
+	Module int
+}
+
+func (n *Export) String() string { return fmt.Sprint("export m", n.Module) }
+
+// List is a list of the values of Elems, followed by those of Tail, if not nil.
+// Like call arguments, Tail and Elems are evaluated last to first.
+type List struct {
+	Elems []Expr
+	Tail  Expr
+}
+
+func (n *List) String() string {
+	if n.Tail != nil {
+		return fmt.Sprintf("[%v | %v]", list(n.Elems), n.Tail)
+	}
+	return fmt.Sprintf("[%v]", list(n.Elems))
+}
+
+// Record is a record with a field for each of Names, holding the value of Fields.
+// Like call arguments, Fields are evaluated last to first.
+type Record struct {
+	Names  []string
+	Fields []Expr
+}
+
+func (n *Record) String() string {
+	var s []string
+	for i, f := range n.Fields {
+		s = append(s, fmt.Sprint(n.Names[i], ": ", f))
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+// Select returns the member named Sel of a module value, or the field of a record.
+type Select struct {
+	X   Expr
+	Sel string
+}
+
+func (n *Select) String() string { return fmt.Sprint(n.X, ".", n.Sel) }
+
+// Match stores the values of X in the locals Tmp,
+// and evaluates the body of the first case that matches them.
+type Match struct {
+	X     []Expr
+	Tmp   []Var
+	Cases []*Case
+}
+
+// Case matches each value of a Match against the corresponding pattern,
+// and then tests the Guard, if not nil.
+type Case struct {
+	Pats  []Pattern
+	Guard Expr
+	Body  Expr
+}
+
+func (n *Match) String() string {
+	var x, cases []string
+	for i, e := range n.X {
+		x = append(x, fmt.Sprint(n.Tmp[i], "=", e))
+	}
+	for _, c := range n.Cases {
+		s := list(c.Pats)
+		if c.Guard != nil {
+			s += fmt.Sprint(" if ", c.Guard)
+		}
+		cases = append(cases, fmt.Sprint(s, " -> ", c.Body))
+	}
+	return fmt.Sprintf("match %v {%v}", strings.Join(x, ", "), strings.Join(cases, "; "))
+}
+
+// A Pattern is a Wildcard, a variable (Var) to store the value in,
+// a Lit, a CtorPat, a ListPat or a RecordPat.
+type Pattern interface {
+	String() string
+}
+
+// Wildcard matches any value.
+type Wildcard struct{}
+
+func (*Wildcard) String() string { return "_" }
+
+// Lit matches a literal value, e.g.: 1, true
+type Lit struct {
+	Value string
+}
+
+func (p *Lit) String() string { return p.Value }
+
+// CtorPat matches a tagged value and its fields.
+type CtorPat struct {
+	Type *ast.TypeDef
+	Ctor string
+	Args []Pattern
+}
+
+func (p *CtorPat) String() string {
+	if len(p.Args) == 0 {
+		return p.Ctor
+	}
+	return fmt.Sprintf("%v(%v)", p.Ctor, list(p.Args))
+}
+
+// ListPat matches a list with an element for each of Elems,
+// followed by a tail that matches Tail or, if nil, is empty.
+type ListPat struct {
+	Elems []Pattern
+	Tail  Pattern
+}
+
+func (p *ListPat) String() string {
+	if p.Tail != nil {
+		return fmt.Sprintf("[%v | %v]", list(p.Elems), p.Tail)
+	}
+	return fmt.Sprintf("[%v]", list(p.Elems))
+}
+
+// RecordPat matches a record that has the fields Names, and their values.
+type RecordPat struct {
+	Names  []string
+	Fields []Pattern
+}
+
+func (p *RecordPat) String() string {
+	var s []string
+	for i, f := range p.Fields {
+		s = append(s, fmt.Sprint(p.Names[i], ": ", f))
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
+
+// String returns a textual dump of the program, a function per line.
+func (p *Program) String() string {
+	var s strings.Builder
+	for i, f := range p.Funcs {
+		fmt.Fprintf(&s, "f%v %v(args=%v locals=%v env=%v boxed=%v): %v\n", i, f.Name, f.NumArgs, f.NumLocals, f.Env, f.Boxed, f.Body)
+	}
+	for i, m := range p.Modules {
+		var exp []string
+		for j, n := range m.Names {
+			exp = append(exp, fmt.Sprint(n, "=", m.Exports[j]))
+		}
+		fmt.Fprintf(&s, "m%v %v: f%v %v\n", i, m.File, m.Func, exp)
+	}
+	return s.String()
+}
+
+// list formats a slice of Exprs, Vars or Patterns, separated by commas.
+func list(x interface{}) string {
+	var s []string
+	switch x := x.(type) {
+	case []Expr:
+		for _, e := range x {
+			s = append(s, e.String())
+		}
+	case []Var:
+		for _, e := range x {
+			s = append(s, e.String())
+		}
+	case []Pattern:
+		for _, e := range x {
+			s = append(s, e.String())
+		}
+	}
+	return strings.Join(s, ", ")
+}
+
+// prefixList is like list, but prefixed by sep if not empty.
+func prefixList(sep string, x interface{}) string {
+	if s := list(x); s != "" {
+		return sep + s
+	}
+	return ""
+}
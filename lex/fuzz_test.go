@@ -0,0 +1,22 @@
+package lex
+
+import "testing"
+
+// FuzzLex splits arbitrary input in tokens, and prints them,
+// checking that failures are reported as errors rather than panics:
+//
+//	go test ./lex -run FuzzLex -fuzz FuzzLex
+func FuzzLex(f *testing.F) {
+	for _, c := range lexTests {
+		f.Add(c.src)
+	}
+	for _, src := range lexErrors {
+		f.Add(src)
+	}
+	f.Fuzz(func(t *testing.T, src string) {
+		toks, _ := lexAll(src) // panics if the error is not an se.Error
+		for _, tok := range toks {
+			_ = tok.String()
+		}
+	})
+}
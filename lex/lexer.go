@@ -2,13 +2,18 @@ package lex
 
 import (
 	"io"
+	"strings"
 	"text/scanner"
 
 	se "github.com/barnex/se-lang"
 )
 
 type Lexer struct {
-	s scanner.Scanner
+	s   scanner.Scanner
+	pos se.Position // position of the last token returned by Next
+
+	prefix    string      // prefix operators split off an operator, returned next
+	prefixPos se.Position // position of prefix
 }
 
 func NewLexer(src io.Reader) *Lexer {
@@ -26,36 +31,53 @@ func NewLexer(src io.Reader) *Lexer {
 	return l
 }
 
+// KeepComments makes Next return comments as TComment tokens,
+// instead of skipping them, e.g. for formatting source text.
+func (l *Lexer) KeepComments() {
+	l.s.Mode &^= scanner.SkipComments
+}
+
+// SetFilename sets the file name reported in token positions.
+func (l *Lexer) SetFilename(name string) {
+	l.s.Filename = name
+}
+
 func (l *Lexer) Next() Token {
+	if l.prefix != "" {
+		return l.nextPrefix()
+	}
+
 	s := &l.s
 	tok := s.Scan()
 	txt := s.TokenText()
+	l.pos = se.Position{Position: s.Position}
 
 	// symbols that require not peeking
 	var ttype TType
 	switch tok {
 	case scanner.EOF:
 		ttype = TEOF
+	case scanner.Comment:
+		ttype = TComment
 	case scanner.Float:
 		ttype = TNum
 	case scanner.Ident:
 		ttype = TIdent
+		if kw, ok := keywords[txt]; ok {
+			ttype = kw
+		}
 	case scanner.Int:
 		ttype = TNum
 	case scanner.String:
 		ttype = TString
-	case '%':
-		ttype = TMod
 	case '(':
 		ttype = TLParen
 	case ')':
 		ttype = TRParen
-	case '*':
-		ttype = TMul
-	case '+':
-		ttype = TAdd
 	case ',':
 		ttype = TComma
+	case '.':
+		ttype = TDot
 	case '/':
 		ttype = TDiv
 	case ':':
@@ -64,6 +86,10 @@ func (l *Lexer) Next() Token {
 		ttype = TSemicol
 	case '?':
 		ttype = TQuestion
+	case '[':
+		ttype = TLBrack
+	case ']':
+		ttype = TRBrack
 	case '{':
 		ttype = TLBrace
 	case '}':
@@ -75,55 +101,101 @@ func (l *Lexer) Next() Token {
 		return Token{ttype, txt}
 	}
 
-	// symbols that require peeking
-	peek := s.Peek()
-	switch {
-	case tok == '!' && peek == '=':
-		ttype = TNEq
-	case tok == '&' && peek == '&':
-		ttype = TAnd
-	case tok == '-' && peek == '>':
-		ttype = TLambda
-	case tok == '<' && peek == '=':
-		ttype = TLe
-	case tok == '=' && peek == '=':
-		ttype = TEq
-	case tok == '>' && peek == '=':
-		ttype = TGe
-	case tok == '|' && peek == '|':
-		ttype = TOr
-	}
-	if ttype != 0 {
-		s.Scan()
-		return Token{ttype, txt + s.TokenText()}
+	// operators and symbols made of operator characters
+	if isOpChar(tok) {
+		return l.operator(txt)
 	}
 
-	// no peeked symbol was accepted
-	switch {
-	case tok == '!':
-		ttype = TNot
-	case tok == '-':
-		ttype = TMinus
-	case tok == '<':
-		ttype = TLt
-	case tok == '=':
-		ttype = TAssign
-	case tok == '>':
-		ttype = TGt
-	}
-	if ttype != 0 {
-		return Token{ttype, txt}
+	// `ident`: a function used as an infix operator
+	if tok == '`' {
+		return l.backquoted()
 	}
 
 	// no valid symbol was accepted
 	panic(l.syntaxError("unexpected: " + scanner.TokenString(tok)))
 }
 
+// symbols maps the symbols made of operator characters to their token type.
+var symbols = map[string]TType{
+	"!":  TNot,
+	"!=": TNEq,
+	"%":  TMod,
+	"&&": TAnd,
+	"*":  TMul,
+	"+":  TAdd,
+	"-":  TMinus,
+	"->": TLambda,
+	"<":  TLt,
+	"<<": TComposeL,
+	"<=": TLe,
+	"=":  TAssign,
+	"==": TEq,
+	">":  TGt,
+	">=": TGe,
+	">>": TCompose,
+	"|":  TBar,
+	"|>": TPipe,
+	"||": TOr,
+}
+
+func isOpChar(r rune) bool {
+	return r >= 0 && strings.ContainsRune("!$%&*+-<=>@^|~", r)
+}
+
+// operator lexes the longest run of operator characters starting with first.
+// A run that is not a symbol is a user-defined operator (TOp),
+// except a symbol followed by prefix operators - or !,
+// which is split so that e.g. x=-1 and a<-b keep their meaning.
+func (l *Lexer) operator(first string) Token {
+	op := first
+	for isOpChar(l.s.Peek()) {
+		op += string(l.s.Next())
+	}
+	if t, ok := symbols[op]; ok {
+		return Token{t, op}
+	}
+	for i := len(op) - 1; i > 0; i-- {
+		if t, ok := symbols[op[:i]]; ok && strings.Trim(op[i:], "-!") == "" {
+			l.prefix = op[i:]
+			l.prefixPos = l.pos
+			l.prefixPos.Offset += i
+			l.prefixPos.Column += i
+			return Token{t, op[:i]}
+		}
+	}
+	return Token{TOp, op}
+}
+
+// nextPrefix returns the next prefix operator split off by operator.
+func (l *Lexer) nextPrefix() Token {
+	op := l.prefix[:1]
+	l.prefix = l.prefix[1:]
+	l.pos = l.prefixPos
+	l.prefixPos.Offset++
+	l.prefixPos.Column++
+	return Token{symbols[op], op}
+}
+
+// backquoted lexes an identifier in backquotes, like `max`,
+// which uses a function as an infix operator.
+func (l *Lexer) backquoted() Token {
+	s := &l.s
+	if s.Scan() != scanner.Ident {
+		panic(l.syntaxError("expected identifier after `"))
+	}
+	name := s.TokenText()
+	if s.Scan() != '`' {
+		panic(l.syntaxError("expected ` after `" + name))
+	}
+	return Token{TOp, "`" + name + "`"}
+}
+
+// Position returns the position of the last token returned by Next.
 func (l *Lexer) Position() se.Position {
-	return se.Position{l.s.Position}
+	return l.pos
 }
 
 // returns a syntax error for the current position
 func (l *Lexer) syntaxError(msg string) error {
-	return se.Errorf("line %v: %v", l.s.Position.Line, msg)
+	return se.Errorf("%v: %v", se.Position{Position: l.s.Position}, msg)
 }
@@ -6,52 +6,69 @@ import (
 	"testing"
 
 	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/internal/bench"
 )
 
-func TestLex(t *testing.T) {
-	cases := []struct {
-		src  string
-		want []Token
-	}{
-		{``, []Token{}},
-		{`//comment`, []Token{}},
-		{"+", []Token{{TAdd, "+"}}},
-		{"=", []Token{{TAssign, "="}}},
-		{"/", []Token{{TDiv, "/"}}},
-		{"==", []Token{{TEq, "=="}}},
-		{"!=", []Token{{TNEq, "!="}}},
-		{"123.4", []Token{{TNum, "123.4"}}},
-		{">=", []Token{{TGe, ">="}}},
-		{">", []Token{{TGt, ">"}}},
-		{"ident", []Token{{TIdent, "ident"}}},
-		{"1234", []Token{{TNum, "1234"}}},
-		{"{", []Token{{TLBrace, "{"}}},
-		{"(", []Token{{TLParen, "("}}},
-		{"->", []Token{{TLambda, "->"}}},
-		{"<=", []Token{{TLe, "<="}}},
-		{"<", []Token{{TLt, "<"}}},
-		{"-", []Token{{TMinus, "-"}}},
-		{"*", []Token{{TMul, "*"}}},
-		{"}", []Token{{TRBrace, "}"}}},
-		{")", []Token{{TRParen, ")"}}},
-		{`1`, []Token{{TNum, "1"}}},
-		{`23`, []Token{{TNum, "23"}}},
-		{` 45 	678 `, []Token{{TNum, "45"}, {TNum, "678"}}},
-		{`x foo bar2`, []Token{{TIdent, "x"}, {TIdent, "foo"}, {TIdent, "bar2"}}},
-		{` x foo bar0 `, []Token{{TIdent, "x"}, {TIdent, "foo"}, {TIdent, "bar0"}}},
-		{`((foo )`, []Token{{TLParen, "("}, {TLParen, "("}, {TIdent, "foo"}, {TRParen, ")"}}},
-		{` " a 1 () "`, []Token{{TString, `" a 1 () "`}}},
-		{`""`, []Token{{TString, `""`}}},
-		{`a+b*c`, []Token{{TIdent, "a"}, {TAdd, "+"}, {TIdent, "b"}, {TMul, "*"}, {TIdent, "c"}}},
-		{`a==b`, []Token{{TIdent, "a"}, {TEq, "=="}, {TIdent, "b"}}},
-		{`%`, []Token{{TMod, "%"}}},
-		{`a&&b||!c`, []Token{{TIdent, "a"}, {TAnd, "&&"}, {TIdent, "b"}, {TOr, "||"}, {TNot, "!"}, {TIdent, "c"}}},
-		{`x=1;x`, []Token{{TIdent, "x"}, {TAssign, "="}, {TNum, "1"}, {TSemicol, ";"}, {TIdent, "x"}}},
-		{`'x`, []Token{{TQuote, "'"}, {TIdent, "x"}}},
-		{`x?1:2`, []Token{{TIdent, "x"}, {TQuestion, "?"}, {TNum, "1"}, {TColon, ":"}, {TNum, "2"}}},
-	}
+// lexTests are valid inputs and their tokens, also used to seed FuzzLex.
+var lexTests = []struct {
+	src  string
+	want []Token
+}{
+	{``, []Token{}},
+	{`//comment`, []Token{}},
+	{"+", []Token{{TAdd, "+"}}},
+	{"=", []Token{{TAssign, "="}}},
+	{"/", []Token{{TDiv, "/"}}},
+	{"==", []Token{{TEq, "=="}}},
+	{"!=", []Token{{TNEq, "!="}}},
+	{"123.4", []Token{{TNum, "123.4"}}},
+	{">=", []Token{{TGe, ">="}}},
+	{">", []Token{{TGt, ">"}}},
+	{"ident", []Token{{TIdent, "ident"}}},
+	{"1234", []Token{{TNum, "1234"}}},
+	{"{", []Token{{TLBrace, "{"}}},
+	{"(", []Token{{TLParen, "("}}},
+	{"->", []Token{{TLambda, "->"}}},
+	{"<=", []Token{{TLe, "<="}}},
+	{"<", []Token{{TLt, "<"}}},
+	{"-", []Token{{TMinus, "-"}}},
+	{"*", []Token{{TMul, "*"}}},
+	{"}", []Token{{TRBrace, "}"}}},
+	{")", []Token{{TRParen, ")"}}},
+	{`1`, []Token{{TNum, "1"}}},
+	{`23`, []Token{{TNum, "23"}}},
+	{` 45 	678 `, []Token{{TNum, "45"}, {TNum, "678"}}},
+	{`x foo bar2`, []Token{{TIdent, "x"}, {TIdent, "foo"}, {TIdent, "bar2"}}},
+	{` x foo bar0 `, []Token{{TIdent, "x"}, {TIdent, "foo"}, {TIdent, "bar0"}}},
+	{`((foo )`, []Token{{TLParen, "("}, {TLParen, "("}, {TIdent, "foo"}, {TRParen, ")"}}},
+	{` " a 1 () "`, []Token{{TString, `" a 1 () "`}}},
+	{`""`, []Token{{TString, `""`}}},
+	{`a+b*c`, []Token{{TIdent, "a"}, {TAdd, "+"}, {TIdent, "b"}, {TMul, "*"}, {TIdent, "c"}}},
+	{`a==b`, []Token{{TIdent, "a"}, {TEq, "=="}, {TIdent, "b"}}},
+	{`%`, []Token{{TMod, "%"}}},
+	{`a&&b||!c`, []Token{{TIdent, "a"}, {TAnd, "&&"}, {TIdent, "b"}, {TOr, "||"}, {TNot, "!"}, {TIdent, "c"}}},
+	{`x=1;x`, []Token{{TIdent, "x"}, {TAssign, "="}, {TNum, "1"}, {TSemicol, ";"}, {TIdent, "x"}}},
+	{`'x`, []Token{{TQuote, "'"}, {TIdent, "x"}}},
+	{`match x {_ -> 1}`, []Token{{TMatch, "match"}, {TIdent, "x"}, {TLBrace, "{"}, {TIdent, "_"}, {TLambda, "->"}, {TNum, "1"}, {TRBrace, "}"}}},
+	{`n if n`, []Token{{TIdent, "n"}, {TIf, "if"}, {TIdent, "n"}}},
+	{`type T = A | B(x)`, []Token{{TTypedef, "type"}, {TIdent, "T"}, {TAssign, "="}, {TIdent, "A"}, {TBar, "|"}, {TIdent, "B"}, {TLParen, "("}, {TIdent, "x"}, {TRParen, ")"}}},
+	{`import "lib.howl"; lib.f`, []Token{{TImport, "import"}, {TString, `"lib.howl"`}, {TSemicol, ";"}, {TIdent, "lib"}, {TDot, "."}, {TIdent, "f"}}},
+	{`x?1:2`, []Token{{TIdent, "x"}, {TQuestion, "?"}, {TNum, "1"}, {TColon, ":"}, {TNum, "2"}}},
+	{`x|>f>>g<<h`, []Token{{TIdent, "x"}, {TPipe, "|>"}, {TIdent, "f"}, {TCompose, ">>"}, {TIdent, "g"}, {TComposeL, "<<"}, {TIdent, "h"}}},
+	{`a<>b`, []Token{{TIdent, "a"}, {TOp, "<>"}, {TIdent, "b"}}},
+	{`a ++ b`, []Token{{TIdent, "a"}, {TOp, "++"}, {TIdent, "b"}}},
+	{`a&b`, []Token{{TIdent, "a"}, {TOp, "&"}, {TIdent, "b"}}},
+	{"a `max` b", []Token{{TIdent, "a"}, {TOp, "`max`"}, {TIdent, "b"}}},
+	{`infixl 6 <> = f`, []Token{{TInfixl, "infixl"}, {TNum, "6"}, {TOp, "<>"}, {TAssign, "="}, {TIdent, "f"}}},
+	{`infixr 1 $`, []Token{{TInfixr, "infixr"}, {TNum, "1"}, {TOp, "$"}}},
+	{`x=-1`, []Token{{TIdent, "x"}, {TAssign, "="}, {TMinus, "-"}, {TNum, "1"}}},
+	{`a<-b`, []Token{{TIdent, "a"}, {TLt, "<"}, {TMinus, "-"}, {TIdent, "b"}}},
+	{`x->-!x`, []Token{{TIdent, "x"}, {TLambda, "->"}, {TMinus, "-"}, {TNot, "!"}, {TIdent, "x"}}},
+	{`--x`, []Token{{TMinus, "-"}, {TMinus, "-"}, {TIdent, "x"}}},
+}
 
-	for _, c := range cases {
+func TestLex(t *testing.T) {
+	for _, c := range lexTests {
 		have, err := lexAll(c.src)
 		if err != nil {
 			t.Errorf("%v: error: %v", c.src, err)
@@ -64,14 +81,18 @@ func TestLex(t *testing.T) {
 	}
 }
 
-func TestError(t *testing.T) {
-	cases := []string{
-		`$`,
-		`"`,
-		`"""`,
-	}
+// lexErrors are inputs with a syntax error.
+var lexErrors = []string{
+	`#`,
+	"`",
+	"`1`",
+	"`x",
+	`"`,
+	`"""`,
+}
 
-	for _, src := range cases {
+func TestError(t *testing.T) {
+	for _, src := range lexErrors {
 		_, err := lexAll(src)
 		if err == nil {
 			t.Errorf("%v: expected error", src)
@@ -80,6 +101,19 @@ func TestError(t *testing.T) {
 	}
 }
 
+func TestErrorPosition(t *testing.T) {
+	defer func() {
+		want := `f.howl:2:3: unexpected: "#"`
+		if err := recover(); err == nil || err.(error).Error() != want {
+			t.Errorf("have %v, want %v", err, want)
+		}
+	}()
+	l := NewLexer(strings.NewReader("x\n  #"))
+	l.SetFilename("f.howl")
+	for l.Next().TType != TEOF {
+	}
+}
+
 // lexAll splits a string in tokens.
 func lexAll(input string) (t []Token, e error) {
 	// catch syntax errors
@@ -109,3 +143,42 @@ func lexAll(input string) (t []Token, e error) {
 
 	return out, nil
 }
+
+func TestPosition(t *testing.T) {
+	l := NewLexer(strings.NewReader("x ->\n  y"))
+	l.SetFilename("f.howl")
+	for _, want := range []string{"f.howl:1:1", "f.howl:1:3", "f.howl:2:3"} {
+		l.Next()
+		if have := l.Position().String(); have != want {
+			t.Errorf("have %v, want %v", have, want)
+		}
+	}
+}
+
+func TestTTypeString(t *testing.T) {
+	for _, c := range []struct {
+		t    TType
+		want string
+	}{
+		{TAdd, "+"},
+		{TType(-1), "TType(-1)"},
+	} {
+		if have := c.t.String(); have != c.want {
+			t.Errorf("have %v, want %v", have, c.want)
+		}
+	}
+}
+
+func BenchmarkLex(b *testing.B) {
+	for _, p := range bench.Programs(b) {
+		b.Run(p.Name, func(b *testing.B) {
+			b.SetBytes(int64(len(p.Src)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				l := NewLexer(strings.NewReader(p.Src))
+				for l.Next().TType != TEOF {
+				}
+			}
+		})
+	}
+}
@@ -28,69 +28,109 @@ const (
 	TAdd            // +
 	TAnd            // &&
 	TAssign         // =
+	TBar            // |
 	TColon          // :
 	TComma          // ,
+	TComment        // comment, only if the Lexer keeps them
+	TCompose        // >>
+	TComposeL       // <<
 	TDiv            // /
+	TDot            // .
 	TEOF            // end-of-file
 	TEq             // ==
 	TGe             // >=
 	TGt             // >
 	TIdent          // identifer
+	TIf             // if
+	TImport         // import
+	TInfixl         // infixl
+	TInfixr         // infixr
 	TLBrace         // {
+	TLBrack         // [
 	TLParen         // (
 	TLambda         // ->
 	TLe             // <=
 	TLt             // <
+	TMatch          // match
 	TMinus          // -
 	TMod            // %
 	TMul            // *
 	TNEq            // !=
 	TNot            // !
 	TNum            // number
+	TOp             // user-defined operator: symbols, or a backquoted identifier
 	TOr             // ||
+	TPipe           // |>
 	TQuestion       // ?
 	TQuote          // '
 	TRBrace         // }
+	TRBrack         // ]
 	TRParen         // )
 	TSemicol        // ;
 	TString         // string
+	TTypedef        // type
 )
 
 var ttypeString = map[TType]string{
 	TAdd:      "+",
 	TAnd:      "&&",
 	TAssign:   "=",
+	TBar:      "|",
 	TColon:    ":",
 	TComma:    ",",
+	TComment:  "comment",
+	TCompose:  ">>",
+	TComposeL: "<<",
 	TDiv:      "/",
+	TDot:      ".",
 	TEOF:      "EOF",
 	TEq:       "==",
 	TGe:       ">=",
 	TGt:       ">",
 	TIdent:    "identifer",
+	TIf:       "if",
+	TImport:   "import",
+	TInfixl:   "infixl",
+	TInfixr:   "infixr",
 	TLBrace:   "{",
+	TLBrack:   "[",
 	TLParen:   "(",
 	TLambda:   "->",
 	TLe:       "<=",
 	TLt:       "<",
+	TMatch:    "match",
 	TMinus:    "-",
 	TMod:      "%",
 	TMul:      "*",
 	TNEq:      "!=",
 	TNot:      "!",
 	TNum:      "number",
+	TOp:       "operator",
 	TOr:       "||",
+	TPipe:     "|>",
 	TQuestion: "?",
 	TQuote:    "'",
 	TRBrace:   "}",
+	TRBrack:   "]",
 	TRParen:   ")",
 	TSemicol:  ";",
 	TString:   "string",
+	TTypedef:  "type",
+}
+
+// keywords maps reserved identifiers to their token type.
+var keywords = map[string]TType{
+	"if":     TIf,
+	"import": TImport,
+	"infixl": TInfixl,
+	"infixr": TInfixr,
+	"match":  TMatch,
+	"type":   TTypedef,
 }
 
 func (t TType) String() string {
 	if str, ok := ttypeString[t]; ok {
 		return str
 	}
-	panic(fmt.Sprintf("bad TType: %q", rune(t)))
+	return fmt.Sprintf("TType(%d)", t)
 }
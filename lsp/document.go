@@ -0,0 +1,487 @@
+package lsp
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	se "github.com/barnex/se-lang"
+	"github.com/barnex/se-lang/ast"
+	"github.com/barnex/se-lang/eva"
+	"github.com/barnex/se-lang/format"
+	"github.com/barnex/se-lang/lex"
+)
+
+// document is an open source file, parsed and resolved.
+type document struct {
+	uri   string
+	file  string // file name, reported in positions
+	text  string
+	lines []int        // byte offset of the start of each line
+	diags []Diagnostic // never nil, an empty list clears the client's diagnostics
+
+	// identifiers that appear in the source, in order, if the document parses.
+	occs  []*occurrence
+	decls map[ast.Var]*decl
+}
+
+// occurrence is an identifier in the source text.
+type occurrence struct {
+	line, col int // 1-based, col counts characters, like se.Position
+	name      string
+	v         ast.Var // variable, after following captures; nil for built-ins
+	captured  bool    // refers to a variable of an enclosing lambda
+}
+
+// decl describes the declaration of a variable.
+type decl struct {
+	line, col int
+	sig       string // e.g. "fib = n -> …", may be empty
+	desc      string // e.g. "function"
+	ctor      bool
+}
+
+var builtins = make(map[string]bool)
+
+func init() {
+	for _, n := range eva.Builtins() {
+		builtins[n] = true
+	}
+}
+
+func analyze(uri, text string) *document {
+	d := &document{
+		uri:   uri,
+		file:  filename(uri),
+		text:  text,
+		diags: []Diagnostic{},
+		decls: make(map[ast.Var]*decl),
+	}
+	d.lines = append(d.lines, 0)
+	for i, c := range text {
+		if c == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+
+	root, err := ast.ParseFile(d.file, strings.NewReader(text))
+	if err != nil {
+		d.diags = append(d.diags, d.syntaxError(err))
+		return d
+	}
+	// like eva.Loader, wrap the program in a lambda to provide a frame for top-level variables.
+	prog := &ast.Lambda{Body: root}
+	for _, diag := range ast.Resolve(prog, eva.Builtins()...) {
+		d.diags = append(d.diags, d.diagnostic(diag))
+	}
+	d.index(prog)
+	return d
+}
+
+// filename returns the file name for a file:// URI, or the URI itself.
+func filename(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return u.Path
+}
+
+// index records the declarations and occurrences of identifiers in a resolved program.
+func (d *document) index(prog ast.Node) {
+	src := make(map[ast.Var]ast.Var) // variable captured by a lambda -> variable it was captured from
+	ast.Walk(prog, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Assign:
+			if l, ok := n.RHS.(*ast.Lambda); ok {
+				d.declare(n.LHS, fmt.Sprint(n.LHS.Name, " = ", lambdaSig(l)), "function")
+			} else if num, ok := n.RHS.(*ast.Num); ok {
+				d.declare(n.LHS, fmt.Sprint(n.LHS.Name, " = ", num.Value), "variable")
+			} else {
+				d.declare(n.LHS, fmt.Sprint(n.LHS.Name, " = …"), "variable")
+			}
+		case *ast.Case:
+			ast.Walk(n.Pat, func(p ast.Node) bool {
+				if id, ok := p.(*ast.Ident); ok {
+					d.declare(id, "", "pattern variable "+id.Name)
+				}
+				return true
+			})
+		case *ast.Import:
+			d.declare(n.Name, fmt.Sprint("import ", n.Name.Name, " ", strconv.Quote(n.Path)), "imported module")
+		case *ast.Lambda:
+			for _, c := range n.Caps {
+				src[c.Dst] = c.Src
+			}
+			for _, a := range n.Args {
+				d.declare(a, lambdaSig(n), "argument "+a.Name)
+			}
+		case *ast.TypeDef:
+			for _, c := range n.Ctors {
+				d.declare(c.Name, typeSig(n), "constructor "+c.Name.Name)
+				if dc := d.decls[c.Name.Var]; dc != nil {
+					dc.ctor = true
+				}
+			}
+		}
+		return true
+	})
+
+	origin := func(v ast.Var) ast.Var {
+		for src[v] != nil {
+			v = src[v]
+		}
+		return v
+	}
+	ast.Walk(prog, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.Ident:
+			d.occur(n.Pos, n.Name, origin(n.Var), n.Var != origin(n.Var))
+		case *ast.CtorPat:
+			if n.Ctor != nil {
+				d.occur(n.Pos, n.Name, n.Ctor.Name.Var, false)
+			}
+		}
+		return true
+	})
+}
+
+func (d *document) declare(id *ast.Ident, sig, desc string) {
+	if id.Var == nil {
+		return // not resolved due to an error
+	}
+	d.decls[id.Var] = &decl{line: id.Pos.Line, col: id.Pos.Column, sig: sig, desc: desc}
+}
+
+// occur records an identifier, unless it does not appear in the source,
+// like the identifiers that operators are translated to.
+func (d *document) occur(pos se.Position, name string, v ast.Var, captured bool) {
+	off := d.offset(pos.Line, pos.Column)
+	if off < 0 || !strings.HasPrefix(d.text[off:], name) {
+		return
+	}
+	if r, _ := utf8.DecodeRuneInString(d.text[off+len(name):]); isIdentRune(r) {
+		return
+	}
+	d.occs = append(d.occs, &occurrence{line: pos.Line, col: pos.Column, name: name, v: v, captured: captured})
+}
+
+func isIdentRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func lambdaSig(l *ast.Lambda) string {
+	var args []string
+	for _, a := range l.Args {
+		args = append(args, a.Name)
+	}
+	return fmt.Sprintf("(%v) -> …", strings.Join(args, ", "))
+}
+
+func typeSig(t *ast.TypeDef) string {
+	var ctors []string
+	for _, c := range t.Ctors {
+		if len(c.Fields) == 0 {
+			ctors = append(ctors, c.Name.Name)
+		} else {
+			ctors = append(ctors, fmt.Sprintf("%v(%v)", c.Name.Name, strings.Join(c.Fields, ", ")))
+		}
+	}
+	return fmt.Sprintf("type %v = %v", t.Name, strings.Join(ctors, " | "))
+}
+
+// -------- requests
+
+// at returns the identifier at (or just after) position p, or nil.
+func (d *document) at(p Position) *occurrence {
+	line, col := d.scanPos(p)
+	for _, o := range d.occs {
+		if o.line == line && col >= o.col && col <= o.col+utf8.RuneCountInString(o.name) {
+			return o
+		}
+	}
+	return nil
+}
+
+func (d *document) definition(p Position) *Location {
+	o := d.at(p)
+	if o == nil || d.decls[o.v] == nil {
+		return nil
+	}
+	dc := d.decls[o.v]
+	return &Location{URI: d.uri, Range: d.identRange(dc.line, dc.col, o.name)}
+}
+
+func (d *document) references(p Position, includeDecl bool) []Location {
+	locs := []Location{}
+	o := d.at(p)
+	if o == nil || d.decls[o.v] == nil {
+		return locs
+	}
+	dc := d.decls[o.v]
+	for _, r := range d.occs {
+		if r.v != o.v || !includeDecl && r.line == dc.line && r.col == dc.col {
+			continue
+		}
+		locs = append(locs, Location{URI: d.uri, Range: d.identRange(r.line, r.col, r.name)})
+	}
+	return locs
+}
+
+func (d *document) hover(p Position) *Hover {
+	o := d.at(p)
+	if o == nil {
+		return nil
+	}
+	var s strings.Builder
+	if dc := d.decls[o.v]; dc != nil {
+		if dc.sig != "" {
+			fmt.Fprintf(&s, "```se\n%v\n```\n", dc.sig)
+		}
+		fmt.Fprintf(&s, "%v, declared at line %v", dc.desc, dc.line)
+		if o.captured {
+			s.WriteString(", captured from an enclosing function")
+		}
+	} else if builtins[o.name] {
+		fmt.Fprintf(&s, "built-in %v", o.name)
+	} else {
+		return nil // undefined
+	}
+	return &Hover{
+		Contents: MarkupContent{Kind: "markdown", Value: s.String()},
+		Range:    d.identRange(o.line, o.col, o.name),
+	}
+}
+
+// rename returns the edits that rename the variable at position p.
+// Bindings of a file may be used by importers, which are not updated.
+func (d *document) rename(p Position, newName string) ([]TextEdit, error) {
+	o := d.at(p)
+	if o == nil || d.decls[o.v] == nil {
+		return nil, fmt.Errorf("no variable to rename here")
+	}
+	if err := checkName(newName, d.decls[o.v].ctor); err != nil {
+		return nil, err
+	}
+
+	// rename occurrences from last to first, so that offsets remain valid
+	var refs []*occurrence
+	for _, r := range d.occs {
+		if r.v == o.v {
+			refs = append(refs, r)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		a, b := refs[i], refs[j]
+		return a.line < b.line || a.line == b.line && a.col < b.col
+	})
+	text := d.text
+	for i := len(refs) - 1; i >= 0; i-- {
+		off := d.offset(refs[i].line, refs[i].col)
+		text = text[:off] + newName + text[off+len(o.name):]
+	}
+
+	// the renamed identifiers must refer to the same variable as before,
+	// and no other identifiers may refer to it.
+	after := analyze(d.uri, text)
+	var want []string
+	shift := 0 // of later occurrences on the same line
+	for i, r := range refs {
+		if i > 0 && refs[i-1].line != r.line {
+			shift = 0
+		}
+		want = append(want, fmt.Sprint(r.line, ":", r.col+shift))
+		shift += utf8.RuneCountInString(newName) - utf8.RuneCountInString(o.name)
+	}
+	var have []string
+	if a := after.at(Position{Line: refs[0].line - 1, Character: d.character(refs[0].line, refs[0].col)}); a != nil && a.v != nil {
+		for _, r := range after.occs {
+			if r.v == a.v {
+				have = append(have, fmt.Sprint(r.line, ":", r.col))
+			}
+		}
+	}
+	if strings.Join(have, " ") != strings.Join(want, " ") || after.numErrors() > d.numErrors() {
+		return nil, fmt.Errorf("renaming %v to %v would change the meaning of the program", o.name, newName)
+	}
+
+	var edits []TextEdit
+	for _, r := range refs {
+		edits = append(edits, TextEdit{Range: d.identRange(r.line, r.col, r.name), NewText: newName})
+	}
+	return edits, nil
+}
+
+// checkName returns an error if name is not a valid name for a variable,
+// or a constructor if ctor is true.
+func checkName(name string, ctor bool) error {
+	if builtins[name] {
+		return fmt.Errorf("%v is a built-in", name)
+	}
+	l := lex.NewLexer(strings.NewReader(name))
+	var toks []lex.Token
+	err := func() (err error) {
+		defer func() {
+			if e, ok := recover().(se.Error); ok {
+				err = e
+			}
+		}()
+		for t := l.Next(); t.TType != lex.TEOF; t = l.Next() {
+			toks = append(toks, t)
+		}
+		return nil
+	}()
+	if err != nil || len(toks) != 1 || toks[0].TType != lex.TIdent || toks[0].Value != name || name == "_" {
+		return fmt.Errorf("%q is not a valid identifier", name)
+	}
+	if upper := unicode.IsUpper([]rune(name)[0]); upper != ctor {
+		if ctor {
+			return fmt.Errorf("constructor %v must start with an upper case letter", name)
+		}
+		return fmt.Errorf("variable %v must not start with an upper case letter", name)
+	}
+	return nil
+}
+
+func (d *document) numErrors() int {
+	n := 0
+	for _, diag := range d.diags {
+		if diag.Severity == SeverityError {
+			n++
+		}
+	}
+	return n
+}
+
+// format returns an edit that replaces the whole document by its formatted text,
+// or no edits if it is already formatted.
+func (d *document) format() ([]TextEdit, error) {
+	out, err := format.Source([]byte(d.text))
+	if err != nil {
+		return nil, err
+	}
+	if string(out) == d.text {
+		return []TextEdit{}, nil
+	}
+	last := len(d.lines)
+	end := Position{Line: last - 1, Character: d.character(last, utf8.RuneCountInString(d.text[d.lines[last-1]:])+1)}
+	return []TextEdit{{Range: Range{End: end}, NewText: string(out)}}, nil
+}
+
+// -------- diagnostics
+
+func (d *document) diagnostic(diag ast.Diag) Diagnostic {
+	sev := SeverityError
+	if diag.Warning {
+		sev = SeverityWarning
+	}
+	return Diagnostic{
+		Range:    d.wordRange(diag.Pos.Line, diag.Pos.Column),
+		Severity: sev,
+		Source:   "se",
+		Message:  diag.Msg,
+	}
+}
+
+// syntaxError returns the diagnostic for a parse error.
+// The position is taken from the message, e.g.:
+//
+//	file.howl:3:5: unexpected ')'
+func (d *document) syntaxError(err error) Diagnostic {
+	msg := err.Error()
+	line, col := 1, 1
+	if m := strings.TrimPrefix(msg, d.file+":"); m != msg {
+		if n, _ := fmt.Sscanf(m, "%d:%d:", &line, &col); n == 2 {
+			msg = strings.TrimSpace(m[strings.Index(m, ": ")+1:])
+		}
+	}
+	return Diagnostic{Range: d.wordRange(line, col), Severity: SeverityError, Source: "se", Message: msg}
+}
+
+// -------- positions
+
+// offset returns the byte offset of a 1-based line and column (counting characters),
+// or -1 if out of range.
+func (d *document) offset(line, col int) int {
+	if line < 1 || line > len(d.lines) || col < 1 {
+		return -1
+	}
+	off := d.lines[line-1]
+	for i := 1; i < col; i++ {
+		r, n := utf8.DecodeRuneInString(d.text[off:])
+		if n == 0 || r == '\n' {
+			return -1
+		}
+		off += n
+	}
+	return off
+}
+
+// character returns the LSP character offset (in UTF-16 code units) of a 1-based line and column.
+func (d *document) character(line, col int) int {
+	if line < 1 || line > len(d.lines) {
+		return 0
+	}
+	c := 0
+	rest := d.text[d.lines[line-1]:]
+	for i := 1; i < col && rest != ""; i++ {
+		r, n := utf8.DecodeRuneInString(rest)
+		if r == '\n' {
+			break
+		}
+		c += len(utf16.Encode([]rune{r}))
+		rest = rest[n:]
+	}
+	return c
+}
+
+// scanPos converts an LSP position to a 1-based line and column.
+func (d *document) scanPos(p Position) (line, col int) {
+	line, col = p.Line+1, 1
+	if line < 1 || line > len(d.lines) {
+		return line, col
+	}
+	rest := d.text[d.lines[line-1]:]
+	for c := 0; c < p.Character && rest != ""; col++ {
+		r, n := utf8.DecodeRuneInString(rest)
+		if r == '\n' {
+			break
+		}
+		c += len(utf16.Encode([]rune{r}))
+		rest = rest[n:]
+	}
+	return line, col
+}
+
+func (d *document) pos(line, col int) Position {
+	return Position{Line: line - 1, Character: d.character(line, col)}
+}
+
+func (d *document) identRange(line, col int, name string) Range {
+	return Range{Start: d.pos(line, col), End: d.pos(line, col+utf8.RuneCountInString(name))}
+}
+
+// wordRange returns the range of the identifier starting at line and column,
+// or of the character there if it does not start an identifier.
+func (d *document) wordRange(line, col int) Range {
+	off := d.offset(line, col)
+	if off < 0 {
+		return Range{Start: d.pos(line, col), End: d.pos(line, col)}
+	}
+	n := 0
+	for _, r := range d.text[off:] {
+		if !isIdentRune(r) {
+			break
+		}
+		n++
+	}
+	if n == 0 {
+		n = 1
+	}
+	return Range{Start: d.pos(line, col), End: d.pos(line, col+n)}
+}
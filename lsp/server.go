@@ -0,0 +1,267 @@
+/*
+Package lsp implements a Language Server Protocol server for se-lang,
+used by editors through 'se lsp'.
+
+It offers diagnostics from the parser and resolver,
+go to definition, find references, hover, rename and document formatting.
+se-lang is dynamically typed, so hover shows how an identifier is bound,
+not its type.
+*/
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Serve reads requests from r and writes responses to w,
+// until the client sends exit or closes r.
+func Serve(r io.Reader, w io.Writer) error {
+	s := &server{
+		r:    bufio.NewReader(r),
+		w:    w,
+		docs: make(map[string]*document),
+	}
+	for {
+		msg, err := readMessage(s.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+type server struct {
+	r        *bufio.Reader
+	w        io.Writer
+	docs     map[string]*document // open documents, by URI
+	shutdown bool
+}
+
+// message is a JSON-RPC request, notification or response.
+type message struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"` // nil for notifications
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  json.RawMessage  `json:"result,omitempty"`
+	Error   *rpcError        `json:"error,omitempty"`
+}
+
+// rpcError is a JSON-RPC error, returned by failing requests.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string { return e.Message }
+
+const (
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeInvalidParams  = -32602
+	codeRequestFailed  = -32803
+)
+
+func errorf(code int, format string, x ...interface{}) *rpcError {
+	return &rpcError{Code: code, Message: fmt.Sprintf(format, x...)}
+}
+
+// readMessage reads a message, preceded by its Content-Length header.
+func readMessage(r *bufio.Reader) (*message, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if v := strings.TrimPrefix(line, "Content-Length:"); v != line {
+			length, err = strconv.Atoi(strings.TrimSpace(v))
+			if err != nil {
+				return nil, fmt.Errorf("lsp: bad header: %q", line)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("lsp: missing Content-Length")
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	var msg message
+	if err := json.Unmarshal(body, &msg); err != nil {
+		return nil, fmt.Errorf("lsp: %v", err)
+	}
+	return &msg, nil
+}
+
+// writeMessage writes a message, preceded by its Content-Length header.
+func writeMessage(w io.Writer, msg *message) error {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "Content-Length: %v\r\n\r\n", len(body)); err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
+
+// notify sends a notification to the client.
+func (s *server) notify(method string, params interface{}) error {
+	p, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+	return writeMessage(s.w, &message{Method: method, Params: p})
+}
+
+// handle handles a request or notification.
+// Only errors writing to the client are returned,
+// failing requests are reported to the client.
+func (s *server) handle(msg *message) error {
+	if msg.ID == nil {
+		return s.notification(msg.Method, msg.Params)
+	}
+	resp := &message{ID: msg.ID}
+	result, err := s.request(msg.Method, msg.Params)
+	if err != nil {
+		resp.Error = err
+	} else {
+		resp.Result, _ = json.Marshal(result) // nil marshals to null
+	}
+	return writeMessage(s.w, resp)
+}
+
+func (s *server) request(method string, params json.RawMessage) (interface{}, *rpcError) {
+	if s.shutdown {
+		return nil, errorf(codeInvalidRequest, "%v: server is shut down", method)
+	}
+	switch method {
+	default:
+		return nil, errorf(codeMethodNotFound, "method not supported: %v", method)
+	case "initialize":
+		var r InitializeResult
+		r.Capabilities = ServerCapabilities{
+			TextDocumentSync:           1,
+			DefinitionProvider:         true,
+			ReferencesProvider:         true,
+			HoverProvider:              true,
+			RenameProvider:             true,
+			DocumentFormattingProvider: true,
+		}
+		r.ServerInfo.Name = "se"
+		return r, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/definition":
+		var p TextDocumentPositionParams
+		d, err := s.doc(params, &p, &p.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return d.definition(p.Position), nil
+	case "textDocument/references":
+		var p ReferenceParams
+		d, err := s.doc(params, &p, &p.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return d.references(p.Position, p.Context.IncludeDeclaration), nil
+	case "textDocument/hover":
+		var p TextDocumentPositionParams
+		d, err := s.doc(params, &p, &p.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		return d.hover(p.Position), nil
+	case "textDocument/rename":
+		var p RenameParams
+		d, err := s.doc(params, &p, &p.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		edits, e := d.rename(p.Position, p.NewName)
+		if e != nil {
+			return nil, errorf(codeRequestFailed, "%v", e)
+		}
+		return WorkspaceEdit{Changes: map[string][]TextEdit{d.uri: edits}}, nil
+	case "textDocument/formatting":
+		var p DocumentFormattingParams
+		d, err := s.doc(params, &p, &p.TextDocument)
+		if err != nil {
+			return nil, err
+		}
+		edits, e := d.format()
+		if e != nil {
+			return nil, errorf(codeRequestFailed, "%v", e)
+		}
+		return edits, nil
+	}
+}
+
+// doc decodes params into p, and returns the open document identified by id,
+// which must point into p.
+func (s *server) doc(params json.RawMessage, p interface{}, id *TextDocumentIdentifier) (*document, *rpcError) {
+	if err := json.Unmarshal(params, p); err != nil {
+		return nil, errorf(codeInvalidParams, "%v", err)
+	}
+	d, ok := s.docs[id.URI]
+	if !ok {
+		return nil, errorf(codeInvalidParams, "document not open: %v", id.URI)
+	}
+	return d, nil
+}
+
+// notification handles a notification. Unknown notifications are ignored.
+func (s *server) notification(method string, params json.RawMessage) error {
+	switch method {
+	case "textDocument/didOpen":
+		var p DidOpenTextDocumentParams
+		if json.Unmarshal(params, &p) != nil {
+			return nil
+		}
+		return s.update(p.TextDocument.URI, p.TextDocument.Text)
+	case "textDocument/didChange":
+		var p DidChangeTextDocumentParams
+		if json.Unmarshal(params, &p) != nil || len(p.ContentChanges) == 0 {
+			return nil
+		}
+		return s.update(p.TextDocument.URI, p.ContentChanges[len(p.ContentChanges)-1].Text)
+	case "textDocument/didClose":
+		var p DidCloseTextDocumentParams
+		if json.Unmarshal(params, &p) != nil {
+			return nil
+		}
+		delete(s.docs, p.TextDocument.URI)
+		return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: p.TextDocument.URI, Diagnostics: []Diagnostic{}})
+	}
+	return nil
+}
+
+// update analyzes the new text of a document, and publishes its diagnostics.
+func (s *server) update(uri, text string) error {
+	d := analyze(uri, text)
+	s.docs[uri] = d
+	return s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: d.diags})
+}
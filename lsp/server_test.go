@@ -0,0 +1,369 @@
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// client drives a server running in-process, through pipes.
+type client struct {
+	t      *testing.T
+	w      io.WriteCloser // to the server
+	r      *bufio.Reader  // from the server
+	nextID int
+	diags  map[string][]Diagnostic // last published diagnostics, by URI
+	done   chan error              // result of Serve
+}
+
+func newClient(t *testing.T) *client {
+	inR, inW := io.Pipe()
+	outR, outW := io.Pipe()
+	c := &client{t: t, w: inW, r: bufio.NewReader(outR), diags: make(map[string][]Diagnostic), done: make(chan error, 1)}
+	go func() {
+		c.done <- Serve(inR, outW)
+		outW.Close()
+	}()
+	var init InitializeResult
+	if err := c.call("initialize", map[string]interface{}{}, &init); err != nil {
+		t.Fatal(err)
+	}
+	if !init.Capabilities.RenameProvider {
+		t.Fatalf("capabilities: %+v", init.Capabilities)
+	}
+	c.notify("initialized", map[string]interface{}{})
+	return c
+}
+
+// call sends a request and decodes the result,
+// handling the notifications that are sent before the response.
+func (c *client) call(method string, params, result interface{}) *rpcError {
+	c.t.Helper()
+	c.nextID++
+	id := json.RawMessage(fmt.Sprint(c.nextID))
+	c.send(&message{ID: &id, Method: method, Params: c.marshal(params)})
+	for {
+		msg := c.recv()
+		if msg.ID == nil {
+			continue // notification, handled by recv
+		}
+		if string(*msg.ID) != string(id) {
+			c.t.Fatalf("%v: response to id %s, want %s", method, *msg.ID, id)
+		}
+		if msg.Error != nil {
+			return msg.Error
+		}
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			c.t.Fatalf("%v: %v: %s", method, err, msg.Result)
+		}
+		return nil
+	}
+}
+
+// notify sends a notification.
+// Notifications that change a document are answered with diagnostics, which are received.
+func (c *client) notify(method string, params interface{}) {
+	c.t.Helper()
+	c.send(&message{Method: method, Params: c.marshal(params)})
+	if strings.HasPrefix(method, "textDocument/did") {
+		c.recv()
+	}
+}
+
+func (c *client) open(uri, text string) {
+	c.t.Helper()
+	c.notify("textDocument/didOpen", DidOpenTextDocumentParams{TextDocument: TextDocumentItem{URI: uri, LanguageID: "se", Text: text}})
+}
+
+func (c *client) send(msg *message) {
+	c.t.Helper()
+	if err := writeMessage(c.w, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// recv receives a message, and records published diagnostics.
+func (c *client) recv() *message {
+	c.t.Helper()
+	msg, err := readMessage(c.r)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	if msg.Method == "textDocument/publishDiagnostics" {
+		var p PublishDiagnosticsParams
+		if err := json.Unmarshal(msg.Params, &p); err != nil {
+			c.t.Fatal(err)
+		}
+		c.diags[p.URI] = p.Diagnostics
+	}
+	return msg
+}
+
+func (c *client) marshal(v interface{}) json.RawMessage {
+	c.t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return b
+}
+
+// close shuts down the server, which must exit without error.
+func (c *client) close() {
+	c.t.Helper()
+	var null interface{}
+	if err := c.call("shutdown", nil, &null); err != nil {
+		c.t.Fatal(err)
+	}
+	c.send(&message{Method: "exit"})
+	if err := <-c.done; err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func at(uri string, line, char int) TextDocumentPositionParams {
+	return TextDocumentPositionParams{TextDocument: TextDocumentIdentifier{URI: uri}, Position: Position{line, char}}
+}
+
+// rng formats a range as line:char-line:char.
+func rng(r Range) string {
+	return fmt.Sprintf("%v:%v-%v:%v", r.Start.Line, r.Start.Character, r.End.Line, r.End.Character)
+}
+
+const uri = "file:///tmp/test.howl"
+
+// src is a test program. Positions in the tests are zero-based, like in the protocol.
+const src = `fib = n -> n <= 2? 1: fib(n-1) + fib(n-2);
+x = fib(10);
+f = y -> z -> x + y + z;
+type Opt = None | Some(v);
+get = o -> match o {Some(v) -> v; None -> add(x, 1)};
+f(1)(get(Some(2)))`
+
+func TestDiagnostics(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+
+	cases := []struct {
+		src  string
+		want []string
+	}{
+		{src, nil},
+		{"x = 1;\ny", []string{"1:0-1:1 error: undefined: y"}}, // top-level bindings are exported, so never unused
+		{"x = 1;\nxs", []string{"1:0-1:2 error: undefined: xs, did you mean x?"}},
+		{"f = x -> 1;\nf(2)", []string{"0:4-0:5 warning: x declared and not used"}},
+		{"x = 1;\nx = (2", []string{"1:6-1:6 error: unexpected 'EOF', expected ')'"}},
+		{"x = 1 # 2", []string{"0:6-0:7 error: unexpected: \"#\""}},
+		{"x = 1 $ 2", []string{"0:6-0:7 error: undefined operator $"}},
+	}
+	for _, cs := range cases {
+		c.notify("textDocument/didChange", map[string]interface{}{
+			"textDocument":   TextDocumentIdentifier{URI: uri},
+			"contentChanges": []map[string]string{{"text": cs.src}},
+		})
+		var have []string
+		for _, d := range c.diags[uri] {
+			kind := map[int]string{SeverityError: "error", SeverityWarning: "warning"}[d.Severity]
+			have = append(have, fmt.Sprintf("%v %v: %v", rng(d.Range), kind, d.Message))
+		}
+		if strings.Join(have, "\n") != strings.Join(cs.want, "\n") {
+			t.Errorf("%q:\nhave: %q\nwant: %q", cs.src, have, cs.want)
+		}
+	}
+
+	c.notify("textDocument/didClose", DidCloseTextDocumentParams{TextDocument: TextDocumentIdentifier{URI: uri}})
+	if d, ok := c.diags[uri]; !ok || len(d) != 0 {
+		t.Errorf("didClose: have diagnostics %v", d)
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open(uri, src)
+	if len(c.diags[uri]) != 0 {
+		t.Fatal(c.diags[uri])
+	}
+
+	cases := []struct {
+		line, char int
+		want       string
+	}{
+		{0, 22, "0:0-0:3"},   // fib(n-1)
+		{0, 25, "0:0-0:3"},   // fib|(n-1)
+		{0, 26, "0:6-0:7"},   // n
+		{2, 14, "1:0-1:1"},   // x, captured twice
+		{2, 18, "2:4-2:5"},   // y, captured
+		{4, 21, "3:18-3:22"}, // Some in pattern
+		{4, 25, "4:25-4:26"}, // v in pattern
+		{4, 31, "4:25-4:26"}, // v in body
+		{5, 11, "3:18-3:22"}, // Some in expression
+		{4, 42, ""},          // built-in add
+		{0, 13, ""},          // <=
+		{0, 20, ""},          // number
+	}
+	for _, cs := range cases {
+		var loc *Location
+		if err := c.call("textDocument/definition", at(uri, cs.line, cs.char), &loc); err != nil {
+			t.Fatal(err)
+		}
+		have := ""
+		if loc != nil {
+			have = rng(loc.Range)
+			if loc.URI != uri {
+				t.Errorf("%v:%v: uri %v", cs.line, cs.char, loc.URI)
+			}
+		}
+		if have != cs.want {
+			t.Errorf("definition %v:%v: have %q, want %q", cs.line, cs.char, have, cs.want)
+		}
+	}
+}
+
+func TestReferences(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open(uri, src)
+
+	cases := []struct {
+		line, char int
+		decl       bool
+		want       string
+	}{
+		{0, 0, true, "0:0-0:3 0:22-0:25 0:33-0:36 1:4-1:7"},
+		{0, 0, false, "0:22-0:25 0:33-0:36 1:4-1:7"},
+		{1, 0, true, "1:0-1:1 2:14-2:15 4:46-4:47"},
+		{3, 19, false, "4:20-4:24 5:9-5:13"},
+		{4, 42, true, ""}, // built-in
+	}
+	for _, cs := range cases {
+		var locs []Location
+		p := ReferenceParams{TextDocumentPositionParams: at(uri, cs.line, cs.char)}
+		p.Context.IncludeDeclaration = cs.decl
+		if err := c.call("textDocument/references", p, &locs); err != nil {
+			t.Fatal(err)
+		}
+		var have []string
+		for _, l := range locs {
+			have = append(have, rng(l.Range))
+		}
+		if strings.Join(have, " ") != cs.want {
+			t.Errorf("references %v:%v: have %q, want %q", cs.line, cs.char, have, cs.want)
+		}
+	}
+}
+
+func TestHover(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open(uri, src)
+
+	cases := []struct {
+		line, char int
+		want       string
+	}{
+		{0, 22, "```se\nfib = (n) -> …\n```\nfunction, declared at line 1, captured from an enclosing function"}, // recursion
+		{1, 4, "```se\nfib = (n) -> …\n```\nfunction, declared at line 1"},
+		{0, 26, "```se\n(n) -> …\n```\nargument n, declared at line 1"},
+		{2, 14, "```se\nx = …\n```\nvariable, declared at line 2, captured from an enclosing function"},
+		{5, 10, "```se\ntype Opt = None | Some(v)\n```\nconstructor Some, declared at line 4"},
+		{4, 31, "pattern variable v, declared at line 5"},
+		{4, 42, "built-in add"},
+		{0, 13, ""},
+	}
+	for _, cs := range cases {
+		var h *Hover
+		if err := c.call("textDocument/hover", at(uri, cs.line, cs.char), &h); err != nil {
+			t.Fatal(err)
+		}
+		have := ""
+		if h != nil {
+			have = h.Contents.Value
+		}
+		if have != cs.want {
+			t.Errorf("hover %v:%v: have %q, want %q", cs.line, cs.char, have, cs.want)
+		}
+	}
+}
+
+func TestRename(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+	c.open(uri, src)
+
+	cases := []struct {
+		line, char int
+		newName    string
+		want       string // edits, or error
+	}{
+		{0, 6, "k", "0:6-0:7=k 0:11-0:12=k 0:26-0:27=k 0:37-0:38=k"},
+		{1, 0, "total", "1:0-1:1=total 2:14-2:15=total 4:46-4:47=total"},
+		{3, 11, "Nothing", "3:11-3:15=Nothing 4:34-4:38=Nothing"},
+		{1, 0, "y", "error: renaming x to y would change the meaning of the program"},     // captured by the y-lambda
+		{2, 4, "x", "error: renaming y to x would change the meaning of the program"},     // shadows x
+		{1, 0, "fib", "error: renaming x to fib would change the meaning of the program"}, // redeclared
+		{1, 0, "add", "error: add is a built-in"},
+		{1, 0, "1x", `error: "1x" is not a valid identifier`},
+		{1, 0, "match", `error: "match" is not a valid identifier`},
+		{1, 0, "X", "error: variable X must not start with an upper case letter"},
+		{3, 11, "none", "error: constructor none must start with an upper case letter"},
+		{4, 42, "plus", "error: no variable to rename here"},
+	}
+	for _, cs := range cases {
+		var edit WorkspaceEdit
+		p := RenameParams{TextDocumentPositionParams: at(uri, cs.line, cs.char), NewName: cs.newName}
+		var have []string
+		if err := c.call("textDocument/rename", p, &edit); err != nil {
+			have = append(have, "error: "+err.Message)
+		}
+		for _, e := range edit.Changes[uri] {
+			have = append(have, rng(e.Range)+"="+e.NewText)
+		}
+		if strings.Join(have, " ") != cs.want {
+			t.Errorf("rename %v:%v to %v: have %q, want %q", cs.line, cs.char, cs.newName, have, cs.want)
+		}
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+
+	p := DocumentFormattingParams{TextDocument: TextDocumentIdentifier{URI: uri}}
+	var edits []TextEdit
+
+	c.open(uri, "x=1;\n\n\nx+ 1\n")
+	if err := c.call("textDocument/formatting", p, &edits); err != nil {
+		t.Fatal(err)
+	}
+	if len(edits) != 1 || rng(edits[0].Range) != "0:0-4:0" || edits[0].NewText != "x = 1;\n\nx + 1\n" {
+		t.Errorf("have %+v", edits)
+	}
+
+	c.open(uri, "x = 1;\nx\n")
+	if err := c.call("textDocument/formatting", p, &edits); err != nil || len(edits) != 0 {
+		t.Errorf("formatted: have %+v, %v", edits, err)
+	}
+
+	c.open(uri, "x = (1")
+	if err := c.call("textDocument/formatting", p, &edits); err == nil {
+		t.Errorf("syntax error: have %+v", edits)
+	}
+}
+
+func TestProtocolErrors(t *testing.T) {
+	c := newClient(t)
+	defer c.close()
+
+	var v interface{}
+	if err := c.call("workspace/symbol", map[string]string{}, &v); err == nil || err.Code != codeMethodNotFound {
+		t.Errorf("unknown method: have %v", err)
+	}
+	if err := c.call("textDocument/hover", at("file:///not/open.howl", 0, 0), &v); err == nil || err.Code != codeInvalidParams {
+		t.Errorf("document not open: have %v", err)
+	}
+	c.notify("$/cancelRequest", map[string]int{"id": 1}) // ignored
+}